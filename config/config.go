@@ -1,8 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -25,16 +28,165 @@ type Config struct {
 	AzureClientSecret  string
 	AzureTenantID      string
 	AzureRedirectURI   string
+	// AzureAudiences are the token audiences accepted for Azure AD login;
+	// empty means AzureClientID and api://AzureClientID.
+	AzureAudiences []string
+	// AzureRoleMap maps Azure AD app role values or group object IDs to
+	// user roles (nurse, admin or doctor). Azure users matching none of
+	// them cannot log in.
+	AzureRoleMap map[string]string
+	// AzureAuthorityURL and AzureGraphURL are the Azure AD login and
+	// Microsoft Graph base URLs, overridable to run the login flow against a
+	// local OIDC stand-in.
+	AzureAuthorityURL string
+	AzureGraphURL     string
+
+	// OIDCProviders are the staff login identity providers. The AZURE_*
+	// settings above add one named "azure"; others are listed in
+	// OIDC_PROVIDERS and configured with OIDC_<NAME>_* variables.
+	OIDCProviders []OIDCProvider
+	// AuthCookieSecret signs the short-lived cookie holding the state,
+	// nonce and PKCE verifier of an OIDC login in progress. It falls back to
+	// JWTSecret.
+	AuthCookieSecret string
+
+	// SMSTextProviders send messages we compose, including our own OTP
+	// codes. SMSOTPProviders run provider-managed OTP. Both list registry
+	// names such as "thsms", "smsmkt" or "sms2pro" in failover order; an
+	// empty list disables that role. A provider is skipped for
+	// SMSBreakerCooldown after SMSBreakerThreshold consecutive failures.
+	SMSTextProviders    []string
+	SMSOTPProviders     []string
+	SMSBreakerThreshold int
+	SMSBreakerCooldown  time.Duration
+	SMSMKTValidateURL   string
+	SMS2ProBaseURL      string
+	// SMSWebhookSecret must be sent by provider delivery report webhooks in
+	// the X-Webhook-Token header or as ?token=; the webhook is disabled while
+	// it is empty.
+	SMSWebhookSecret string
+
+	// LegacyOTPRoutesEnabled keeps /auth/request-otp and /auth/verify-otp
+	// serving. They answer with deprecation headers, plus Sunset when
+	// LegacyOTPSunset is set; once disabled they return 410 Gone.
+	LegacyOTPRoutesEnabled bool
+	LegacyOTPSunset        time.Time
+
+	// OTPMaxAttempts is how many codes may be tried against one OTP. The
+	// request limits count OTP sends per user, per phone number and per
+	// client IP; the verify limits count verification calls.
+	OTPMaxAttempts       int
+	OTPRequestLimitUser  RateLimit
+	OTPRequestLimitPhone RateLimit
+	OTPRequestLimitIP    RateLimit
+	OTPVerifyLimitUser   RateLimit
+	OTPVerifyLimitIP     RateLimit
+
+	// Request rate limits (token buckets) for the /auth routes per client IP
+	// and per phone number, and for the public doctor and slot routes per
+	// IP. Buckets live in RateLimitRedisURL when set, otherwise in memory.
+	RateLimitAuthIP    RateLimit
+	RateLimitAuthPhone RateLimit
+	RateLimitPublicIP  RateLimit
+	RateLimitRedisURL  string
+
+	// TrustedProxies are the addresses or CIDRs of reverse proxies whose
+	// X-Forwarded-For and X-Real-IP headers give the client IP. Empty trusts
+	// none, so the client IP is the connection's remote address.
+	TrustedProxies []string
+
+	// JWTSigningKeyFile is a PEM RSA (RS256) or P-256 (ES256) private key
+	// that signs access tokens. JWTVerifyKeyFiles lists further PEM keys or
+	// glob patterns that are still accepted, e.g. the previous key during a
+	// rotation. All public keys are published as the JWKS. Without a signing
+	// key, tokens are signed with HS256 and JWTSecret.
+	JWTSigningKeyFile string
+	JWTVerifyKeyFiles []string
+
+	// JWTIssuer and JWTAudience are the iss and aud claims of access
+	// tokens; tokens with other values are refused.
+	JWTIssuer   string
+	JWTAudience string
+
+	// AccessTokenTTL is the lifetime of a JWT access token. Refresh tokens
+	// last RefreshTokenTTL from their last use, and a rotated-out one is
+	// accepted for RefreshTokenGrace. AuthMiddleware caches session checks
+	// for SessionCheckCacheTTL.
+	AccessTokenTTL       time.Duration
+	RefreshTokenTTL      time.Duration
+	RefreshTokenGrace    time.Duration
+	SessionCheckCacheTTL time.Duration
+
+	// PermissionCacheTTL is how long a role's permissions are cached, so
+	// role edits made on another instance take up to this long to apply.
+	PermissionCacheTTL time.Duration
+
+	// NotificationWorkerEnabled runs the notification sender in this
+	// process. Day-before reminders are queued from NotificationReminderHour
+	// (clinic time) onwards.
+	NotificationWorkerEnabled bool
+	NotificationPollInterval  time.Duration
+	NotificationMaxAttempts   int
+	NotificationReminderHour  int
+
+	// CancellationCutoffHours is how close to the first appointment a
+	// customer may still cancel. Nurses and admins are not restricted.
+	CancellationCutoffHours int
+	// ClinicTimezone is the IANA name of the time zone that schedule dates
+	// and slot times are recorded in; ClinicLocation is its loaded form.
+	ClinicTimezone string
+	ClinicLocation *time.Location
+
+	// ScheduleHorizonDays is how many days ahead schedules are generated
+	// from templates. ScheduleAutoGenerate keeps that horizon filled daily.
+	ScheduleHorizonDays  int
+	ScheduleAutoGenerate bool
+}
+
+// OIDCProvider configures one OpenID Connect identity provider for staff
+// login. Endpoints and signing keys come from DiscoveryURL.
+//
+// ClaimMap names the token claim behind each user field (subject, email,
+// name, given_name, family_name, phone, employee_id, department, job_title);
+// a value may list fallbacks as "a|b" and use dotted paths into nested
+// claims. ProfileURL, the userinfo endpoint by default, is read with the
+// access token when the token lacks fields; ProfileSubjectClaim in its
+// response must match the token's subject. Values of RoleClaims are mapped
+// to user roles through RoleMap.
+type OIDCProvider struct {
+	Name                string
+	DiscoveryURL        string
+	ClientID            string
+	ClientSecret        string
+	RedirectURI         string
+	Scopes              []string
+	Audiences           []string
+	ExtraIssuers        []string
+	RequiredClaims      map[string]string
+	ClaimMap            map[string]string
+	RoleClaims          []string
+	RoleMap             map[string]string
+	ProfileURL          string
+	ProfileClaimMap     map[string]string
+	ProfileSubjectClaim string
+}
+
+// RateLimit allows Limit hits per Window. A zero Limit disables it.
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
 }
 
 func NewConfig() *Config {
+	clinicTimezone := getEnvOrDefault("CLINIC_TIMEZONE", "Asia/Bangkok")
+
 	allowedOriginsStr := os.Getenv("ALLOWED_ORIGINS")
 	allowedOrigins := []string{"http://localhost:3000"}
 	if allowedOriginsStr != "" {
 		allowedOrigins = strings.Split(allowedOriginsStr, ",")
 	}
 
-	return &Config{
+	cfg := &Config{
 		SupabaseURL:        os.Getenv("SUPABASE_URL"),
 		SupabaseAnonKey:    os.Getenv("SUPABASE_ANON_KEY"),
 		SupabaseServiceKey: os.Getenv("SUPABASE_SERVICE_ROLE_KEY"),
@@ -54,7 +206,145 @@ func NewConfig() *Config {
 		AzureClientSecret:  os.Getenv("AZURE_CLIENT_SECRET"),
 		AzureTenantID:      os.Getenv("AZURE_TENANT_ID"),
 		AzureRedirectURI:   os.Getenv("AZURE_REDIRECT_URI"),
+		AzureAudiences:     getEnvListOrDefault("AZURE_AUDIENCES", ""),
+		AzureRoleMap:       getEnvMap("AZURE_ROLE_MAP"),
+		AzureAuthorityURL:  strings.TrimRight(getEnvOrDefault("AZURE_AUTHORITY_URL", "https://login.microsoftonline.com"), "/"),
+		AzureGraphURL:      strings.TrimRight(getEnvOrDefault("AZURE_GRAPH_URL", "https://graph.microsoft.com/v1.0"), "/"),
+		AuthCookieSecret:   getEnvOrDefault("AUTH_COOKIE_SECRET", os.Getenv("JWT_SECRET")),
+
+		SMSTextProviders:    getEnvListOrDefault("SMS_TEXT_PROVIDERS", defaultIfSet(os.Getenv("THSMS_API_TOKEN"), "thsms")),
+		SMSOTPProviders:     getEnvListOrDefault("SMS_OTP_PROVIDERS", defaultIfSet(os.Getenv("SMSMKT_API_KEY"), "smsmkt")),
+		SMSBreakerThreshold: getEnvIntOrDefault("SMS_BREAKER_THRESHOLD", 3),
+		SMSBreakerCooldown:  time.Duration(getEnvIntOrDefault("SMS_BREAKER_COOLDOWN_SECONDS", 60)) * time.Second,
+		SMSMKTValidateURL:   getEnvOrDefault("SMSMKT_VALIDATE_URL", "https://portal-otp.smsmkt.com/api/otp-validate"),
+		SMS2ProBaseURL:      getEnvOrDefault("SMS2PRO_BASE_URL", "https://portal.sms2pro.com"),
+		SMSWebhookSecret:    os.Getenv("SMS_WEBHOOK_SECRET"),
+
+		LegacyOTPRoutesEnabled: getEnvOrDefault("LEGACY_OTP_ROUTES_ENABLED", "true") == "true",
+		LegacyOTPSunset:        getEnvDate("LEGACY_OTP_SUNSET"),
+
+		OTPMaxAttempts:       getEnvIntOrDefault("OTP_MAX_ATTEMPTS", 3),
+		OTPRequestLimitUser:  getEnvRateLimit("OTP_REQUEST_LIMIT_USER", "3/1h"),
+		OTPRequestLimitPhone: getEnvRateLimit("OTP_REQUEST_LIMIT_PHONE", "5/1h"),
+		OTPRequestLimitIP:    getEnvRateLimit("OTP_REQUEST_LIMIT_IP", "20/1h"),
+		OTPVerifyLimitUser:   getEnvRateLimit("OTP_VERIFY_LIMIT_USER", "5/1m"),
+		OTPVerifyLimitIP:     getEnvRateLimit("OTP_VERIFY_LIMIT_IP", "30/1m"),
+
+		RateLimitAuthIP:    getEnvRateLimit("RATE_LIMIT_AUTH_IP", "30/1m"),
+		RateLimitAuthPhone: getEnvRateLimit("RATE_LIMIT_AUTH_PHONE", "10/10m"),
+		RateLimitPublicIP:  getEnvRateLimit("RATE_LIMIT_PUBLIC_IP", "120/1m"),
+		RateLimitRedisURL:  os.Getenv("RATE_LIMIT_REDIS_URL"),
+		TrustedProxies:     getEnvListOrDefault("TRUSTED_PROXIES", ""),
+
+		JWTSigningKeyFile: os.Getenv("JWT_SIGNING_KEY_FILE"),
+		JWTVerifyKeyFiles: getEnvListOrDefault("JWT_VERIFY_KEY_FILES", ""),
+		JWTIssuer:         getEnvOrDefault("JWT_ISSUER", "backend-appointment"),
+		JWTAudience:       getEnvOrDefault("JWT_AUDIENCE", "backend-appointment"),
+
+		AccessTokenTTL:       time.Duration(getEnvIntOrDefault("ACCESS_TOKEN_TTL_MINUTES", 15)) * time.Minute,
+		RefreshTokenTTL:      time.Duration(getEnvIntOrDefault("REFRESH_TOKEN_TTL_HOURS", 720)) * time.Hour,
+		RefreshTokenGrace:    time.Duration(getEnvIntOrDefault("REFRESH_TOKEN_GRACE_SECONDS", 10)) * time.Second,
+		SessionCheckCacheTTL: time.Duration(getEnvIntOrDefault("SESSION_CHECK_CACHE_SECONDS", 30)) * time.Second,
+		PermissionCacheTTL:   time.Duration(getEnvIntOrDefault("PERMISSION_CACHE_SECONDS", 30)) * time.Second,
+
+		NotificationWorkerEnabled: getEnvOrDefault("NOTIFICATION_WORKER_ENABLED", "true") == "true",
+		NotificationPollInterval:  time.Duration(getEnvIntOrDefault("NOTIFICATION_POLL_SECONDS", 30)) * time.Second,
+		NotificationMaxAttempts:   getEnvIntOrDefault("NOTIFICATION_MAX_ATTEMPTS", 5),
+		NotificationReminderHour:  getEnvIntOrDefault("NOTIFICATION_REMINDER_HOUR", 9),
+
+		CancellationCutoffHours: getEnvIntOrDefault("CANCELLATION_CUTOFF_HOURS", 24),
+		ClinicTimezone:          clinicTimezone,
+		ClinicLocation:          loadLocation(clinicTimezone),
+
+		ScheduleHorizonDays:  getEnvIntOrDefault("SCHEDULE_HORIZON_DAYS", 28),
+		ScheduleAutoGenerate: os.Getenv("SCHEDULE_AUTO_GENERATE") == "true",
 	}
+	cfg.OIDCProviders = loadOIDCProviders(cfg)
+	return cfg
+}
+
+// loadOIDCProviders builds the "azure" provider from the AZURE_* settings,
+// when they are set, followed by every provider named in OIDC_PROVIDERS.
+func loadOIDCProviders(cfg *Config) []OIDCProvider {
+	var providers []OIDCProvider
+	if cfg.AzureTenantID != "" && cfg.AzureClientID != "" {
+		audiences := cfg.AzureAudiences
+		if len(audiences) == 0 {
+			audiences = []string{cfg.AzureClientID, "api://" + cfg.AzureClientID}
+		}
+		providers = append(providers, OIDCProvider{
+			Name:         "azure",
+			DiscoveryURL: fmt.Sprintf("%s/%s/v2.0/.well-known/openid-configuration", cfg.AzureAuthorityURL, cfg.AzureTenantID),
+			ClientID:     cfg.AzureClientID,
+			ClientSecret: cfg.AzureClientSecret,
+			RedirectURI:  cfg.AzureRedirectURI,
+			Scopes:       []string{"openid", "profile", "email", "offline_access", "User.Read"},
+			Audiences:    audiences,
+			// v1.0 access tokens for this app carry the older issuer.
+			ExtraIssuers:   []string{fmt.Sprintf("https://sts.windows.net/%s/", cfg.AzureTenantID)},
+			RequiredClaims: map[string]string{"tid": cfg.AzureTenantID},
+			ClaimMap: map[string]string{
+				"subject":     "oid",
+				"email":       "email|preferred_username|upn|unique_name",
+				"name":        "name",
+				"given_name":  "given_name",
+				"family_name": "family_name",
+			},
+			RoleClaims: []string{"roles", "groups"},
+			RoleMap:    cfg.AzureRoleMap,
+			ProfileURL: cfg.AzureGraphURL + "/me?$select=id,displayName,jobTitle,mail,userPrincipalName,mobilePhone,employeeId,department,givenName,surname",
+			ProfileClaimMap: map[string]string{
+				"email":       "mail|userPrincipalName",
+				"name":        "displayName",
+				"given_name":  "givenName",
+				"family_name": "surname",
+				"phone":       "mobilePhone",
+				"employee_id": "employeeId",
+				"department":  "department",
+				"job_title":   "jobTitle",
+			},
+			ProfileSubjectClaim: "id",
+		})
+	}
+
+	for _, name := range getEnvListOrDefault("OIDC_PROVIDERS", "") {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name)) + "_"
+		claimMap := map[string]string{
+			"subject":     "sub",
+			"email":       "email",
+			"name":        "name",
+			"given_name":  "given_name",
+			"family_name": "family_name",
+			"phone":       "phone_number",
+		}
+		for field, claim := range getEnvMap(prefix + "CLAIM_MAP") {
+			claimMap[field] = claim
+		}
+		profileClaimMap := claimMap
+		if m := getEnvMap(prefix + "PROFILE_CLAIM_MAP"); len(m) > 0 {
+			profileClaimMap = m
+		}
+
+		providers = append(providers, OIDCProvider{
+			Name:                name,
+			DiscoveryURL:        os.Getenv(prefix + "DISCOVERY_URL"),
+			ClientID:            os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret:        os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURI:         os.Getenv(prefix + "REDIRECT_URI"),
+			Scopes:              getEnvListOrDefault(prefix+"SCOPES", "openid,profile,email"),
+			Audiences:           getEnvListOrDefault(prefix+"AUDIENCES", os.Getenv(prefix+"CLIENT_ID")),
+			ExtraIssuers:        getEnvListOrDefault(prefix+"EXTRA_ISSUERS", ""),
+			RequiredClaims:      getEnvMap(prefix + "REQUIRED_CLAIMS"),
+			ClaimMap:            claimMap,
+			RoleClaims:          getEnvListOrDefault(prefix+"ROLE_CLAIMS", "roles,groups"),
+			RoleMap:             getEnvMap(prefix + "ROLE_MAP"),
+			ProfileURL:          os.Getenv(prefix + "PROFILE_URL"),
+			ProfileClaimMap:     profileClaimMap,
+			ProfileSubjectClaim: getEnvOrDefault(prefix+"PROFILE_SUBJECT_CLAIM", "sub"),
+		})
+	}
+	return providers
 }
 
 func getEnvOrDefault(key, defaultValue string) string {
@@ -63,3 +353,93 @@ func getEnvOrDefault(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// defaultIfSet returns value when the credential it depends on is present.
+func defaultIfSet(credential, value string) string {
+	if credential != "" {
+		return value
+	}
+	return ""
+}
+
+// getEnvListOrDefault splits a comma separated variable, dropping blanks.
+func getEnvListOrDefault(key, defaultValue string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnvOrDefault(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvMap parses a comma separated list of "<key>:<value>" pairs. The
+// value follows the last colon, so keys may contain colons.
+func getEnvMap(key string) map[string]string {
+	m := map[string]string{}
+	for _, item := range getEnvListOrDefault(key, "") {
+		i := strings.LastIndex(item, ":")
+		if i <= 0 || i == len(item)-1 {
+			continue
+		}
+		m[strings.TrimSpace(item[:i])] = strings.TrimSpace(item[i+1:])
+	}
+	return m
+}
+
+func getEnvIntOrDefault(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+// getEnvRateLimit parses "<limit>/<window>", e.g. "3/1h" or "30/90s", using
+// Go duration syntax for the window. "0" or "off" disables the limit. A
+// malformed value falls back to defaultValue.
+func getEnvRateLimit(key, defaultValue string) RateLimit {
+	parse := func(value string) (RateLimit, bool) {
+		if value == "0" || value == "off" {
+			return RateLimit{}, true
+		}
+		limitStr, windowStr, ok := strings.Cut(value, "/")
+		if !ok {
+			return RateLimit{}, false
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(limitStr))
+		if err != nil || limit < 0 {
+			return RateLimit{}, false
+		}
+		window, err := time.ParseDuration(strings.TrimSpace(windowStr))
+		if err != nil || window < time.Second {
+			return RateLimit{}, false
+		}
+		return RateLimit{Limit: limit, Window: window}, true
+	}
+
+	if limit, ok := parse(os.Getenv(key)); ok && os.Getenv(key) != "" {
+		return limit
+	}
+	limit, _ := parse(defaultValue)
+	return limit
+}
+
+// getEnvDate parses a YYYY-MM-DD variable, returning the zero time when it
+// is unset or malformed.
+func getEnvDate(key string) time.Time {
+	date, err := time.Parse("2006-01-02", os.Getenv(key))
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
+
+// loadLocation falls back to UTC+7 when the host has no time zone database.
+func loadLocation(name string) *time.Location {
+	if loc, err := time.LoadLocation(name); err == nil {
+		return loc
+	}
+	return time.FixedZone("ICT", 7*60*60)
+}
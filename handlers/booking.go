@@ -2,24 +2,31 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sittawut/backend-appointment/config"
+	"github.com/sittawut/backend-appointment/middleware"
 	"github.com/sittawut/backend-appointment/models"
+	"github.com/sittawut/backend-appointment/services"
 	supa "github.com/supabase-community/supabase-go"
 )
 
 type BookingHandler struct {
 	supabase *supa.Client
 	config   *config.Config
+	notifier *services.Notifier
 }
 
-func NewBookingHandler(supabase *supa.Client, cfg *config.Config) *BookingHandler {
+func NewBookingHandler(supabase *supa.Client, cfg *config.Config, notifier *services.Notifier) *BookingHandler {
 	return &BookingHandler{
 		supabase: supabase,
 		config:   cfg,
+		notifier: notifier,
 	}
 }
 
@@ -30,6 +37,7 @@ func (h *BookingHandler) GetMyBookings(c *gin.Context) {
 	query := h.supabase.From("bookings").
 		Select("*", "", false).
 		Eq("customer_id", userID.(string)).
+		Is("deleted_at", "null").
 		Order("appointment_date", nil)
 
 	if status != "" {
@@ -106,17 +114,24 @@ func (h *BookingHandler) GetMyBookings(c *gin.Context) {
 func (h *BookingHandler) GetBookingByID(c *gin.Context) {
 	bookingID := c.Param("id")
 	userID, _ := c.Get("user_id")
-	role, _ := c.Get("role")
 
 	query := h.supabase.From("bookings").
 		Select("*", "", false).
 		Eq("id", bookingID)
 
-	// If customer, only show their own bookings
-	roleStr, ok := role.(string)
-	userIDStr, ok2 := userID.(string)
-	if ok && ok2 && roleStr == "customer" {
-		query = query.Eq("customer_id", userIDStr)
+	// Without booking:read:any, only show their own bookings; with it, only
+	// bookings at the caller's branches
+	userIDStr, _ := userID.(string)
+	if !middleware.HasPermission(c, models.PermBookingReadAny) {
+		query = query.Eq("customer_id", userIDStr).Is("deleted_at", "null")
+	} else {
+		branchIDs, ok := branchScope(c, "")
+		if !ok {
+			return
+		}
+		if branchIDs != nil {
+			query = query.In("branch_id", branchIDs)
+		}
 	}
 
 	var bookings []models.Booking
@@ -226,103 +241,57 @@ func (h *BookingHandler) CreateBooking(c *gin.Context) {
 		req.CustomerID = userIDStr
 	}
 
-	// Verify customer_id matches authenticated user (unless nurse/admin)
-	role, _ := c.Get("role")
-	roleStr, ok := role.(string)
-	userIDStr, ok2 := userID.(string)
-	if ok && ok2 && roleStr == "customer" && req.CustomerID != userIDStr {
-		c.JSON(http.StatusForbidden, models.Response{
-			Success: false,
-			Error:   "Cannot create booking for another user",
-		})
-		return
-	}
-
-	// Create booking
-	bookingData := map[string]interface{}{
-		"customer_id":      req.CustomerID,
-		"appointment_date": req.AppointmentDate,
-		"status":           "pending",
-		"notes":            req.Notes,
-		"created_by":       userIDStr,
-		"updated_by":       userIDStr,
+	// Verify customer_id matches authenticated user (unless booking:write:any,
+	// which only books at the caller's branches)
+	userIDStr, _ := userID.(string)
+	var branchIDs []string
+	if req.CustomerID != userIDStr {
+		if !middleware.HasPermission(c, models.PermBookingWriteAny) {
+			c.JSON(http.StatusForbidden, models.Response{
+				Success: false,
+				Error:   "Cannot create booking for another user",
+			})
+			return
+		}
+		var ok bool
+		if branchIDs, ok = branchScope(c, ""); !ok {
+			return
+		}
 	}
 
+	status := models.StatusPending
 	if req.Status != "" {
-		bookingData["status"] = req.Status
+		status = req.Status
 	}
-
-	fmt.Printf("[CreateBooking] Booking data to insert: %+v\n", bookingData)
-
-	var createdBookings []models.Booking
-	data, _, err := h.supabase.From("bookings").
-		Insert(bookingData, false, "", "", "").
-		Execute()
-
-	fmt.Printf("[CreateBooking] Response data: %s, Error: %v\n", string(data), err)
-
-	if err != nil {
-		fmt.Printf("[CreateBooking] Insert error: %v\n", err)
-		c.JSON(http.StatusInternalServerError, models.Response{
+	if !models.IsInitialStatus(status, middleware.PermissionCheck(c)) {
+		c.JSON(http.StatusUnprocessableEntity, models.Response{
 			Success: false,
-			Error:   fmt.Sprintf("Failed to create booking: %v", err),
+			Error:   fmt.Sprintf("Cannot create a booking with status %s", status),
 		})
 		return
 	}
 
-	if err := json.Unmarshal(data, &createdBookings); err != nil {
-		fmt.Printf("[CreateBooking] Unmarshal error: %v\n", err)
-		c.JSON(http.StatusInternalServerError, models.Response{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to parse booking response: %v", err),
-		})
-		return
-	}
-
-	if len(createdBookings) == 0 {
-		fmt.Printf("[CreateBooking] No bookings returned from insert\n")
+	booking, err := createBooking(h.supabase, createBookingParams{
+		CustomerID:      req.CustomerID,
+		AppointmentDate: req.AppointmentDate,
+		Status:          status,
+		Notes:           req.Notes,
+		CreatedBy:       userIDStr,
+		Appointments:    req.Appointments,
+		BranchIDs:       branchIDs,
+	})
+	if err != nil {
+		fmt.Printf("[CreateBooking] Create error: %v\n", err)
+		if respondSlotConflict(c, err) || respondBookingError(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, models.Response{
 			Success: false,
-			Error:   "No booking created",
+			Error:   "Failed to create booking",
 		})
 		return
 	}
-
-	booking := createdBookings[0]
-
-	// Create appointments
-	for i, apt := range req.Appointments {
-		fmt.Printf("[CreateBooking] Creating appointment %d: TimeSlotID=%s, DoctorID=%s, ServiceType=%s\n",
-			i, apt.TimeSlotID, apt.DoctorID, apt.ServiceType)
-
-		appointmentData := map[string]interface{}{
-			"booking_id":   booking.ID,
-			"time_slot_id": apt.TimeSlotID,
-			"doctor_id":    apt.DoctorID,
-			"service_type": apt.ServiceType,
-			"location":     apt.Location,
-			"status":       "pending",
-		}
-
-		var createdAppointments []models.Appointment
-		aptData, _, err := h.supabase.From("appointments").
-			Insert(appointmentData, false, "", "", "").
-			Execute()
-
-		fmt.Printf("[CreateBooking] Appointment %d response: %s, Error: %v\n", i, string(aptData), err)
-
-		if err != nil {
-			fmt.Printf("[CreateBooking] Appointment creation failed, rolling back booking\n")
-			// Rollback: delete booking if appointment creation fails
-			h.supabase.From("bookings").Delete("", "").Eq("id", booking.ID).Execute()
-			c.JSON(http.StatusInternalServerError, models.Response{
-				Success: false,
-				Error:   fmt.Sprintf("Failed to create appointments: %v", err),
-			})
-			return
-		}
-		json.Unmarshal(aptData, &createdAppointments)
-	}
+	notifyBookingStatus(h.notifier, booking, "")
 
 	c.JSON(http.StatusCreated, models.Response{
 		Success: true,
@@ -334,7 +303,6 @@ func (h *BookingHandler) CreateBooking(c *gin.Context) {
 func (h *BookingHandler) UpdateBooking(c *gin.Context) {
 	bookingID := c.Param("id")
 	userID, _ := c.Get("user_id")
-	role, _ := c.Get("role")
 
 	var req models.UpdateBookingRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -345,17 +313,6 @@ func (h *BookingHandler) UpdateBooking(c *gin.Context) {
 		return
 	}
 
-	// Build update data
-	updateData := make(map[string]interface{})
-	if req.AppointmentDate != nil {
-		updateData["appointment_date"] = *req.AppointmentDate
-	}
-	if req.Status != nil {
-		updateData["status"] = *req.Status
-	}
-	if req.Notes != nil {
-		updateData["notes"] = *req.Notes
-	}
 	userIDStr, ok := userID.(string)
 	if !ok {
 		c.JSON(http.StatusInternalServerError, models.Response{
@@ -364,69 +321,403 @@ func (h *BookingHandler) UpdateBooking(c *gin.Context) {
 		})
 		return
 	}
-	updateData["updated_by"] = userIDStr
 
+	// Without booking:write:any, only update their own bookings; with it,
+	// only bookings at the caller's branches
 	query := h.supabase.From("bookings").
-		Update(updateData, "", "").
-		Eq("id", bookingID)
-
-	// If customer, only update their own bookings
-	roleStr, okRole := role.(string)
-	userIDStr2, okUserID := userID.(string)
-	if okRole && okUserID && roleStr == "customer" {
-		query = query.Eq("customer_id", userIDStr2)
+		Select("*", "", false).
+		Eq("id", bookingID).
+		Is("deleted_at", "null")
+	if !middleware.HasPermission(c, models.PermBookingWriteAny) {
+		query = query.Eq("customer_id", userIDStr)
+	} else {
+		branchIDs, ok := branchScope(c, "")
+		if !ok {
+			return
+		}
+		if branchIDs != nil {
+			query = query.In("branch_id", branchIDs)
+		}
 	}
 
-	var updatedBookings []models.Booking
+	var bookings []models.Booking
 	data, _, err := query.Execute()
 	if err == nil {
-		err = json.Unmarshal(data, &updatedBookings)
+		err = json.Unmarshal(data, &bookings)
 	}
-
-	if err != nil || len(updatedBookings) == 0 {
+	if err != nil || len(bookings) == 0 {
 		c.JSON(http.StatusNotFound, models.Response{
 			Success: false,
-			Error:   "Booking not found or update failed",
+			Error:   "Booking not found",
 		})
 		return
 	}
+	booking := &bookings[0]
+
+	// Status changes go through the lifecycle rules
+	if req.Status != nil && *req.Status != booking.Status {
+		if !models.CanTransition(booking.Status, *req.Status, middleware.PermissionCheck(c)) {
+			respondIllegalTransition(c, booking.Status, *req.Status)
+			return
+		}
+
+		if *req.Status == models.StatusCancelled {
+			if !h.authorizeCancel(c, bookingID, "") {
+				return
+			}
+			booking, err = cancelBooking(h.supabase, bookingID, userIDStr, "")
+		} else {
+			booking, err = setBookingStatus(h.supabase, bookingID, bookings[0].Status, *req.Status, userIDStr)
+		}
+		if err != nil {
+			fmt.Printf("[UpdateBooking] Status change error: %v\n", err)
+			if respondBookingError(c, err) {
+				return
+			}
+			c.JSON(http.StatusInternalServerError, models.Response{
+				Success: false,
+				Error:   "Failed to update booking status",
+			})
+			return
+		}
+		notifyBookingStatus(h.notifier, booking, "")
+	}
+
+	// Build update data
+	updateData := make(map[string]interface{})
+	if req.AppointmentDate != nil {
+		updateData["appointment_date"] = *req.AppointmentDate
+	}
+	if req.Notes != nil {
+		updateData["notes"] = *req.Notes
+	}
+
+	if len(updateData) > 0 {
+		updateData["updated_by"] = userIDStr
+
+		var updatedBookings []models.Booking
+		data, _, err := h.supabase.From("bookings").
+			Update(updateData, "", "").
+			Eq("id", bookingID).
+			Execute()
+		if err == nil {
+			err = json.Unmarshal(data, &updatedBookings)
+		}
+		if err != nil || len(updatedBookings) == 0 {
+			c.JSON(http.StatusInternalServerError, models.Response{
+				Success: false,
+				Error:   "Failed to update booking",
+			})
+			return
+		}
+		booking = &updatedBookings[0]
+	}
 
 	c.JSON(http.StatusOK, models.Response{
 		Success: true,
 		Message: "Booking updated successfully",
-		Data:    updatedBookings[0],
+		Data:    booking,
 	})
 }
 
+func (h *BookingHandler) UpdateAppointmentStatus(c *gin.Context) {
+	bookingID := c.Param("id")
+	appointmentID := c.Param("appointment_id")
+	userID, _ := c.Get("user_id")
+	userIDStr, _ := userID.(string)
+
+	var req models.UpdateStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Success: false, Error: "Invalid request body"})
+		return
+	}
+
+	if allowed, _ := h.bookingAccess(c, models.PermBookingWriteAny, models.PermBookingWriteOwn, bookingID); !allowed {
+		c.JSON(http.StatusNotFound, models.Response{Success: false, Error: "Booking not found"})
+		return
+	}
+
+	var appointments []models.Appointment
+	data, _, err := h.supabase.From("appointments").
+		Select("*", "", false).
+		Eq("id", appointmentID).
+		Eq("booking_id", bookingID).
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &appointments)
+	}
+	if err != nil || len(appointments) == 0 {
+		c.JSON(http.StatusNotFound, models.Response{Success: false, Error: "Appointment not found"})
+		return
+	}
+	appointment := &appointments[0]
+
+	if req.Status != appointment.Status {
+		if !models.CanTransition(appointment.Status, req.Status, middleware.PermissionCheck(c)) {
+			respondIllegalTransition(c, appointment.Status, req.Status)
+			return
+		}
+
+		if req.Status == models.StatusCancelled {
+			if !h.authorizeCancel(c, bookingID, appointmentID) {
+				return
+			}
+			appointment, err = cancelAppointment(h.supabase, appointmentID, userIDStr, req.Reason)
+		} else {
+			appointment, err = setAppointmentStatus(h.supabase, appointmentID, appointments[0].Status, req.Status, userIDStr)
+		}
+		if err != nil {
+			fmt.Printf("[UpdateAppointmentStatus] Status change error: %v\n", err)
+			if respondBookingError(c, err) {
+				return
+			}
+			c.JSON(http.StatusInternalServerError, models.Response{Success: false, Error: "Failed to update appointment status"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, models.Response{Success: true, Message: "Appointment updated successfully", Data: appointment})
+}
+
 func (h *BookingHandler) CancelBooking(c *gin.Context) {
 	bookingID := c.Param("id")
 	userID, _ := c.Get("user_id")
-	role, _ := c.Get("role")
+	userIDStr, _ := userID.(string)
 
-	// If customer, ensure booking belongs to them
-	if role.(string) == "customer" {
-		// Check ownership
-		var rows []map[string]interface{}
-		data, _, err := h.supabase.From("bookings").
-			Select("id", "", false).
-			Eq("id", bookingID).
-			Eq("customer_id", userID.(string)).
-			Execute()
-		if err != nil || json.Unmarshal(data, &rows) != nil || len(rows) == 0 {
-			c.JSON(http.StatusForbidden, models.Response{Success: false, Error: "Not allowed"})
+	var req models.CancelBookingRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, models.Response{Success: false, Error: "Invalid request body"})
+		return
+	}
+
+	if !h.authorizeCancel(c, bookingID, "") {
+		return
+	}
+
+	var bookings []models.Booking
+	data, _, err := h.supabase.From("bookings").
+		Select("id, status", "", false).
+		Eq("id", bookingID).
+		Execute()
+	if err != nil || json.Unmarshal(data, &bookings) != nil || len(bookings) == 0 {
+		c.JSON(http.StatusNotFound, models.Response{Success: false, Error: "Booking not found"})
+		return
+	}
+	if !allowCancel(c, bookings[0].Status) {
+		return
+	}
+
+	booking, err := cancelBooking(h.supabase, bookingID, userIDStr, req.Reason)
+	if err != nil {
+		fmt.Printf("[CancelBooking] Cancel error: %v\n", err)
+		if respondBookingError(c, err) {
 			return
 		}
+		c.JSON(http.StatusInternalServerError, models.Response{Success: false, Error: "Failed to cancel booking"})
+		return
+	}
+	notifyBookingStatus(h.notifier, booking, req.Reason)
+
+	c.JSON(http.StatusOK, models.Response{Success: true, Message: "Booking cancelled successfully", Data: booking})
+}
+
+func (h *BookingHandler) CancelAppointment(c *gin.Context) {
+	bookingID := c.Param("id")
+	appointmentID := c.Param("appointment_id")
+	userID, _ := c.Get("user_id")
+	userIDStr, _ := userID.(string)
+
+	var req models.CancelBookingRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, models.Response{Success: false, Error: "Invalid request body"})
+		return
+	}
+
+	// Make sure the appointment is part of the booking in the URL
+	var appointments []models.Appointment
+	data, _, err := h.supabase.From("appointments").
+		Select("id, status", "", false).
+		Eq("id", appointmentID).
+		Eq("booking_id", bookingID).
+		Execute()
+	if err != nil || json.Unmarshal(data, &appointments) != nil || len(appointments) == 0 {
+		c.JSON(http.StatusNotFound, models.Response{Success: false, Error: "Appointment not found"})
+		return
 	}
 
-	// Delete child appointments first
-	_, _, _ = h.supabase.From("appointments").Delete("", "").Eq("booking_id", bookingID).Execute()
+	if !h.authorizeCancel(c, bookingID, appointmentID) {
+		return
+	}
+	if !allowCancel(c, appointments[0].Status) {
+		return
+	}
 
-	// Delete booking
-	delResp, _, err := h.supabase.From("bookings").Delete("", "").Eq("id", bookingID).Execute()
+	appointment, err := cancelAppointment(h.supabase, appointmentID, userIDStr, req.Reason)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.Response{Success: false, Error: "Failed to delete booking"})
+		fmt.Printf("[CancelAppointment] Cancel error: %v\n", err)
+		if respondBookingError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.Response{Success: false, Error: "Failed to cancel appointment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Success: true, Message: "Appointment cancelled successfully", Data: appointment})
+}
+
+// allowCancel checks a cancellation against the status machine and writes a
+// 422 when it is not allowed. Rows that are already cancelled pass, so the
+// cancel functions can report them as such.
+func allowCancel(c *gin.Context, status string) bool {
+	if status == models.StatusCancelled || models.CanTransition(status, models.StatusCancelled, middleware.PermissionCheck(c)) {
+		return true
+	}
+	respondIllegalTransition(c, status, models.StatusCancelled)
+	return false
+}
+
+// notifyBookingStatus queues the customer notification for a booking that
+// has just been confirmed or cancelled. It runs in the background so the
+// response does not wait for the queue insert.
+func notifyBookingStatus(notifier *services.Notifier, booking *models.Booking, reason string) {
+	if notifier == nil || booking == nil {
 		return
 	}
 
-	c.JSON(http.StatusOK, models.Response{Success: true, Message: "Booking cancelled successfully", Data: string(delResp)})
+	var event string
+	switch booking.Status {
+	case models.StatusConfirmed:
+		event = models.NotificationBookingConfirmed
+	case models.StatusCancelled:
+		event = models.NotificationBookingCancelled
+	default:
+		return
+	}
+	go notifier.NotifyBooking(booking.ID, event, reason)
+}
+
+// authorizeCancel lets callers with booking:cancel:any cancel anything at
+// their branches. Others may only cancel their own bookings, and not too
+// close to the appointment. It writes the error response itself when it
+// returns false.
+func (h *BookingHandler) authorizeCancel(c *gin.Context, bookingID, appointmentID string) bool {
+	allowed, asStaff := h.bookingAccess(c, models.PermBookingCancelAny, models.PermBookingCancelOwn, bookingID)
+	if !allowed {
+		c.JSON(http.StatusForbidden, models.Response{Success: false, Error: "Not allowed"})
+		return false
+	}
+	if asStaff {
+		return true
+	}
+	return h.enforceCancellationCutoff(c, bookingID, appointmentID)
+}
+
+// bookingAccess is middleware.CanAccessOwned for bookings, where
+// anyPermission only reaches bookings at the caller's branches. asStaff
+// reports whether access comes from anyPermission rather than ownership.
+func (h *BookingHandler) bookingAccess(c *gin.Context, anyPermission, ownPermission, bookingID string) (allowed, asStaff bool) {
+	if middleware.HasPermission(c, anyPermission) && bookingInBranchScope(c, h.supabase, bookingID) {
+		return true, true
+	}
+	userID := c.GetString("user_id")
+	return middleware.HasPermission(c, ownPermission) && userID != "" && h.ownsBooking(bookingID, userID), false
+}
+
+// bookingInBranchScope reports whether the booking is at one of the caller's
+// branches.
+func bookingInBranchScope(c *gin.Context, client *supa.Client, bookingID string) bool {
+	branchIDs, all := middleware.BranchScope(c)
+	if all {
+		return true
+	}
+	if len(branchIDs) == 0 {
+		return false
+	}
+
+	var rows []map[string]interface{}
+	data, _, err := client.From("bookings").
+		Select("id", "", false).
+		Eq("id", bookingID).
+		In("branch_id", branchIDs).
+		Execute()
+	return err == nil && json.Unmarshal(data, &rows) == nil && len(rows) > 0
+}
+
+// ownsBooking reports whether the booking belongs to the given customer and
+// has not been deleted by staff.
+func (h *BookingHandler) ownsBooking(bookingID, customerID string) bool {
+	var rows []map[string]interface{}
+	data, _, err := h.supabase.From("bookings").
+		Select("id", "", false).
+		Eq("id", bookingID).
+		Eq("customer_id", customerID).
+		Is("deleted_at", "null").
+		Execute()
+	return err == nil && json.Unmarshal(data, &rows) == nil && len(rows) > 0
+}
+
+// appointmentSlotRow is an appointment with the date and start time of its slot.
+type appointmentSlotRow struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	TimeSlot struct {
+		StartTime string `json:"start_time"`
+		Schedule  struct {
+			ScheduleDate string `json:"schedule_date"`
+		} `json:"schedule"`
+	} `json:"time_slot"`
+}
+
+// enforceCancellationCutoff rejects the request when the earliest active
+// appointment of the booking (or only appointmentID, when given) starts within
+// the configured cutoff. It reports whether the cancellation may proceed.
+func (h *BookingHandler) enforceCancellationCutoff(c *gin.Context, bookingID, appointmentID string) bool {
+	query := h.supabase.From("appointments").
+		Select("id, status, time_slot:time_slots(start_time, schedule:doctor_schedules(schedule_date))", "", false).
+		Eq("booking_id", bookingID).
+		Neq("status", "cancelled")
+	if appointmentID != "" {
+		query = query.Eq("id", appointmentID)
+	}
+
+	var rows []appointmentSlotRow
+	data, _, err := query.Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &rows)
+	}
+	if err != nil {
+		fmt.Printf("[CancelBooking] Cutoff lookup error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{Success: false, Error: "Failed to check cancellation window"})
+		return false
+	}
+
+	cutoff := time.Duration(h.config.CancellationCutoffHours) * time.Hour
+	deadline := time.Now().Add(cutoff)
+	for _, row := range rows {
+		start, err := slotStartTime(row.TimeSlot.Schedule.ScheduleDate, row.TimeSlot.StartTime, h.config.ClinicLocation)
+		if err != nil {
+			// Refuse rather than let an unreadable slot slip past the cutoff
+			fmt.Printf("[CancelBooking] Cutoff time parse error for appointment %s: %v\n", row.ID, err)
+			c.JSON(http.StatusInternalServerError, models.Response{Success: false, Error: "Failed to check cancellation window"})
+			return false
+		}
+		if start.Before(deadline) {
+			c.JSON(http.StatusForbidden, models.Response{
+				Success: false,
+				Error:   fmt.Sprintf("Bookings cannot be cancelled within %d hours of the appointment", h.config.CancellationCutoffHours),
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// slotStartTime combines a schedule date and a slot start time in the clinic's
+// time zone. Slot times may be stored with or without seconds.
+func slotStartTime(date, startTime string, loc *time.Location) (time.Time, error) {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", date+" "+startTime, loc)
+	if err != nil {
+		t, err = time.ParseInLocation("2006-01-02 15:04", date+" "+startTime, loc)
+	}
+	return t, err
 }
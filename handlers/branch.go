@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sittawut/backend-appointment/middleware"
+	"github.com/sittawut/backend-appointment/models"
+	"github.com/supabase-community/postgrest-go"
+	supa "github.com/supabase-community/supabase-go"
+)
+
+// branchCodePattern matches the codes the branches table accepts.
+var branchCodePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,29}$`)
+
+// BranchHandler lists clinic branches and lets admins edit them.
+type BranchHandler struct {
+	supabase *supa.Client
+}
+
+func NewBranchHandler(supabase *supa.Client) *BranchHandler {
+	return &BranchHandler{
+		supabase: supabase,
+	}
+}
+
+// GetBranches lists the active branches, for customers choosing where to
+// book.
+func (h *BranchHandler) GetBranches(c *gin.Context) {
+	var branches []models.Branch
+	data, _, err := h.supabase.From("branches").
+		Select("*", "", false).
+		Eq("is_active", "true").
+		Order("name", &postgrest.OrderOpts{Ascending: true}).
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &branches)
+	}
+	if err != nil {
+		fmt.Printf("[GetBranches] Query error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch branches",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    branches,
+	})
+}
+
+func (h *BranchHandler) CreateBranch(c *gin.Context) {
+	var req models.BranchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+	if req.Code == nil || req.Name == nil || strings.TrimSpace(*req.Name) == "" {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "code and name are required",
+		})
+		return
+	}
+
+	branchData, ok := branchData(c, req)
+	if !ok {
+		return
+	}
+
+	var created []models.Branch
+	data, _, err := h.supabase.From("branches").
+		Insert(branchData, false, "", "", "").
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &created)
+	}
+
+	if err != nil || len(created) == 0 {
+		fmt.Printf("[CreateBranch] Insert error: %v\n", err)
+		c.JSON(http.StatusConflict, models.Response{
+			Success: false,
+			Error:   "Failed to create branch, the code may already be in use",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.Response{
+		Success: true,
+		Message: "Branch created successfully",
+		Data:    created[0],
+	})
+}
+
+// UpdateBranch edits a branch. Deactivated branches drop out of the public
+// list and can no longer be given new doctors; existing data is kept.
+func (h *BranchHandler) UpdateBranch(c *gin.Context) {
+	branchID := c.Param("id")
+
+	var req models.BranchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	branchData, ok := branchData(c, req)
+	if !ok {
+		return
+	}
+	branchData["updated_at"] = time.Now()
+
+	var updated []models.Branch
+	data, _, err := h.supabase.From("branches").
+		Update(branchData, "", "").
+		Eq("id", branchID).
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &updated)
+	}
+
+	if err != nil || len(updated) == 0 {
+		c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Branch not found or update failed",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Branch updated successfully",
+		Data:    updated[0],
+	})
+}
+
+// branchData validates a branch request and converts the fields it sets to
+// a row. It writes an error response and reports false when invalid.
+func branchData(c *gin.Context, req models.BranchRequest) (map[string]interface{}, bool) {
+	row := map[string]interface{}{}
+	if req.Code != nil {
+		if !branchCodePattern.MatchString(*req.Code) {
+			c.JSON(http.StatusBadRequest, models.Response{
+				Success: false,
+				Error:   "Branch codes are lower case letters, digits, dashes and underscores",
+			})
+			return nil, false
+		}
+		row["code"] = *req.Code
+	}
+	if req.Name != nil {
+		row["name"] = strings.TrimSpace(*req.Name)
+	}
+	if req.Address != nil {
+		row["address"] = *req.Address
+	}
+	if req.Phone != nil {
+		row["phone"] = *req.Phone
+	}
+	if req.IsActive != nil {
+		row["is_active"] = *req.IsActive
+	}
+	return row, true
+}
+
+// branchScope resolves the branches a staff request covers; nil means every
+// branch. requested, when set, narrows the scope to that branch and must be
+// one the caller works at. It writes a 403 and reports false when the caller
+// may not see the requested branch or has no branch at all.
+func branchScope(c *gin.Context, requested string) ([]string, bool) {
+	branchIDs, all := middleware.BranchScope(c)
+	if all {
+		if requested != "" {
+			return []string{requested}, true
+		}
+		return nil, true
+	}
+
+	if len(branchIDs) == 0 {
+		c.JSON(http.StatusForbidden, models.Response{
+			Success: false,
+			Error:   "No branch assigned to your account",
+		})
+		return nil, false
+	}
+	if requested == "" {
+		return branchIDs, true
+	}
+	if !middleware.InBranchScope(c, requested) {
+		respondBranchForbidden(c)
+		return nil, false
+	}
+	return []string{requested}, true
+}
+
+// respondBranchForbidden rejects access to a record at another branch.
+func respondBranchForbidden(c *gin.Context) {
+	c.JSON(http.StatusForbidden, models.Response{
+		Success: false,
+		Error:   "You do not have access to this branch",
+	})
+}
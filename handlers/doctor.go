@@ -2,12 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	supa "github.com/supabase-community/supabase-go"
 	"github.com/sittawut/backend-appointment/config"
 	"github.com/sittawut/backend-appointment/models"
+	"github.com/supabase-community/postgrest-go"
+	supa "github.com/supabase-community/supabase-go"
 )
 
 type DoctorHandler struct {
@@ -22,16 +26,47 @@ func NewDoctorHandler(supabase *supa.Client, cfg *config.Config) *DoctorHandler
 	}
 }
 
+// GetDoctors lists active doctors with their specialties and next available
+// slot. specialty (names) and specialty_id may be repeated or comma separated;
+// a doctor matches when they have any of the requested specialties. branch_id
+// limits the list to doctors based at that branch and their next slot there.
 func (h *DoctorHandler) GetDoctors(c *gin.Context) {
-	specialty := c.Query("specialty")
+	branchID := c.Query("branch_id")
+	specialtyIDs := splitQueryList(c.QueryArray("specialty_id"))
+	if names := splitQueryList(c.QueryArray("specialty")); len(names) > 0 {
+		ids, err := h.specialtyIDsByName(names)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.Response{
+				Success: false,
+				Error:   "Failed to fetch doctors",
+			})
+			return
+		}
+		if len(ids) == 0 {
+			c.JSON(http.StatusOK, models.Response{
+				Success: true,
+				Data:    []models.Doctor{},
+			})
+			return
+		}
+		specialtyIDs = append(specialtyIDs, ids...)
+	}
+
+	selectCols := "*, specialties(id, name, name_en, is_active)"
+	if len(specialtyIDs) > 0 {
+		selectCols += ", matched:doctor_specialties!inner(specialty_id)"
+	}
 
 	query := h.supabase.From("doctors").
-		Select("*", "", false).
+		Select(selectCols, "", false).
 		Eq("is_active", "true").
-		Order("full_name", nil)
+		Order("full_name", &postgrest.OrderOpts{Ascending: true})
 
-	if specialty != "" {
-		query = query.Eq("specialty", specialty)
+	if len(specialtyIDs) > 0 {
+		query = query.In("matched.specialty_id", specialtyIDs)
+	}
+	if branchID != "" {
+		query = query.Eq("branch_id", branchID)
 	}
 
 	var doctors []models.Doctor
@@ -41,6 +76,7 @@ func (h *DoctorHandler) GetDoctors(c *gin.Context) {
 	}
 
 	if err != nil {
+		fmt.Printf("[GetDoctors] Query error: %v\n", err)
 		c.JSON(http.StatusInternalServerError, models.Response{
 			Success: false,
 			Error:   "Failed to fetch doctors",
@@ -48,18 +84,123 @@ func (h *DoctorHandler) GetDoctors(c *gin.Context) {
 		return
 	}
 
+	if err := h.attachNextAvailableSlots(doctors, branchID); err != nil {
+		// The list is still useful without next slots.
+		fmt.Printf("[GetDoctors] Next available slot lookup error: %v\n", err)
+	}
+
 	c.JSON(http.StatusOK, models.Response{
 		Success: true,
 		Data:    doctors,
 	})
 }
 
+// GetSpecialties lists the specialty catalogue.
+func (h *DoctorHandler) GetSpecialties(c *gin.Context) {
+	query := h.supabase.From("specialties").
+		Select("*", "", false).
+		Order("name", &postgrest.OrderOpts{Ascending: true})
+
+	if c.Query("include_inactive") != "true" {
+		query = query.Eq("is_active", "true")
+	}
+
+	var specialties []models.Specialty
+	data, _, err := query.Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &specialties)
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch specialties",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    specialties,
+	})
+}
+
+// specialtyIDsByName resolves catalogue names to IDs, ignoring unknown names.
+func (h *DoctorHandler) specialtyIDsByName(names []string) ([]string, error) {
+	var specialties []models.Specialty
+	data, _, err := h.supabase.From("specialties").
+		Select("id, name", "", false).
+		In("name", names).
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &specialties)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(specialties))
+	for _, s := range specialties {
+		ids = append(ids, s.ID)
+	}
+	return ids, nil
+}
+
+// attachNextAvailableSlots fills in each doctor's earliest bookable slot, at
+// branchID when set.
+func (h *DoctorHandler) attachNextAvailableSlots(doctors []models.Doctor, branchID string) error {
+	if len(doctors) == 0 {
+		return nil
+	}
+
+	doctorIDs := make([]string, len(doctors))
+	for i, d := range doctors {
+		doctorIDs[i] = d.ID
+	}
+
+	params := map[string]interface{}{
+		"p_doctor_ids": doctorIDs,
+		"p_now":        time.Now(),
+		"p_timezone":   h.config.ClinicTimezone,
+		"p_branch_id":  nullableString(branchID),
+	}
+
+	var slots []models.AvailableSlot
+	if err := callRPC(h.supabase, "get_next_available_slots", params, &slots); err != nil {
+		return err
+	}
+
+	next := make(map[string]models.AvailableSlot, len(slots))
+	for _, slot := range slots {
+		next[slot.DoctorID] = slot
+	}
+	for i := range doctors {
+		if slot, ok := next[doctors[i].ID]; ok {
+			doctors[i].NextAvailableSlot = &slot
+		}
+	}
+	return nil
+}
+
+// splitQueryList flattens repeated and comma separated query values.
+func splitQueryList(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
 func (h *DoctorHandler) GetDoctorByID(c *gin.Context) {
 	doctorID := c.Param("id")
 
 	var doctors []models.Doctor
 	data, _, err := h.supabase.From("doctors").
-		Select("*", "", false).
+		Select("*, specialties(id, name, name_en, is_active)", "", false).
 		Eq("id", doctorID).
 		Execute()
 	if err == nil {
@@ -83,6 +224,7 @@ func (h *DoctorHandler) GetDoctorByID(c *gin.Context) {
 func (h *DoctorHandler) GetSchedules(c *gin.Context) {
 	doctorID := c.Query("doctor_id")
 	date := c.Query("date")
+	branchID := c.Query("branch_id")
 
 	query := h.supabase.From("doctor_schedules").
 		Select("*", "", false).
@@ -94,6 +236,9 @@ func (h *DoctorHandler) GetSchedules(c *gin.Context) {
 	if date != "" {
 		query = query.Eq("schedule_date", date)
 	}
+	if branchID != "" {
+		query = query.Eq("branch_id", branchID)
+	}
 
 	var schedules []models.DoctorSchedule
 	data, _, err := query.Execute()
@@ -156,9 +301,15 @@ func (h *DoctorHandler) GetTimeSlots(c *gin.Context) {
 	})
 }
 
+// GetAvailableSlots searches bookable slots on a date. specialty and
+// specialty_id work as in GetDoctors, so doctors are found under any of their
+// catalogue specialties.
 func (h *DoctorHandler) GetAvailableSlots(c *gin.Context) {
 	date := c.Query("date")
-	_ = c.Query("specialty") // TODO: implement specialty filter
+	doctorID := c.Query("doctor_id")
+	timeFrom := c.Query("time_from")
+	timeTo := c.Query("time_to")
+	branchID := c.Query("branch_id")
 
 	if date == "" {
 		c.JSON(http.StatusBadRequest, models.Response{
@@ -167,14 +318,187 @@ func (h *DoctorHandler) GetAvailableSlots(c *gin.Context) {
 		})
 		return
 	}
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "date must be in YYYY-MM-DD format",
+		})
+		return
+	}
+	for _, t := range []string{timeFrom, timeTo} {
+		if t == "" {
+			continue
+		}
+		if _, err := time.Parse("15:04", t); err != nil {
+			c.JSON(http.StatusBadRequest, models.Response{
+				Success: false,
+				Error:   "time_from and time_to must be in HH:MM format",
+			})
+			return
+		}
+	}
+
+	specialtyIDs := splitQueryList(c.QueryArray("specialty_id"))
+	if names := splitQueryList(c.QueryArray("specialty")); len(names) > 0 {
+		ids, err := h.specialtyIDsByName(names)
+		if err != nil {
+			fmt.Printf("[GetAvailableSlots] Specialty lookup error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, models.Response{
+				Success: false,
+				Error:   "Failed to fetch available slots",
+			})
+			return
+		}
+		if len(ids) == 0 {
+			c.JSON(http.StatusOK, models.Response{
+				Success: true,
+				Data:    []models.AvailableSlot{},
+			})
+			return
+		}
+		specialtyIDs = append(specialtyIDs, ids...)
+	}
+
+	rows, err := h.fetchSlotRows(slotFilter{
+		Date:         date,
+		SpecialtyIDs: specialtyIDs,
+		DoctorID:     doctorID,
+		TimeFrom:     timeFrom,
+		TimeTo:       timeTo,
+		BranchID:     branchID,
+	})
+	if err != nil {
+		fmt.Printf("[GetAvailableSlots] Query error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch available slots",
+		})
+		return
+	}
 
-	// This would require a custom SQL query or multiple queries
-	// For simplicity, returning a basic response
-	// In production, you'd want to use a stored procedure or complex query
+	slots := []models.AvailableSlot{}
+	for _, row := range rows {
+		if slot, ok := row.toAvailableSlot(); ok {
+			slots = append(slots, slot)
+		}
+	}
 
 	c.JSON(http.StatusOK, models.Response{
 		Success: true,
-		Message: "Available slots endpoint - implement custom query",
-		Data:    []models.AvailableSlot{},
+		Data:    slots,
 	})
 }
+
+// slotFilter narrows the time_slots search used by availability lookups.
+// SpecialtyIDs matches doctors listed under any of the catalogue
+// specialties, primary or not.
+type slotFilter struct {
+	Date         string
+	SpecialtyIDs []string
+	DoctorID     string
+	TimeFrom     string
+	TimeTo       string
+	BranchID     string
+}
+
+// slotRow is a time_slots row with its schedule and doctor embedded.
+type slotRow struct {
+	models.TimeSlot
+	Schedule struct {
+		DoctorID     string `json:"doctor_id"`
+		BranchID     string `json:"branch_id"`
+		ScheduleDate string `json:"schedule_date"`
+		IsAvailable  bool   `json:"is_available"`
+		Doctor       struct {
+			models.Doctor
+			// Matched holds the doctor's specialties that the search asked for.
+			Matched []struct {
+				SpecialtyID string `json:"specialty_id"`
+			} `json:"matched"`
+		} `json:"doctor"`
+	} `json:"schedule"`
+}
+
+// toAvailableSlot converts the row into an AvailableSlot, reporting false
+// when the slot cannot take any more bookings.
+func (r slotRow) toAvailableSlot() (models.AvailableSlot, bool) {
+	remaining := r.MaxCapacity - r.CurrentBookings
+	if remaining <= 0 || !r.Schedule.IsAvailable || !r.Schedule.Doctor.IsActive {
+		return models.AvailableSlot{}, false
+	}
+
+	return models.AvailableSlot{
+		TimeSlotID:     r.ID,
+		DoctorID:       r.Schedule.DoctorID,
+		BranchID:       r.Schedule.BranchID,
+		DoctorName:     r.Schedule.Doctor.FullName,
+		DoctorTitle:    r.Schedule.Doctor.Title,
+		Specialty:      r.specialty(),
+		Specialties:    r.Schedule.Doctor.Specialties,
+		ScheduleDate:   r.Schedule.ScheduleDate,
+		StartTime:      r.StartTime,
+		EndTime:        r.EndTime,
+		AvailableSlots: remaining,
+	}, true
+}
+
+// specialty names the specialty the slot was found under: the first matched
+// catalogue specialty when the search filtered by specialty, otherwise the
+// doctor's primary one.
+func (r slotRow) specialty() string {
+	for _, m := range r.Schedule.Doctor.Matched {
+		for _, s := range r.Schedule.Doctor.Specialties {
+			if s.ID == m.SpecialtyID {
+				return s.Name
+			}
+		}
+	}
+	return r.Schedule.Doctor.Specialty
+}
+
+// fetchSlotRows joins time_slots with doctor_schedules and doctors in a single
+// PostgREST request. Blocked and inactive slots, unavailable schedules and
+// inactive doctors are excluded by the inner joins.
+func (h *DoctorHandler) fetchSlotRows(f slotFilter) ([]slotRow, error) {
+	doctorCols := "*, specialties(id, name, name_en, is_active)"
+	if len(f.SpecialtyIDs) > 0 {
+		doctorCols += ", matched:doctor_specialties!inner(specialty_id)"
+	}
+
+	query := h.supabase.From("time_slots").
+		Select("*, schedule:doctor_schedules!inner(doctor_id, branch_id, schedule_date, is_available, doctor:doctors!inner("+doctorCols+"))", "", false).
+		Not("status", "in", "(blocked,inactive)").
+		Eq("schedule.is_available", "true").
+		Eq("schedule.doctor.is_active", "true").
+		Order("start_time", &postgrest.OrderOpts{Ascending: true})
+
+	if f.Date != "" {
+		query = query.Eq("schedule.schedule_date", f.Date)
+	}
+	if f.DoctorID != "" {
+		query = query.Eq("schedule.doctor_id", f.DoctorID)
+	}
+	if f.BranchID != "" {
+		query = query.Eq("schedule.branch_id", f.BranchID)
+	}
+	if len(f.SpecialtyIDs) > 0 {
+		query = query.In("schedule.doctor.matched.specialty_id", f.SpecialtyIDs)
+	}
+	if f.TimeFrom != "" {
+		query = query.Gte("start_time", f.TimeFrom)
+	}
+	if f.TimeTo != "" {
+		query = query.Lte("end_time", f.TimeTo)
+	}
+
+	data, _, err := query.Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []slotRow
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sittawut/backend-appointment/services"
+)
+
+// JWKSHandler publishes the public keys that verify our access tokens.
+type JWKSHandler struct {
+	keys *services.TokenKeys
+}
+
+func NewJWKSHandler(keys *services.TokenKeys) *JWKSHandler {
+	return &JWKSHandler{
+		keys: keys,
+	}
+}
+
+// GetJWKS serves the key set in RFC 7517 form, not wrapped in
+// models.Response, so standard JWT libraries can consume it. Caches may keep
+// it for a few minutes; a new verification key must be deployed that long
+// before it starts signing.
+func (h *JWKSHandler) GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.keys.JWKS())
+}
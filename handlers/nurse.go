@@ -2,34 +2,62 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	supa "github.com/supabase-community/supabase-go"
+	"github.com/google/uuid"
 	"github.com/sittawut/backend-appointment/config"
+	"github.com/sittawut/backend-appointment/middleware"
 	"github.com/sittawut/backend-appointment/models"
+	"github.com/sittawut/backend-appointment/services"
+	"github.com/supabase-community/postgrest-go"
+	supa "github.com/supabase-community/supabase-go"
 )
 
 type NurseHandler struct {
-	supabase *supa.Client
-	config   *config.Config
+	supabase    *supa.Client
+	config      *config.Config
+	sms         *services.SMSService
+	notifier    *services.Notifier
+	sessions    *services.SessionService
+	permissions *services.PermissionService
 }
 
-func NewNurseHandler(supabase *supa.Client, cfg *config.Config) *NurseHandler {
+func NewNurseHandler(supabase *supa.Client, cfg *config.Config, smsService *services.SMSService, notifier *services.Notifier, sessions *services.SessionService, permissions *services.PermissionService) *NurseHandler {
 	return &NurseHandler{
-		supabase: supabase,
-		config:   cfg,
+		supabase:    supabase,
+		config:      cfg,
+		sms:         smsService,
+		notifier:    notifier,
+		sessions:    sessions,
+		permissions: permissions,
 	}
 }
 
 func (h *NurseHandler) GetAllBookings(c *gin.Context) {
 	status := c.Query("status")
 	date := c.Query("date")
+	branchIDs, ok := branchScope(c, c.Query("branch_id"))
+	if !ok {
+		return
+	}
 
 	query := h.supabase.From("bookings").
 		Select("*", "", false).
 		Order("appointment_date", nil)
 
+	if branchIDs != nil {
+		query = query.In("branch_id", branchIDs)
+	}
+
+	if c.Query("include_deleted") != "true" {
+		query = query.Is("deleted_at", "null")
+	}
+
 	if status != "" {
 		query = query.Eq("status", status)
 	}
@@ -67,76 +95,1515 @@ func (h *NurseHandler) CreateBookingForCustomer(c *gin.Context) {
 		return
 	}
 
-	userID, _ := c.Get("user_id")
+	if req.CustomerID == "" {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "customer_id is required",
+		})
+		return
+	}
 
-	bookingData := map[string]interface{}{
-		"customer_id":      req.CustomerID,
-		"appointment_date": req.AppointmentDate,
-		"status":           req.Status,
-		"notes":            req.Notes,
-		"created_by":       userID.(string),
+	userID, _ := c.Get("user_id")
+	branchIDs, ok := branchScope(c, "")
+	if !ok {
+		return
 	}
 
-	var createdBookings []models.Booking
-	data, _, err := h.supabase.From("bookings").Insert(bookingData, false, "", "", "").Execute()
-	if err == nil {
-		err = json.Unmarshal(data, &createdBookings)
+	status := models.StatusPending
+	if req.Status != "" {
+		status = req.Status
+	}
+	if !models.IsInitialStatus(status, middleware.PermissionCheck(c)) {
+		c.JSON(http.StatusUnprocessableEntity, models.Response{
+			Success: false,
+			Error:   fmt.Sprintf("Cannot create a booking with status %s", status),
+		})
+		return
 	}
 
+	booking, err := createBooking(h.supabase, createBookingParams{
+		CustomerID:      req.CustomerID,
+		AppointmentDate: req.AppointmentDate,
+		Status:          status,
+		Notes:           req.Notes,
+		CreatedBy:       userID.(string),
+		Appointments:    req.Appointments,
+		BranchIDs:       branchIDs,
+	})
 	if err != nil {
+		fmt.Printf("[CreateBookingForCustomer] Create error: %v\n", err)
+		if respondSlotConflict(c, err) || respondBookingError(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, models.Response{
 			Success: false,
 			Error:   "Failed to create booking",
 		})
 		return
 	}
+	notifyBookingStatus(h.notifier, booking, "")
 
 	c.JSON(http.StatusCreated, models.Response{
 		Success: true,
-		Data:    createdBookings[0],
+		Message: "Booking created successfully",
+		Data:    booking,
 	})
 }
 
 func (h *NurseHandler) UpdateBooking(c *gin.Context) {
-	c.JSON(http.StatusOK, models.Response{Success: true})
+	bookingID := c.Param("id")
+	userID, _ := c.Get("user_id")
+	userIDStr := userID.(string)
+
+	var req models.NurseUpdateBookingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	branchIDs, ok := branchScope(c, "")
+	if !ok {
+		return
+	}
+	query := h.supabase.From("bookings").
+		Select("*", "", false).
+		Eq("id", bookingID).
+		Is("deleted_at", "null")
+	if branchIDs != nil {
+		query = query.In("branch_id", branchIDs)
+	}
+
+	var bookings []models.Booking
+	data, _, err := query.Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &bookings)
+	}
+	if err != nil || len(bookings) == 0 {
+		c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Booking not found",
+		})
+		return
+	}
+	booking := &bookings[0]
+
+	previousStatus := booking.Status
+	if req.Status != nil && *req.Status != booking.Status &&
+		!models.CanTransition(booking.Status, *req.Status, middleware.PermissionCheck(c)) {
+		respondIllegalTransition(c, booking.Status, *req.Status)
+		return
+	}
+	if !h.allowRemovals(c, bookingID, req.Appointments) {
+		return
+	}
+
+	// Appointment changes, status and notes go in one transaction so a
+	// refused status change does not leave the appointments edited
+	booking, err = updateBooking(h.supabase, updateBookingParams{
+		BookingID:       bookingID,
+		UpdatedBy:       userIDStr,
+		AppointmentDate: req.AppointmentDate,
+		Changes:         req.Appointments,
+		ExpectedStatus:  previousStatus,
+		Status:          req.Status,
+		Notes:           req.Notes,
+	})
+	if err != nil {
+		fmt.Printf("[NurseUpdateBooking] Update error: %v\n", err)
+		if respondSlotConflict(c, err) || respondBookingError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to update booking",
+		})
+		return
+	}
+
+	var moved []string
+	for _, change := range req.Appointments {
+		if change.AppointmentID != "" && change.TimeSlotID != "" && !change.Remove {
+			moved = append(moved, change.AppointmentID)
+		}
+	}
+	if len(moved) > 0 && h.notifier != nil {
+		go h.notifier.NotifyAppointments(moved, models.NotificationAppointmentRescheduled, "")
+	}
+	if req.Status != nil && booking.Status != previousStatus {
+		notifyBookingStatus(h.notifier, booking, "")
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Booking updated successfully",
+		Data:    booking,
+	})
+}
+
+// allowRemovals checks each appointment a booking edit removes against the
+// status machine, since removing one cancels it. It writes the error
+// response and reports false when a removal is not allowed.
+func (h *NurseHandler) allowRemovals(c *gin.Context, bookingID string, changes []models.AppointmentChange) bool {
+	var removed []string
+	for _, change := range changes {
+		if change.Remove && change.AppointmentID != "" {
+			removed = append(removed, change.AppointmentID)
+		}
+	}
+	if len(removed) == 0 {
+		return true
+	}
+
+	var appointments []models.Appointment
+	data, _, err := h.supabase.From("appointments").
+		Select("id, status", "", false).
+		Eq("booking_id", bookingID).
+		In("id", removed).
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &appointments)
+	}
+	if err != nil {
+		fmt.Printf("[NurseUpdateBooking] Appointment lookup error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to update appointments",
+		})
+		return false
+	}
+
+	// Unknown appointments are reported by update_booking_appointments
+	for _, apt := range appointments {
+		if !allowCancel(c, apt.Status) {
+			return false
+		}
+	}
+	return true
 }
 
 func (h *NurseHandler) DeleteBooking(c *gin.Context) {
-	c.JSON(http.StatusOK, models.Response{Success: true})
+	bookingID := c.Param("id")
+	userID, _ := c.Get("user_id")
+
+	var req models.DeleteBookingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "A reason is required to delete a booking",
+		})
+		return
+	}
+
+	if !bookingInBranchScope(c, h.supabase, bookingID) {
+		c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Booking not found",
+		})
+		return
+	}
+
+	booking, err := softDeleteBooking(h.supabase, bookingID, userID.(string), req.Reason)
+	if err != nil {
+		fmt.Printf("[NurseDeleteBooking] Delete error: %v\n", err)
+		if respondBookingError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to delete booking",
+		})
+		return
+	}
+	// Deleting an open booking cancels it, which the customer should hear about
+	notifyBookingStatus(h.notifier, booking, "")
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Booking deleted successfully",
+		Data:    booking,
+	})
 }
 
+// maxDashboardDays caps the date range a single dashboard request may cover.
+const maxDashboardDays = 93
+
 func (h *NurseHandler) GetDashboard(c *gin.Context) {
-	c.JSON(http.StatusOK, models.Response{Success: true})
+	today := time.Now().In(h.config.ClinicLocation).Format("2006-01-02")
+	dateFrom := c.DefaultQuery("date_from", c.DefaultQuery("date", today))
+	dateTo := c.DefaultQuery("date_to", dateFrom)
+
+	from, errFrom := time.Parse("2006-01-02", dateFrom)
+	to, errTo := time.Parse("2006-01-02", dateTo)
+	if errFrom != nil || errTo != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Dates must be in YYYY-MM-DD format",
+		})
+		return
+	}
+	if to.Before(from) || to.Sub(from) > maxDashboardDays*24*time.Hour {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   fmt.Sprintf("date_to must be on or after date_from and within %d days", maxDashboardDays),
+		})
+		return
+	}
+
+	branchIDs, ok := branchScope(c, c.Query("branch_id"))
+	if !ok {
+		return
+	}
+
+	params := map[string]interface{}{
+		"p_date_from":  dateFrom,
+		"p_date_to":    dateTo,
+		"p_now":        time.Now(),
+		"p_timezone":   h.config.ClinicTimezone,
+		"p_branch_ids": branchIDs,
+	}
+
+	var dashboard models.Dashboard
+	if err := callRPC(h.supabase, "get_nurse_dashboard", params, &dashboard); err != nil {
+		fmt.Printf("[GetDashboard] Query error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to load dashboard",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    dashboard,
+	})
 }
 
 func (h *NurseHandler) BlockTimeSlots(c *gin.Context) {
-	c.JSON(http.StatusOK, models.Response{Success: true})
+	userID, _ := c.Get("user_id")
+
+	var req models.SlotBlockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	slotIDs, ok := h.resolveSlotIDs(c, req, false)
+	if !ok {
+		return
+	}
+
+	affected, err := h.findAffectedAppointments(slotIDs)
+	if err != nil {
+		fmt.Printf("[BlockTimeSlots] Affected lookup error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to check existing bookings",
+		})
+		return
+	}
+
+	result := models.SlotBlockResult{
+		SlotIDs:              slotIDs,
+		AffectedAppointments: affected,
+		DryRun:               req.DryRun,
+	}
+
+	if req.DryRun || len(slotIDs) == 0 {
+		c.JSON(http.StatusOK, models.Response{
+			Success: true,
+			Data:    result,
+		})
+		return
+	}
+
+	if len(affected) > 0 && !req.Force {
+		c.JSON(http.StatusConflict, models.Response{
+			Success: false,
+			Error:   "Some slots still have bookings; set force to block them and flag the appointments for rescheduling",
+			Data:    result,
+		})
+		return
+	}
+
+	params := map[string]interface{}{
+		"p_slot_ids":   slotIDs,
+		"p_blocked_by": userID.(string),
+		"p_reason":     nullableString(req.Reason),
+		"p_force":      req.Force,
+	}
+	if err := callRPC(h.supabase, "block_time_slots", params, &result); err != nil {
+		fmt.Printf("[BlockTimeSlots] Block error: %v\n", err)
+		if rpcErr, ok := err.(*rpcError); ok && rpcErr.Reason() == "slot_has_bookings" {
+			c.JSON(http.StatusConflict, models.Response{
+				Success: false,
+				Error:   "A slot was booked while blocking; please review and try again",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to block time slots",
+		})
+		return
+	}
+
+	h.notifyRescheduleNeeded(result.AffectedAppointments, req.Reason)
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: fmt.Sprintf("Blocked %d time slots", result.BlockedCount),
+		Data:    result,
+	})
 }
 
 func (h *NurseHandler) UnblockTimeSlots(c *gin.Context) {
-	c.JSON(http.StatusOK, models.Response{Success: true})
+	var req models.SlotBlockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	slotIDs, ok := h.resolveSlotIDs(c, req, true)
+	if !ok {
+		return
+	}
+
+	result := models.SlotBlockResult{
+		SlotIDs:              slotIDs,
+		AffectedAppointments: []models.AffectedAppointment{},
+		DryRun:               req.DryRun,
+	}
+
+	if req.DryRun || len(slotIDs) == 0 {
+		c.JSON(http.StatusOK, models.Response{
+			Success: true,
+			Data:    result,
+		})
+		return
+	}
+
+	params := map[string]interface{}{
+		"p_slot_ids": slotIDs,
+	}
+	if err := callRPC(h.supabase, "unblock_time_slots", params, &result); err != nil {
+		fmt.Printf("[UnblockTimeSlots] Unblock error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to unblock time slots",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: fmt.Sprintf("Unblocked %d time slots", result.UnblockedCount),
+		Data:    result,
+	})
 }
 
-func (h *NurseHandler) CreateDoctor(c *gin.Context) {
-	c.JSON(http.StatusOK, models.Response{Success: true})
+// maxBlockDays caps the date range a single block request may cover.
+const maxBlockDays = 366
+
+// resolveSlotIDs turns a block request into the list of matching time slot
+// IDs at the caller's branches. When blocked is true only blocked slots
+// match, otherwise only slots that are not blocked yet. It writes a 4xx/5xx
+// response and reports false when the selection is invalid or cannot be
+// loaded.
+func (h *NurseHandler) resolveSlotIDs(c *gin.Context, req models.SlotBlockRequest, blocked bool) ([]string, bool) {
+	branchIDs, ok := branchScope(c, req.BranchID)
+	if !ok {
+		return nil, false
+	}
+
+	query := h.supabase.From("time_slots").
+		Select("id, schedule:doctor_schedules!inner(doctor_id, branch_id, schedule_date)", "", false)
+
+	if branchIDs != nil {
+		query = query.In("schedule.branch_id", branchIDs)
+	}
+
+	if blocked {
+		query = query.Eq("status", "blocked")
+	} else {
+		query = query.Neq("status", "blocked")
+	}
+
+	if len(req.SlotIDs) > 0 {
+		query = query.In("id", req.SlotIDs)
+	} else {
+		if req.DateFrom == "" {
+			c.JSON(http.StatusBadRequest, models.Response{
+				Success: false,
+				Error:   "Either slot_ids or date_from is required",
+			})
+			return nil, false
+		}
+		dateTo := req.DateTo
+		if dateTo == "" {
+			dateTo = req.DateFrom
+		}
+
+		from, errFrom := time.Parse("2006-01-02", req.DateFrom)
+		to, errTo := time.Parse("2006-01-02", dateTo)
+		if errFrom != nil || errTo != nil || to.Before(from) || to.Sub(from) > maxBlockDays*24*time.Hour {
+			c.JSON(http.StatusBadRequest, models.Response{
+				Success: false,
+				Error:   fmt.Sprintf("date_from and date_to must be YYYY-MM-DD, in order and within %d days", maxBlockDays),
+			})
+			return nil, false
+		}
+		for _, t := range []string{req.TimeFrom, req.TimeTo} {
+			if t == "" {
+				continue
+			}
+			if _, err := time.Parse("15:04", t); err != nil {
+				c.JSON(http.StatusBadRequest, models.Response{
+					Success: false,
+					Error:   "time_from and time_to must be in HH:MM format",
+				})
+				return nil, false
+			}
+		}
+
+		query = query.And(fmt.Sprintf("schedule_date.gte.%s,schedule_date.lte.%s", req.DateFrom, dateTo), "schedule")
+		if req.DoctorID != "" {
+			query = query.Eq("schedule.doctor_id", req.DoctorID)
+		}
+		if req.TimeFrom != "" {
+			query = query.Gte("start_time", req.TimeFrom)
+		}
+		if req.TimeTo != "" {
+			query = query.Lte("end_time", req.TimeTo)
+		}
+	}
+
+	var rows []struct {
+		ID string `json:"id"`
+	}
+	data, _, err := query.Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &rows)
+	}
+	if err != nil {
+		fmt.Printf("[SlotBlock] Slot lookup error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to find time slots",
+		})
+		return nil, false
+	}
+
+	slotIDs := make([]string, 0, len(rows))
+	for _, row := range rows {
+		slotIDs = append(slotIDs, row.ID)
+	}
+	return slotIDs, true
 }
 
-func (h *NurseHandler) UpdateDoctor(c *gin.Context) {
-	c.JSON(http.StatusOK, models.Response{Success: true})
+// affectedAppointmentRow is an open appointment with its booking, customer
+// and slot time embedded.
+type affectedAppointmentRow struct {
+	ID         string `json:"id"`
+	BookingID  string `json:"booking_id"`
+	TimeSlotID string `json:"time_slot_id"`
+	Booking    struct {
+		BookingNumber *string `json:"booking_number"`
+		Customer      struct {
+			ID       string `json:"id"`
+			FullName string `json:"full_name"`
+			Phone    string `json:"phone"`
+		} `json:"customer"`
+	} `json:"booking"`
+	TimeSlot struct {
+		StartTime string `json:"start_time"`
+		Schedule  struct {
+			ScheduleDate string `json:"schedule_date"`
+		} `json:"schedule"`
+	} `json:"time_slot"`
 }
 
-func (h *NurseHandler) DeleteDoctor(c *gin.Context) {
-	c.JSON(http.StatusOK, models.Response{Success: true})
+// findAffectedAppointments lists the open appointments booked on the slots.
+// Slot IDs are queried in chunks to keep request URLs short.
+func (h *NurseHandler) findAffectedAppointments(slotIDs []string) ([]models.AffectedAppointment, error) {
+	const chunkSize = 100
+
+	affected := []models.AffectedAppointment{}
+	for start := 0; start < len(slotIDs); start += chunkSize {
+		end := start + chunkSize
+		if end > len(slotIDs) {
+			end = len(slotIDs)
+		}
+
+		var rows []affectedAppointmentRow
+		data, _, err := h.supabase.From("appointments").
+			Select("id, booking_id, time_slot_id, "+
+				"booking:bookings!inner(booking_number, customer:users!customer_id(id, full_name, phone)), "+
+				"time_slot:time_slots(start_time, schedule:doctor_schedules(schedule_date))", "", false).
+			In("time_slot_id", slotIDs[start:end]).
+			In("status", []string{models.StatusPending, models.StatusConfirmed}).
+			Execute()
+		if err == nil {
+			err = json.Unmarshal(data, &rows)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, row := range rows {
+			affected = append(affected, models.AffectedAppointment{
+				AppointmentID: row.ID,
+				BookingID:     row.BookingID,
+				BookingNumber: row.Booking.BookingNumber,
+				TimeSlotID:    row.TimeSlotID,
+				ScheduleDate:  row.TimeSlot.Schedule.ScheduleDate,
+				StartTime:     row.TimeSlot.StartTime,
+				CustomerID:    row.Booking.Customer.ID,
+				CustomerName:  row.Booking.Customer.FullName,
+				CustomerPhone: row.Booking.Customer.Phone,
+			})
+		}
+	}
+	return affected, nil
 }
 
-func (h *NurseHandler) GetAllUsers(c *gin.Context) {
-	c.JSON(http.StatusOK, models.Response{Success: true})
+// notifyRescheduleNeeded queues a notice for each affected customer that
+// their appointment has to be moved.
+func (h *NurseHandler) notifyRescheduleNeeded(affected []models.AffectedAppointment, reason string) {
+	if h.notifier == nil || len(affected) == 0 {
+		return
+	}
+
+	ids := make([]string, len(affected))
+	for i, apt := range affected {
+		ids[i] = apt.AppointmentID
+	}
+	go h.notifier.NotifyAppointments(ids, models.NotificationRescheduleNeeded, reason)
 }
 
-func (h *NurseHandler) CreateUser(c *gin.Context) {
-	c.JSON(http.StatusOK, models.Response{Success: true})
+// GetSMSHealth reports the circuit breaker state of each SMS provider.
+func (h *NurseHandler) GetSMSHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    h.sms.Health(),
+	})
 }
 
-func (h *NurseHandler) UpdateUser(c *gin.Context) {
-	c.JSON(http.StatusOK, models.Response{Success: true})
+// GetSMSMessages lists the latest SMS sent to a phone number with their
+// delivery status, newest first.
+func (h *NurseHandler) GetSMSMessages(c *gin.Context) {
+	phone := strings.TrimSpace(c.Query("phone"))
+	if phone == "" {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "phone is required",
+		})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "limit must be between 1 and 100",
+		})
+		return
+	}
+
+	var messages []models.SMSMessage
+	data, _, err := h.supabase.From("sms_messages").
+		Select("*", "", false).
+		Eq("phone", phone).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(limit, "").
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &messages)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch SMS messages",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    messages,
+	})
+}
+
+func (h *NurseHandler) CreateDoctor(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req models.DoctorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	if req.FullName == nil || strings.TrimSpace(*req.FullName) == "" {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "full_name is required",
+		})
+		return
+	}
+	if len(req.SpecialtyIDs) == 0 {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "At least one specialty_id is required",
+		})
+		return
+	}
+	if req.BranchID == nil || *req.BranchID == "" {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "branch_id is required",
+		})
+		return
+	}
+	if !middleware.InBranchScope(c, *req.BranchID) {
+		respondBranchForbidden(c)
+		return
+	}
+
+	doctor, err := saveDoctor(h.supabase, "", req, userID.(string), time.Now().In(h.config.ClinicLocation).Format("2006-01-02"))
+	if err != nil {
+		if respondDoctorError(c, err) {
+			return
+		}
+		fmt.Printf("[CreateDoctor] RPC error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to create doctor",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.Response{
+		Success: true,
+		Message: "Doctor created successfully",
+		Data:    doctor,
+	})
+}
+
+// UpdateDoctor changes a doctor's details or specialties; "title": null
+// clears the title. It can reactivate a doctor, which reopens the upcoming
+// schedules closed on deactivation, but deactivation goes through
+// DeleteDoctor so that upcoming appointments are checked.
+func (h *NurseHandler) UpdateDoctor(c *gin.Context) {
+	doctorID := c.Param("id")
+	userID, _ := c.Get("user_id")
+
+	var req models.DoctorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	if req.FullName != nil && strings.TrimSpace(*req.FullName) == "" {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "full_name cannot be empty",
+		})
+		return
+	}
+	if req.SpecialtyIDs != nil && len(req.SpecialtyIDs) == 0 {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "At least one specialty_id is required",
+		})
+		return
+	}
+	if req.IsActive != nil && !*req.IsActive {
+		c.JSON(http.StatusUnprocessableEntity, models.Response{
+			Success: false,
+			Error:   "Use DELETE /nurse/doctors/:id to deactivate a doctor",
+		})
+		return
+	}
+	if !h.doctorInBranchScope(c, doctorID) {
+		return
+	}
+	if req.BranchID != nil && !middleware.InBranchScope(c, *req.BranchID) {
+		respondBranchForbidden(c)
+		return
+	}
+
+	doctor, err := saveDoctor(h.supabase, doctorID, req, userID.(string), time.Now().In(h.config.ClinicLocation).Format("2006-01-02"))
+	if err != nil {
+		if respondDoctorError(c, err) {
+			return
+		}
+		fmt.Printf("[UpdateDoctor] RPC error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to update doctor",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Doctor updated successfully",
+		Data:    doctor,
+	})
+}
+
+// DeleteDoctor deactivates a doctor. It is refused while the doctor has
+// upcoming appointments unless reassign_to names a doctor to take them over.
+func (h *NurseHandler) DeleteDoctor(c *gin.Context) {
+	doctorID := c.Param("id")
+	userID, _ := c.Get("user_id")
+
+	var req models.DeactivateDoctorRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.Response{
+				Success: false,
+				Error:   "Invalid request body",
+			})
+			return
+		}
+	}
+	if req.ReassignTo == "" {
+		req.ReassignTo = c.Query("reassign_to")
+	}
+	if !h.doctorInBranchScope(c, doctorID) {
+		return
+	}
+
+	params := map[string]interface{}{
+		"p_doctor_id":      doctorID,
+		"p_deactivated_by": userID.(string),
+		"p_reassign_to":    nullableString(req.ReassignTo),
+		"p_today":          time.Now().In(h.config.ClinicLocation).Format("2006-01-02"),
+	}
+
+	var result models.DeactivateDoctorResult
+	if err := callRPC(h.supabase, "deactivate_doctor", params, &result); err != nil {
+		if respondSlotConflict(c, err) || respondDoctorError(c, err) {
+			return
+		}
+		fmt.Printf("[DeleteDoctor] RPC error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to deactivate doctor",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Doctor deactivated successfully",
+		Data:    result,
+	})
+}
+
+// doctorInBranchScope reports whether the doctor is based at one of the
+// caller's branches. It writes a 404 and reports false otherwise, so doctors
+// elsewhere are indistinguishable from missing ones.
+func (h *NurseHandler) doctorInBranchScope(c *gin.Context, doctorID string) bool {
+	var doctors []models.Doctor
+	data, _, err := h.supabase.From("doctors").
+		Select("id, branch_id", "", false).
+		Eq("id", doctorID).
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &doctors)
+	}
+	if err != nil || len(doctors) == 0 || !middleware.InBranchScope(c, doctors[0].BranchID) {
+		c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Doctor not found",
+		})
+		return false
+	}
+	return true
+}
+
+func (h *NurseHandler) CreateSpecialty(c *gin.Context) {
+	var req models.SpecialtyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	if req.Name == nil || strings.TrimSpace(*req.Name) == "" {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "name is required",
+		})
+		return
+	}
+
+	specialtyData := map[string]interface{}{
+		"name":        strings.TrimSpace(*req.Name),
+		"name_en":     req.NameEn,
+		"description": req.Description,
+	}
+	if req.IsActive != nil {
+		specialtyData["is_active"] = *req.IsActive
+	}
+
+	var created []models.Specialty
+	data, _, err := h.supabase.From("specialties").
+		Insert(specialtyData, false, "", "", "").
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &created)
+	}
+
+	if err != nil || len(created) == 0 {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, models.Response{
+				Success: false,
+				Error:   "A specialty with this name already exists",
+			})
+			return
+		}
+		fmt.Printf("[CreateSpecialty] Insert error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to create specialty",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.Response{
+		Success: true,
+		Message: "Specialty created successfully",
+		Data:    created[0],
+	})
+}
+
+// UpdateSpecialty renames or (de)activates a catalogue entry. Deactivated
+// specialties stay on existing doctors but cannot be newly assigned.
+func (h *NurseHandler) UpdateSpecialty(c *gin.Context) {
+	specialtyID := c.Param("id")
+
+	var req models.SpecialtyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	updateData := map[string]interface{}{
+		"updated_at": time.Now(),
+	}
+	if req.Name != nil {
+		if strings.TrimSpace(*req.Name) == "" {
+			c.JSON(http.StatusBadRequest, models.Response{
+				Success: false,
+				Error:   "name cannot be empty",
+			})
+			return
+		}
+		updateData["name"] = strings.TrimSpace(*req.Name)
+	}
+	if req.NameEn != nil {
+		updateData["name_en"] = *req.NameEn
+	}
+	if req.Description != nil {
+		updateData["description"] = *req.Description
+	}
+	if req.IsActive != nil {
+		updateData["is_active"] = *req.IsActive
+	}
+
+	var updated []models.Specialty
+	data, _, err := h.supabase.From("specialties").
+		Update(updateData, "", "").
+		Eq("id", specialtyID).
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &updated)
+	}
+
+	if err != nil || len(updated) == 0 {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, models.Response{
+				Success: false,
+				Error:   "A specialty with this name already exists",
+			})
+			return
+		}
+		c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Specialty not found or update failed",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Specialty updated successfully",
+		Data:    updated[0],
+	})
+}
+
+// userSortColumns lists the columns GetAllUsers may sort by.
+var userSortColumns = map[string]bool{
+	"created_at":   true,
+	"full_name":    true,
+	"phone":        true,
+	"company_name": true,
+	"employee_id":  true,
+	"role":         true,
+}
+
+// searchEscaper prepares q for a double-quoted value in a PostgREST or()
+// filter, where , . : ( ) are literal. Backslashes and quotes are escaped and
+// * is dropped so that q cannot add wildcards of its own.
+var searchEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "*", "")
+
+// GetAllUsers lists users with pagination. q searches phone, name, company
+// and employee ID; role, company_name and is_active narrow the result.
+// Callers without branch:any see customers and the staff of their branches.
+func (h *NurseHandler) GetAllUsers(c *gin.Context) {
+	branchIDs, ok := branchScope(c, "")
+	if !ok {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	sort := c.DefaultQuery("sort", "created_at")
+	if !userSortColumns[sort] {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid sort column",
+		})
+		return
+	}
+	ascending := c.Query("order") == "asc"
+
+	var query *postgrest.FilterBuilder
+	if branchIDs == nil {
+		query = h.supabase.From("users").
+			Select("*", "exact", false)
+	} else {
+		query = h.supabase.From("users").
+			Select("*, scope:user_branches(branch_id)", "exact", false).
+			In("scope.branch_id", branchIDs).
+			Or(fmt.Sprintf("role.eq.%s,scope.not.is.null", models.RoleCustomer), "")
+	}
+
+	if q := strings.TrimSpace(searchEscaper.Replace(c.Query("q"))); q != "" {
+		pattern := `"*` + q + `*"`
+		query = query.Or(fmt.Sprintf("phone.ilike.%[1]s,full_name.ilike.%[1]s,company_name.ilike.%[1]s,employee_id.ilike.%[1]s", pattern), "")
+	}
+	if role := c.Query("role"); role != "" {
+		query = query.Eq("role", role)
+	}
+	if company := c.Query("company_name"); company != "" {
+		query = query.Eq("company_name", company)
+	}
+	if isActive := c.Query("is_active"); isActive == "true" || isActive == "false" {
+		query = query.Eq("is_active", isActive)
+	}
+
+	offset := (page - 1) * limit
+	query = query.
+		Order(sort, &postgrest.OrderOpts{Ascending: ascending}).
+		Range(offset, offset+limit-1, "")
+
+	var users []models.User
+	data, total, err := query.Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &users)
+	}
+
+	if err != nil {
+		fmt.Printf("[GetAllUsers] Query error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch users",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.PaginatedResponse{
+		Success: true,
+		Data:    users,
+		Pagination: models.Pagination{
+			Page:       page,
+			Limit:      limit,
+			TotalPages: int((total + int64(limit) - 1) / int64(limit)),
+			TotalItems: int(total),
+		},
+	})
+}
+
+// CreateUser registers a user on someone's behalf. Accounts with any role
+// other than customer need user:write:staff and at least one branch.
+func (h *NurseHandler) CreateUser(c *gin.Context) {
+	var req models.CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	newRole := models.RoleCustomer
+	if req.Role != nil && *req.Role != "" {
+		newRole = *req.Role
+	}
+	if newRole != models.RoleCustomer && !middleware.HasPermission(c, models.PermUserWriteStaff) {
+		c.JSON(http.StatusForbidden, models.Response{
+			Success: false,
+			Error:   "Not allowed to create staff accounts",
+		})
+		return
+	}
+	if !h.validRole(c, newRole) {
+		return
+	}
+	if newRole == models.RoleCustomer && len(req.BranchIDs) > 0 {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Customers are not assigned to branches",
+		})
+		return
+	}
+	if newRole != models.RoleCustomer && !h.validBranches(c, req.BranchIDs) {
+		return
+	}
+
+	if taken, err := h.phoneTaken(req.Phone, ""); err != nil || taken {
+		if err == nil {
+			c.JSON(http.StatusConflict, models.Response{
+				Success: false,
+				Error:   "Phone number already registered",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to create user",
+		})
+		return
+	}
+
+	newUser := map[string]interface{}{
+		"id":        uuid.New().String(),
+		"phone":     req.Phone,
+		"full_name": req.FullName,
+		"role":      newRole,
+		"is_active": true,
+		"gender":    normaliseGender(req.Gender),
+	}
+
+	addString := func(key string, val *string) {
+		if val != nil && *val != "" {
+			newUser[key] = *val
+		}
+	}
+
+	addString("birth_date", req.BirthDate)
+	addString("email", req.Email)
+	addString("address", req.Address)
+	addString("blood_type", req.BloodType)
+	addString("company_id", req.CompanyID)
+	addString("company_name", req.CompanyName)
+	addString("employee_id", req.EmployeeID)
+	addString("department", req.Department)
+	addString("job_title", req.JobTitle)
+
+	if req.Age != nil {
+		newUser["age"] = *req.Age
+	}
+
+	var created []models.User
+	data, _, err := h.supabase.From("users").
+		Insert(newUser, false, "", "", "").
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &created)
+	}
+
+	if err != nil || len(created) == 0 {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, models.Response{
+				Success: false,
+				Error:   "Phone number already registered",
+			})
+			return
+		}
+		fmt.Printf("[CreateUser] Insert error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to create user",
+		})
+		return
+	}
+
+	if newRole != models.RoleCustomer {
+		if err := h.setUserBranches(created[0].ID, req.BranchIDs); err != nil {
+			fmt.Printf("[CreateUser] Branch assignment error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, models.Response{
+				Success: false,
+				Error:   "User created but branch assignment failed",
+			})
+			return
+		}
+		created[0].BranchIDs = uniqueSorted(req.BranchIDs)
+	}
+
+	c.JSON(http.StatusCreated, models.Response{
+		Success: true,
+		Message: "User created successfully",
+		Data:    created[0],
+	})
+}
+
+// UpdateUser edits a user's profile. Editing anyone but a customer needs
+// user:write:staff and role changes need user:role:assign; staff cannot
+// change their own role or deactivate themselves.
+// Setting is_active=false blocks further OTP logins and ends the user's
+// sessions. New branch_ids apply from the user's next token refresh.
+func (h *NurseHandler) UpdateUser(c *gin.Context) {
+	targetID := c.Param("id")
+	userID, _ := c.Get("user_id")
+
+	var req models.UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	var targets []struct {
+		models.User
+		Branches []userBranchRow `json:"user_branches"`
+	}
+	data, _, err := h.supabase.From("users").
+		Select("id, role, user_branches(branch_id)", "", false).
+		Eq("id", targetID).
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &targets)
+	}
+	// Users outside the caller's branches are reported as missing rather
+	// than forbidden, as GetAllUsers doesn't list them either.
+	if err != nil || len(targets) == 0 || !userInBranchScope(c, targets[0].Role, targets[0].Branches) {
+		c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "User not found",
+		})
+		return
+	}
+	target := targets[0].User
+
+	if target.Role != models.RoleCustomer && !middleware.HasPermission(c, models.PermUserWriteStaff) {
+		c.JSON(http.StatusForbidden, models.Response{
+			Success: false,
+			Error:   "Not allowed to edit staff accounts",
+		})
+		return
+	}
+
+	if req.Role != nil && *req.Role != target.Role {
+		if !middleware.HasPermission(c, models.PermUserRoleAssign) {
+			c.JSON(http.StatusForbidden, models.Response{
+				Success: false,
+				Error:   "Not allowed to change user roles",
+			})
+			return
+		}
+		if !h.validRole(c, *req.Role) {
+			return
+		}
+	}
+
+	if req.BranchIDs != nil {
+		if !middleware.HasPermission(c, models.PermUserWriteStaff) {
+			c.JSON(http.StatusForbidden, models.Response{
+				Success: false,
+				Error:   "Not allowed to assign branches",
+			})
+			return
+		}
+		if !h.validBranches(c, *req.BranchIDs) {
+			return
+		}
+	}
+
+	roleChanged := req.Role != nil && *req.Role != target.Role
+	deactivated := req.IsActive != nil && !*req.IsActive
+	if targetID == userID.(string) && (roleChanged || deactivated) {
+		c.JSON(http.StatusUnprocessableEntity, models.Response{
+			Success: false,
+			Error:   "You cannot change your own role or deactivate your own account",
+		})
+		return
+	}
+
+	if req.Phone != nil {
+		if *req.Phone == "" {
+			c.JSON(http.StatusBadRequest, models.Response{
+				Success: false,
+				Error:   "phone cannot be empty",
+			})
+			return
+		}
+		if taken, err := h.phoneTaken(*req.Phone, targetID); err == nil && taken {
+			c.JSON(http.StatusConflict, models.Response{
+				Success: false,
+				Error:   "Phone number already registered",
+			})
+			return
+		}
+	}
+
+	updateData := map[string]interface{}{
+		"updated_at": time.Now(),
+	}
+
+	setString := func(key string, val *string) {
+		if val != nil {
+			updateData[key] = *val
+		}
+	}
+
+	setString("phone", req.Phone)
+	setString("full_name", req.FullName)
+	setString("birth_date", req.BirthDate)
+	setString("email", req.Email)
+	setString("address", req.Address)
+	setString("blood_type", req.BloodType)
+	setString("company_id", req.CompanyID)
+	setString("company_name", req.CompanyName)
+	setString("employee_id", req.EmployeeID)
+	setString("department", req.Department)
+	setString("job_title", req.JobTitle)
+	setString("role", req.Role)
+	setString("preferred_language", req.PreferredLanguage)
+
+	if req.Gender != nil {
+		updateData["gender"] = normaliseGender(req.Gender)
+	}
+	if req.Age != nil {
+		updateData["age"] = *req.Age
+	}
+	if req.IsActive != nil {
+		updateData["is_active"] = *req.IsActive
+	}
+
+	var updated []models.User
+	data, _, err = h.supabase.From("users").
+		Update(updateData, "", "").
+		Eq("id", targetID).
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &updated)
+	}
+
+	if err != nil || len(updated) == 0 {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, models.Response{
+				Success: false,
+				Error:   "Phone number already registered",
+			})
+			return
+		}
+		fmt.Printf("[UpdateUser] Update error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to update user",
+		})
+		return
+	}
+
+	if req.BranchIDs != nil {
+		if err := h.setUserBranches(targetID, *req.BranchIDs); err != nil {
+			fmt.Printf("[UpdateUser] Branch assignment error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, models.Response{
+				Success: false,
+				Error:   "Failed to update branch assignments",
+			})
+			return
+		}
+		updated[0].BranchIDs = uniqueSorted(*req.BranchIDs)
+	}
+
+	// Access tokens carry the role and stay valid while their session is, so
+	// end the user's sessions; they get the new role when they log in again.
+	if roleChanged || deactivated {
+		reason := "role_changed"
+		if deactivated {
+			reason = "user_deactivated"
+		}
+		if err := h.sessions.RevokeUser(targetID, reason); err != nil {
+			fmt.Printf("[UpdateUser] Failed to revoke sessions of %s: %v\n", targetID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "User updated successfully",
+		Data:    updated[0],
+	})
+}
+
+// userBranchRow is a user_branches row embedded in a users query.
+type userBranchRow struct {
+	BranchID string `json:"branch_id"`
+}
+
+// userInBranchScope reports whether the caller may see a user with role and
+// branches. Customers belong to no branch and are seen by everyone; staff
+// only by callers sharing one of their branches.
+func userInBranchScope(c *gin.Context, role string, branches []userBranchRow) bool {
+	if _, all := middleware.BranchScope(c); all || role == models.RoleCustomer {
+		return true
+	}
+	for _, branch := range branches {
+		if middleware.InBranchScope(c, branch.BranchID) {
+			return true
+		}
+	}
+	return false
+}
+
+// validBranches checks a staff member's branch assignments: at least one
+// existing branch, all of them branches the caller works at. It writes a 4xx
+// response and reports false otherwise.
+func (h *NurseHandler) validBranches(c *gin.Context, branchIDs []string) bool {
+	if len(branchIDs) == 0 {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Staff accounts need at least one branch_id",
+		})
+		return false
+	}
+	for _, id := range branchIDs {
+		if !middleware.InBranchScope(c, id) {
+			respondBranchForbidden(c)
+			return false
+		}
+	}
+
+	unique := uniqueSorted(branchIDs)
+	var branches []models.Branch
+	data, _, err := h.supabase.From("branches").
+		Select("id", "", false).
+		In("id", unique).
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &branches)
+	}
+	if err != nil {
+		fmt.Printf("[Branches] Lookup error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to check branches",
+		})
+		return false
+	}
+	if len(branches) != len(unique) {
+		c.JSON(http.StatusUnprocessableEntity, models.Response{
+			Success: false,
+			Error:   "Branch not found",
+		})
+		return false
+	}
+	return true
+}
+
+// setUserBranches replaces the branches a staff member is assigned to.
+func (h *NurseHandler) setUserBranches(userID string, branchIDs []string) error {
+	params := map[string]interface{}{
+		"p_user_id":    userID,
+		"p_branch_ids": uniqueSorted(branchIDs),
+	}
+	return callRPC(h.supabase, "set_user_branches", params, nil)
+}
+
+// phoneTaken reports whether another user (other than exceptID) already uses
+// the phone number.
+func (h *NurseHandler) phoneTaken(phone, exceptID string) (bool, error) {
+	query := h.supabase.From("users").
+		Select("id", "", false).
+		Eq("phone", phone)
+	if exceptID != "" {
+		query = query.Neq("id", exceptID)
+	}
+
+	var users []models.User
+	data, _, err := query.Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &users)
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(users) > 0, nil
+}
+
+// normaliseGender maps free text to the values accepted by users.gender,
+// matching registration.
+func normaliseGender(gender *string) string {
+	if gender != nil {
+		g := strings.ToLower(strings.TrimSpace(*gender))
+		if g == "male" || g == "female" || g == "other" {
+			return g
+		}
+	}
+	return "other"
+}
+
+// validRole checks that role is defined in the roles table. It writes the
+// error response itself when it returns false.
+func (h *NurseHandler) validRole(c *gin.Context, role string) bool {
+	exists, err := h.permissions.RoleExists(role)
+	if err != nil {
+		fmt.Printf("[Users] Role lookup error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to check role",
+		})
+		return false
+	}
+	if !exists {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid role",
+		})
+		return false
+	}
+	return true
 }
@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sittawut/backend-appointment/config"
+	"github.com/sittawut/backend-appointment/models"
+	"github.com/sittawut/backend-appointment/services"
+	supa "github.com/supabase-community/supabase-go"
+)
+
+// OIDCAuthHandler logs staff in through the configured OpenID Connect
+// providers, Azure AD among them.
+type OIDCAuthHandler struct {
+	supabase  *supa.Client
+	config    *config.Config
+	sessions  *services.SessionService
+	providers *services.OIDCProviders
+	cookieKey []byte
+	usedFlows *usedFlows
+}
+
+func NewOIDCAuthHandler(supabase *supa.Client, cfg *config.Config, sessions *services.SessionService, providers *services.OIDCProviders) *OIDCAuthHandler {
+	cookieKey := []byte(cfg.AuthCookieSecret)
+	if len(cookieKey) == 0 {
+		// Logins started on another instance or before a restart will fail.
+		fmt.Println("[OIDC] Warning: AUTH_COOKIE_SECRET not set, using a random per-process key")
+		cookieKey = make([]byte, 32)
+		_, _ = rand.Read(cookieKey)
+	}
+	return &OIDCAuthHandler{
+		supabase:  supabase,
+		config:    cfg,
+		sessions:  sessions,
+		providers: providers,
+		cookieKey: cookieKey,
+		usedFlows: newUsedFlows(),
+	}
+}
+
+// OIDCTokenRequest carries tokens a frontend received from the provider
+// itself. The identity comes from IDToken, or from AccessToken when it was
+// issued for this app. An AccessToken alongside an IDToken is only used to
+// read the user's profile, e.g. from Microsoft Graph.
+type OIDCTokenRequest struct {
+	IDToken     string `json:"id_token"`
+	AccessToken string `json:"access_token"`
+}
+
+// UseOIDCProvider fixes the :provider route parameter, for routes such as
+// /auth/azure/* that serve a single provider.
+func UseOIDCProvider(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Params = append(c.Params, gin.Param{Key: "provider", Value: name})
+		c.Next()
+	}
+}
+
+// GetProviders lists the providers staff can log in with.
+func (h *OIDCAuthHandler) GetProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    h.providers.Names(),
+	})
+}
+
+// Login starts the authorization code flow: it stores a fresh state, nonce
+// and PKCE verifier in a signed cookie and redirects to the provider. After
+// login the callback redirects to return_to when it is a relative path or
+// an allowed origin, otherwise it answers with JSON.
+func (h *OIDCAuthHandler) Login(c *gin.Context) {
+	provider, ok := h.provider(c)
+	if !ok {
+		return
+	}
+
+	returnTo := c.Query("return_to")
+	if returnTo != "" && !h.allowedReturnTo(returnTo) {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "return_to is not an allowed URL",
+		})
+		return
+	}
+
+	flow := oidcFlow{Provider: provider.Name(), ReturnTo: returnTo, ExpiresAt: time.Now().Add(oidcFlowTTL).Unix()}
+	for _, field := range []*string{&flow.State, &flow.Nonce, &flow.CodeVerifier} {
+		value, err := randomURLToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.Response{
+				Success: false,
+				Error:   "Failed to start login",
+			})
+			return
+		}
+		*field = value
+	}
+
+	authURL, err := provider.AuthCodeURL(flow.State, flow.Nonce, flow.CodeVerifier)
+	if err != nil {
+		fmt.Printf("[OIDC] %s discovery error: %v\n", provider.Name(), err)
+		c.JSON(http.StatusBadGateway, models.Response{
+			Success: false,
+			Error:   "Identity provider is unavailable",
+		})
+		return
+	}
+
+	cookie, err := h.sealFlow(flow)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to start login",
+		})
+		return
+	}
+	h.setFlowCookie(c, cookie, int(oidcFlowTTL.Seconds()))
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback completes the flow started by Login. The state must match the
+// flow cookie, the code is exchanged with the PKCE verifier and the ID token
+// must carry the flow's nonce.
+func (h *OIDCAuthHandler) Callback(c *gin.Context) {
+	provider, ok := h.provider(c)
+	if !ok {
+		return
+	}
+
+	if idpErr := c.Query("error"); idpErr != "" {
+		fmt.Printf("[OIDC] %s authorization error: %s: %s\n", provider.Name(), idpErr, c.Query("error_description"))
+		h.setFlowCookie(c, "", -1)
+		c.JSON(http.StatusUnauthorized, models.Response{
+			Success: false,
+			Error:   "Login was cancelled or refused",
+		})
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Missing authorization code",
+		})
+		return
+	}
+
+	flow, err := h.consumeFlow(c, provider.Name())
+	if err != nil {
+		fmt.Printf("[OIDC] %s callback rejected: %v\n", provider.Name(), err)
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Login session is invalid or expired, please start again",
+		})
+		return
+	}
+
+	tokens, err := provider.Exchange(code, flow.CodeVerifier)
+	if err != nil {
+		fmt.Printf("[OIDC] %s token exchange error: %v\n", provider.Name(), err)
+		c.JSON(http.StatusBadGateway, models.Response{
+			Success: false,
+			Error:   "Failed to authenticate with the identity provider",
+		})
+		return
+	}
+
+	identity, ok := h.verifyIdentity(c, provider, tokens.IDToken, tokens.AccessToken, flow.Nonce)
+	if !ok {
+		return
+	}
+	login, ok := h.loginStaff(c, provider, identity)
+	if !ok {
+		return
+	}
+
+	// Set HttpOnly cookies
+	domain, secure := cookieDomain(c)
+	setSessionCookies(c, h.config, h.sessions, login, domain, secure)
+
+	if flow.ReturnTo != "" {
+		c.Redirect(http.StatusFound, flow.ReturnTo)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Login successful",
+		Data:    login,
+	})
+}
+
+// CreateToken logs a staff member in with tokens a frontend obtained from
+// the provider, e.g. the NextJS Azure AD callback. Nothing in the request is
+// trusted until the token has been verified against the provider's keys.
+func (h *OIDCAuthHandler) CreateToken(c *gin.Context) {
+	provider, ok := h.provider(c)
+	if !ok {
+		return
+	}
+
+	var req OIDCTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.IDToken == "" && req.AccessToken == "") {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "id_token or access_token is required",
+		})
+		return
+	}
+
+	token, profileToken := req.IDToken, req.AccessToken
+	if token == "" {
+		token, profileToken = req.AccessToken, ""
+	}
+
+	identity, ok := h.verifyIdentity(c, provider, token, profileToken, "")
+	if !ok {
+		return
+	}
+	login, ok := h.loginStaff(c, provider, identity)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Token created successfully",
+		Data:    login,
+	})
+}
+
+// provider resolves the :provider route parameter. It writes the error
+// response itself when ok is false.
+func (h *OIDCAuthHandler) provider(c *gin.Context) (*services.OIDCProvider, bool) {
+	provider, ok := h.providers.Get(c.Param("provider"))
+	if !ok {
+		c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Login provider is not configured",
+		})
+		return nil, false
+	}
+	return provider, true
+}
+
+// verifyIdentity verifies token and, with a profileToken, loads the user's
+// profile. A non-empty nonce must match the token's. It writes the error
+// response itself when ok is false.
+func (h *OIDCAuthHandler) verifyIdentity(c *gin.Context, provider *services.OIDCProvider, token, profileToken, nonce string) (*services.OIDCIdentity, bool) {
+	identity, err := provider.Verify(token, nonce)
+	if err != nil {
+		fmt.Printf("[OIDC] %s token verification failed: %v\n", provider.Name(), err)
+		if errors.Is(err, services.ErrOIDCTokenInvalid) {
+			c.JSON(http.StatusUnauthorized, models.Response{
+				Success: false,
+				Error:   "Invalid identity token",
+			})
+		} else {
+			c.JSON(http.StatusBadGateway, models.Response{
+				Success: false,
+				Error:   "Unable to verify identity token",
+			})
+		}
+		return nil, false
+	}
+
+	if profileToken != "" {
+		if err := provider.LoadProfile(identity, profileToken); err != nil {
+			fmt.Printf("[OIDC] %s profile error: %v\n", provider.Name(), err)
+			if errors.Is(err, services.ErrOIDCTokenInvalid) {
+				c.JSON(http.StatusUnauthorized, models.Response{
+					Success: false,
+					Error:   "Identity tokens belong to different users",
+				})
+				return nil, false
+			}
+			// The verified token is enough to log in; the profile is optional.
+		}
+	}
+	return identity, true
+}
+
+// loginStaff upserts the users row for a verified identity and starts its
+// session. The access token's Provider claim is the provider's name. It
+// writes the error response itself when ok is false.
+func (h *OIDCAuthHandler) loginStaff(c *gin.Context, provider *services.OIDCProvider, identity *services.OIDCIdentity) (*models.LoginResponse, bool) {
+	role, ok := provider.Role(identity)
+	if !ok {
+		fmt.Printf("[OIDC] No role mapped for %s:%s (claims=%v)\n", provider.Name(), identity.Subject, identity.RoleValues)
+		c.JSON(http.StatusForbidden, models.Response{
+			Success: false,
+			Error:   "Your account has no role in this application",
+		})
+		return nil, false
+	}
+
+	params := map[string]interface{}{
+		"p_provider":    provider.Name(),
+		"p_subject":     identity.Subject,
+		"p_full_name":   identity.FullName(),
+		"p_role":        role,
+		"p_email":       nullableString(identity.Email),
+		"p_phone":       nullableString(identity.Phone),
+		"p_employee_id": nullableString(identity.EmployeeID),
+		"p_department":  nullableString(identity.Department),
+		"p_job_title":   nullableString(identity.JobTitle),
+	}
+
+	var user models.User
+	if err := callRPC(h.supabase, "upsert_oidc_staff", params, &user); err != nil {
+		fmt.Printf("[OIDC] Upsert staff error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to save staff account",
+		})
+		return nil, false
+	}
+	if !user.IsActive {
+		c.JSON(http.StatusForbidden, models.Response{
+			Success: false,
+			Error:   "Account is deactivated",
+		})
+		return nil, false
+	}
+
+	login, err := startSession(c, h.supabase, h.config, h.sessions, &user, provider.Name())
+	if err != nil {
+		fmt.Printf("[OIDC] Session error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to generate token",
+		})
+		return nil, false
+	}
+	return login, true
+}
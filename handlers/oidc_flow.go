@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// oidcFlowCookie holds the state, nonce and PKCE verifier between an
+	// OIDC login and its callback.
+	oidcFlowCookie     = "oidc_login"
+	oidcFlowCookiePath = "/api/v1/auth"
+	oidcFlowTTL        = 10 * time.Minute
+)
+
+// oidcFlow is an authorization request in progress.
+type oidcFlow struct {
+	Provider     string `json:"p"`
+	State        string `json:"s"`
+	Nonce        string `json:"n"`
+	CodeVerifier string `json:"v"`
+	ReturnTo     string `json:"r,omitempty"`
+	ExpiresAt    int64  `json:"e"`
+}
+
+// consumeFlow reads and clears the flow cookie and checks it against the
+// callback's provider and state. The cookie is cleared whatever the outcome,
+// so each flow can complete once.
+func (h *OIDCAuthHandler) consumeFlow(c *gin.Context, provider string) (*oidcFlow, error) {
+	cookie, err := c.Cookie(oidcFlowCookie)
+	if err != nil || cookie == "" {
+		return nil, errors.New("login session not found")
+	}
+	h.setFlowCookie(c, "", -1)
+
+	flow, err := h.openFlow(cookie)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > flow.ExpiresAt {
+		return nil, errors.New("login session expired")
+	}
+	if flow.Provider != provider {
+		return nil, errors.New("login session belongs to another provider")
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Query("state")), []byte(flow.State)) != 1 {
+		return nil, errors.New("state mismatch")
+	}
+	if !h.usedFlows.claim(flow.State, flow.ExpiresAt) {
+		return nil, errors.New("login session already used")
+	}
+	return flow, nil
+}
+
+// usedFlows remembers the state of every flow that reached the callback
+// until the flow expires, so a copy of the cookie cannot be replayed. It is
+// per instance: a replay sent to another replica is still stopped by the
+// provider, which redeems each code once.
+type usedFlows struct {
+	mu     sync.Mutex
+	states map[string]int64
+}
+
+func newUsedFlows() *usedFlows {
+	return &usedFlows{states: map[string]int64{}}
+}
+
+// claim records state and reports whether it was unused.
+func (u *usedFlows) claim(state string, expiresAt int64) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now().Unix()
+	for s, exp := range u.states {
+		if now > exp {
+			delete(u.states, s)
+		}
+	}
+	if _, used := u.states[state]; used {
+		return false
+	}
+	u.states[state] = expiresAt
+	return true
+}
+
+func (h *OIDCAuthHandler) setFlowCookie(c *gin.Context, value string, maxAge int) {
+	// Lax, not Strict: the callback arrives as a top-level redirect from
+	// the provider.
+	c.SetSameSite(http.SameSiteLaxMode)
+	_, secure := cookieDomain(c)
+	c.SetCookie(oidcFlowCookie, value, maxAge, oidcFlowCookiePath, "", secure, true)
+}
+
+// sealFlow encodes flow as base64url(JSON) "." base64url(HMAC-SHA256).
+func (h *OIDCAuthHandler) sealFlow(flow oidcFlow) (string, error) {
+	data, err := json.Marshal(flow)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + h.flowSignature(payload), nil
+}
+
+func (h *OIDCAuthHandler) openFlow(cookie string) (*oidcFlow, error) {
+	payload, signature, ok := strings.Cut(cookie, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(h.flowSignature(payload))) {
+		return nil, errors.New("login session signature is invalid")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errors.New("login session is malformed")
+	}
+	var flow oidcFlow
+	if err := json.Unmarshal(data, &flow); err != nil {
+		return nil, errors.New("login session is malformed")
+	}
+	return &flow, nil
+}
+
+func (h *OIDCAuthHandler) flowSignature(payload string) string {
+	mac := hmac.New(sha256.New, h.cookieKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// allowedReturnTo accepts a same-site path or a URL on one of the CORS
+// allowed origins, so the callback cannot be used as an open redirect.
+func (h *OIDCAuthHandler) allowedReturnTo(returnTo string) bool {
+	if strings.HasPrefix(returnTo, "/") && !strings.HasPrefix(returnTo, "//") && !strings.Contains(returnTo, "\\") {
+		return true
+	}
+	u, err := url.Parse(returnTo)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+	origin := u.Scheme + "://" + u.Host
+	for _, allowed := range h.config.AllowedOrigins {
+		if strings.TrimRight(strings.TrimSpace(allowed), "/") == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// randomURLToken returns 32 random bytes, base64url encoded. As a PKCE
+// verifier that is 43 characters, the minimum RFC 7636 allows.
+func randomURLToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
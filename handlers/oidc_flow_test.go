@@ -0,0 +1,463 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sittawut/backend-appointment/config"
+	"github.com/sittawut/backend-appointment/services"
+	supa "github.com/supabase-community/supabase-go"
+)
+
+const (
+	testOIDCClientID    = "staff-portal"
+	testOIDCRedirectURI = "http://localhost:8080/api/v1/auth/oidc/azure/callback"
+)
+
+// issuedCode is an authorization code the fake IdP handed out, bound to the
+// PKCE challenge and nonce of its authorization request.
+type issuedCode struct {
+	challenge string
+	nonce     string
+}
+
+// fakeOIDCProvider is an httptest identity provider with discovery, JWKS and
+// a token endpoint that enforces PKCE and redeems each code once.
+type fakeOIDCProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu          sync.Mutex
+	codes       map[string]issuedCode
+	tokenErrors int
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeOIDCProvider{key: key, codes: map[string]issuedCode{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(services.JWKSet{Keys: []services.JWK{{
+			KeyType:   "RSA",
+			KeyID:     "idp-key",
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         b64(key.N.Bytes()),
+			E:         b64(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// authorize plays the user signing in at the provider: it issues a code for
+// the authorization request in authURL. nonce, when set, replaces the
+// request's nonce in the ID token.
+func (idp *fakeOIDCProvider) authorize(t *testing.T, authURL, nonce string) (code, state string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if !strings.HasPrefix(authURL, idp.URL+"/authorize?") || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+	if nonce == "" {
+		nonce = query.Get("nonce")
+	}
+
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	code = "code-" + query.Get("state")[:8]
+	idp.codes[code] = issuedCode{challenge: query.Get("code_challenge"), nonce: nonce}
+	return code, query.Get("state")
+}
+
+func (idp *fakeOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	fail := func(description string) {
+		idp.tokenErrors++
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": description})
+	}
+
+	if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("client_id") != testOIDCClientID || r.PostFormValue("redirect_uri") != testOIDCRedirectURI {
+		fail("bad client or redirect")
+		return
+	}
+	issued, ok := idp.codes[r.PostFormValue("code")]
+	if !ok {
+		fail("unknown or redeemed code")
+		return
+	}
+	delete(idp.codes, r.PostFormValue("code"))
+
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(verifier[:]) != issued.challenge {
+		fail("PKCE verification failed")
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":    idp.URL,
+		"aud":    testOIDCClientID,
+		"sub":    "staff-subject",
+		"nonce":  issued.nonce,
+		"name":   "Nurse Joy",
+		"email":  "joy@example.com",
+		"groups": []string{"nurses"},
+		"iat":    now.Unix(),
+		"exp":    now.Add(time.Hour).Unix(),
+	})
+	idToken.Header["kid"] = "idp-key"
+	signed, err := idToken.SignedString(idp.key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"token_type": "Bearer", "expires_in": 3600, "id_token": signed})
+}
+
+// fakeStaffStore stands in for PostgREST: it upserts the staff member,
+// lists their branches and stores sessions.
+type fakeStaffStore struct {
+	mu       sync.Mutex
+	sessions int
+}
+
+func (s *fakeStaffStore) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/rest/v1/rpc/upsert_oidc_staff":
+		io.WriteString(w, `{"id":"staff-1","phone":"","full_name":"Nurse Joy","role":"nurse","is_active":true}`)
+	case r.Method == http.MethodGet && r.URL.Path == "/rest/v1/user_branches":
+		io.WriteString(w, `[{"branch_id":"branch-1"}]`)
+	case r.Method == http.MethodPost && r.URL.Path == "/rest/v1/sessions":
+		var row map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&row)
+		s.sessions++
+		row["id"] = "session-1"
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode([]map[string]interface{}{row})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"code":"PGRST205","message":"unexpected request"}`)
+	}
+}
+
+func (s *fakeStaffStore) sessionCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions
+}
+
+type oidcFlowTest struct {
+	idp     *fakeOIDCProvider
+	store   *fakeStaffStore
+	handler *OIDCAuthHandler
+	router  *gin.Engine
+}
+
+func newOIDCFlowTest(t *testing.T) *oidcFlowTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	idp := newFakeOIDCProvider(t)
+	store := &fakeStaffStore{}
+	db := httptest.NewServer(http.HandlerFunc(store.serve))
+	t.Cleanup(db.Close)
+	client, err := supa.NewClient(db.URL, "service-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		AllowedOrigins:   []string{"http://localhost:3000"},
+		AuthCookieSecret: "cookie-secret",
+		AccessTokenTTL:   15 * time.Minute,
+	}
+	keys, err := services.LoadTokenKeys("", nil, "jwt-secret", "backend-appointment", "backend-appointment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := services.NewSessionService(client, keys, services.SessionOptions{})
+	providers, err := services.NewOIDCProviders([]config.OIDCProvider{{
+		Name:         "azure",
+		DiscoveryURL: idp.URL + "/.well-known/openid-configuration",
+		ClientID:     testOIDCClientID,
+		RedirectURI:  testOIDCRedirectURI,
+		Scopes:       []string{"openid", "profile", "email"},
+		ClaimMap:     map[string]string{"name": "name", "email": "email"},
+		RoleClaims:   []string{"groups"},
+		RoleMap:      map[string]string{"nurses": "nurse"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewOIDCAuthHandler(client, cfg, sessions, providers)
+	router := gin.New()
+	router.GET("/api/v1/auth/oidc/:provider/login", handler.Login)
+	router.GET("/api/v1/auth/oidc/:provider/callback", handler.Callback)
+	return &oidcFlowTest{idp: idp, store: store, handler: handler, router: router}
+}
+
+func (f *oidcFlowTest) get(target string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Host = "localhost:8080"
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+// login starts a flow and returns the provider URL and the flow cookie.
+func (f *oidcFlowTest) login(t *testing.T, query string) (string, *http.Cookie) {
+	t.Helper()
+	w := f.get("/api/v1/auth/oidc/azure/login"+query, nil)
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status = %d, body %s", w.Code, w.Body)
+	}
+	cookie := responseCookie(w, oidcFlowCookie)
+	if cookie == nil || cookie.Value == "" || cookie.Path != oidcFlowCookiePath || !cookie.HttpOnly {
+		t.Fatalf("flow cookie = %+v", cookie)
+	}
+	return w.Header().Get("Location"), cookie
+}
+
+func (f *oidcFlowTest) callback(code, state string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	return f.get("/api/v1/auth/oidc/azure/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), cookie)
+}
+
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestOIDCLoginCallback(t *testing.T) {
+	f := newOIDCFlowTest(t)
+
+	authURL, cookie := f.login(t, "?return_to=/staff")
+	code, state := f.idp.authorize(t, authURL, "")
+	w := f.callback(code, state, cookie)
+
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/staff" {
+		t.Fatalf("callback status = %d, location %q, body %s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+	if cleared := responseCookie(w, oidcFlowCookie); cleared == nil || cleared.MaxAge >= 0 {
+		t.Errorf("flow cookie not cleared: %+v", cleared)
+	}
+	token := responseCookie(w, "token")
+	if token == nil || token.Value == "" {
+		t.Fatal("no access token cookie")
+	}
+	claims, err := parseTestAccessToken(f.handler, token.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["user_id"] != "staff-1" || claims["role"] != "nurse" || claims["provider"] != "azure" || claims["sid"] != "session-1" {
+		t.Errorf("access token claims = %v", claims)
+	}
+	if f.store.sessionCount() != 1 {
+		t.Errorf("sessions created = %d, want 1", f.store.sessionCount())
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		// run drives the flow and returns the callback response.
+		run          func(t *testing.T, f *oidcFlowTest) *httptest.ResponseRecorder
+		wantStatus   int
+		wantSessions int
+	}{
+		{
+			name: "state mismatch",
+			run: func(t *testing.T, f *oidcFlowTest) *httptest.ResponseRecorder {
+				authURL, cookie := f.login(t, "")
+				code, _ := f.idp.authorize(t, authURL, "")
+				return f.callback(code, "forged-state", cookie)
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "missing cookie",
+			run: func(t *testing.T, f *oidcFlowTest) *httptest.ResponseRecorder {
+				authURL, _ := f.login(t, "")
+				code, state := f.idp.authorize(t, authURL, "")
+				return f.callback(code, state, nil)
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "tampered cookie",
+			run: func(t *testing.T, f *oidcFlowTest) *httptest.ResponseRecorder {
+				authURL, cookie := f.login(t, "")
+				code, state := f.idp.authorize(t, authURL, "")
+
+				flow, err := f.handler.openFlow(cookie.Value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				flow.ReturnTo = "https://evil.example.com"
+				data, _ := json.Marshal(flow)
+				_, signature, _ := strings.Cut(cookie.Value, ".")
+				cookie.Value = base64.RawURLEncoding.EncodeToString(data) + "." + signature
+				return f.callback(code, state, cookie)
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "expired cookie",
+			run: func(t *testing.T, f *oidcFlowTest) *httptest.ResponseRecorder {
+				authURL, cookie := f.login(t, "")
+				code, state := f.idp.authorize(t, authURL, "")
+
+				flow, err := f.handler.openFlow(cookie.Value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				flow.ExpiresAt = time.Now().Add(-time.Second).Unix()
+				if cookie.Value, err = f.handler.sealFlow(*flow); err != nil {
+					t.Fatal(err)
+				}
+				return f.callback(code, state, cookie)
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "cookie reused after callback",
+			run: func(t *testing.T, f *oidcFlowTest) *httptest.ResponseRecorder {
+				authURL, cookie := f.login(t, "")
+				code, state := f.idp.authorize(t, authURL, "")
+				if w := f.callback(code, state, cookie); w.Code != http.StatusOK {
+					t.Fatalf("first callback status = %d, body %s", w.Code, w.Body)
+				}
+
+				// Even with a fresh code for the same authorization
+				// request, the flow cannot be completed twice.
+				code, state = f.idp.authorize(t, authURL, "")
+				return f.callback(code, state, cookie)
+			},
+			wantStatus:   http.StatusBadRequest,
+			wantSessions: 1,
+		},
+		{
+			name: "code from another authorization request",
+			run: func(t *testing.T, f *oidcFlowTest) *httptest.ResponseRecorder {
+				// The attacker's code is bound to their own PKCE
+				// challenge, so the victim's verifier is refused at the
+				// token endpoint.
+				attackerURL, _ := f.login(t, "")
+				code, _ := f.idp.authorize(t, attackerURL, "")
+				authURL, cookie := f.login(t, "")
+				_, state := f.idp.authorize(t, authURL, "")
+				return f.callback(code, state, cookie)
+			},
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name: "nonce mismatch",
+			run: func(t *testing.T, f *oidcFlowTest) *httptest.ResponseRecorder {
+				authURL, cookie := f.login(t, "")
+				code, state := f.idp.authorize(t, authURL, "replayed-nonce")
+				return f.callback(code, state, cookie)
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOIDCFlowTest(t)
+			w := tt.run(t, f)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if token := responseCookie(w, "token"); token != nil && token.Value != "" {
+				t.Error("access token cookie set on a rejected callback")
+			}
+			if f.store.sessionCount() != tt.wantSessions {
+				t.Errorf("sessions created = %d, want %d", f.store.sessionCount(), tt.wantSessions)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackWrongVerifierRejectedAtTokenEndpoint(t *testing.T) {
+	f := newOIDCFlowTest(t)
+	authURL, cookie := f.login(t, "")
+	code, state := f.idp.authorize(t, authURL, "")
+
+	// Swap the verifier in a correctly signed cookie: the state still
+	// matches but the provider refuses the code.
+	flow, err := f.handler.openFlow(cookie.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow.CodeVerifier = strings.Repeat("a", 43)
+	if cookie.Value, err = f.handler.sealFlow(*flow); err != nil {
+		t.Fatal(err)
+	}
+
+	w := f.callback(code, state, cookie)
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502, body %s", w.Code, w.Body)
+	}
+	if f.idp.tokenErrors != 1 {
+		t.Errorf("token endpoint errors = %d, want 1", f.idp.tokenErrors)
+	}
+	if f.store.sessionCount() != 0 {
+		t.Error("session created with a refused code")
+	}
+}
+
+func parseTestAccessToken(h *OIDCAuthHandler, token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := h.sessions.Keys().Parse(token, claims)
+	return claims, err
+}
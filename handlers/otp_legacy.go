@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sittawut/backend-appointment/models"
+)
+
+// Legacy OTP routes (/auth/request-otp, /auth/verify-otp). They share
+// OTPService with /auth/otp/* and only keep their original request and
+// response shapes and cookie handling for clients that have not migrated.
+// Routes wraps them with middleware.Deprecated, or retires them when
+// LEGACY_OTP_ROUTES_ENABLED is false.
+
+// LegacyRequestOTP sends an OTP and answers with the legacy OTPResponse.
+func (h *OTPHandler) LegacyRequestOTP(c *gin.Context) {
+	var req models.RequestOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   fmt.Sprintf("Invalid request body: %v", err),
+		})
+		return
+	}
+
+	challenge, ok := h.requestOTP(c, req.Phone)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "OTP sent successfully",
+		Data: models.OTPResponse{
+			Message:   "OTP has been sent to your phone",
+			ExpiresAt: challenge.ExpiresAt,
+		},
+	})
+}
+
+// LegacyVerifyOTP verifies the OTP and sets the cross-domain token cookie the
+// legacy clients read.
+func (h *OTPHandler) LegacyVerifyOTP(c *gin.Context) {
+	var req models.VerifyOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   fmt.Sprintf("Invalid request body: %v", err),
+		})
+		return
+	}
+
+	login, ok := h.verifyOTP(c, req.Phone, req.OTPCode)
+	if !ok {
+		return
+	}
+
+	domain, secure := cookieDomain(c)
+	setSessionCookies(c, h.config, h.sessions, login, domain, secure)
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Login successful",
+		Data:    login,
+	})
+}
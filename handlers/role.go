@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sittawut/backend-appointment/models"
+	"github.com/sittawut/backend-appointment/services"
+	"github.com/supabase-community/postgrest-go"
+	supa "github.com/supabase-community/supabase-go"
+)
+
+// roleNamePattern matches the names the roles table accepts.
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,29}$`)
+
+// RoleHandler lets admins edit roles and the permissions they grant.
+type RoleHandler struct {
+	supabase    *supa.Client
+	permissions *services.PermissionService
+}
+
+func NewRoleHandler(supabase *supa.Client, permissions *services.PermissionService) *RoleHandler {
+	return &RoleHandler{
+		supabase:    supabase,
+		permissions: permissions,
+	}
+}
+
+// GetPermissions lists every permission a role can be granted.
+func (h *RoleHandler) GetPermissions(c *gin.Context) {
+	var permissions []models.Permission
+	data, _, err := h.supabase.From("permissions").
+		Select("name, description", "", false).
+		Order("name", &postgrest.OrderOpts{Ascending: true}).
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &permissions)
+	}
+	if err != nil {
+		fmt.Printf("[GetPermissions] Query error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch permissions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    permissions,
+	})
+}
+
+// GetRoles lists the roles with the permissions each one grants.
+func (h *RoleHandler) GetRoles(c *gin.Context) {
+	var rows []struct {
+		Name            string  `json:"name"`
+		Description     *string `json:"description"`
+		RolePermissions []struct {
+			Permission string `json:"permission"`
+		} `json:"role_permissions"`
+	}
+	data, _, err := h.supabase.From("roles").
+		Select("name, description, role_permissions(permission)", "", false).
+		Order("name", &postgrest.OrderOpts{Ascending: true}).
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &rows)
+	}
+	if err != nil {
+		fmt.Printf("[GetRoles] Query error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch roles",
+		})
+		return
+	}
+
+	roles := make([]models.Role, 0, len(rows))
+	for _, row := range rows {
+		role := models.Role{Name: row.Name, Description: row.Description, Permissions: []string{}}
+		for _, rp := range row.RolePermissions {
+			role.Permissions = append(role.Permissions, rp.Permission)
+		}
+		sort.Strings(role.Permissions)
+		roles = append(roles, role)
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    roles,
+	})
+}
+
+// SaveRole creates the role named in the URL or replaces its permissions.
+// The change applies to every user holding the role, on this instance at
+// once and on others within PERMISSION_CACHE_SECONDS.
+func (h *RoleHandler) SaveRole(c *gin.Context) {
+	name := c.Param("name")
+	if !roleNamePattern.MatchString(name) {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Role names are lower case letters, digits and underscores",
+		})
+		return
+	}
+
+	var req models.SaveRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	params := map[string]interface{}{
+		"p_name":        name,
+		"p_description": req.Description,
+		"p_permissions": req.Permissions,
+	}
+
+	var role models.Role
+	if err := callRPC(h.supabase, "save_role", params, &role); err != nil {
+		if rpcErr, ok := err.(*rpcError); ok {
+			switch rpcErr.Reason() {
+			case "unknown_permission":
+				c.JSON(http.StatusBadRequest, models.Response{
+					Success: false,
+					Error:   fmt.Sprintf("Unknown permission %s", rpcErr.Detail()),
+				})
+				return
+			case "admin_lockout":
+				c.JSON(http.StatusUnprocessableEntity, models.Response{
+					Success: false,
+					Error:   fmt.Sprintf("The admin role must keep %s", models.PermRoleManage),
+				})
+				return
+			}
+		}
+		fmt.Printf("[SaveRole] RPC error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to save role",
+		})
+		return
+	}
+	h.permissions.Invalidate()
+
+	role.Permissions = uniqueSorted(req.Permissions)
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Role saved successfully",
+		Data:    role,
+	})
+}
+
+// uniqueSorted returns values sorted without duplicates.
+func uniqueSorted(values []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sittawut/backend-appointment/models"
+	"github.com/sittawut/backend-appointment/rpc"
+	supa "github.com/supabase-community/supabase-go"
+)
+
+// rpcError is an exception raised inside a Postgres function.
+type rpcError = rpc.Error
+
+// callRPC invokes a Postgres function and decodes its JSON result into out.
+func callRPC(client *supa.Client, name string, params interface{}, out interface{}) error {
+	return rpc.Call(client, name, params, out)
+}
+
+// isUniqueViolation reports whether a PostgREST request failed on a unique
+// constraint.
+func isUniqueViolation(err error) bool {
+	return rpc.IsUniqueViolation(err)
+}
+
+// slotConflictMessages maps reservation failures raised by reserve_time_slot
+// to user facing messages.
+var slotConflictMessages = map[string]string{
+	"slot_not_found":       "Time slot not found",
+	"slot_full":            "Time slot is fully booked",
+	"slot_blocked":         "Time slot is not open for booking",
+	"slot_doctor_mismatch": "Time slot does not belong to the selected doctor",
+	"slot_date_mismatch":   "Time slot is not on the appointment date",
+	"slot_branch_mismatch": "Time slot is at a different branch from the booking",
+}
+
+// respondSlotConflict writes a 409 response when err is a slot reservation
+// failure and reports whether it did so.
+func respondSlotConflict(c *gin.Context, err error) bool {
+	rpcErr, ok := err.(*rpcError)
+	if !ok {
+		return false
+	}
+	message, ok := slotConflictMessages[rpcErr.Reason()]
+	if !ok {
+		return false
+	}
+
+	c.JSON(http.StatusConflict, models.Response{
+		Success: false,
+		Error:   message,
+		Data: gin.H{
+			"reason":       rpcErr.Reason(),
+			"time_slot_id": rpcErr.Detail(),
+		},
+	})
+	return true
+}
+
+// createBookingParams is the argument list of the create_booking function.
+type createBookingParams struct {
+	CustomerID      string                            `json:"p_customer_id"`
+	AppointmentDate string                            `json:"p_appointment_date"`
+	Status          string                            `json:"p_status"`
+	Notes           *string                           `json:"p_notes"`
+	CreatedBy       string                            `json:"p_created_by"`
+	Appointments    []models.CreateAppointmentRequest `json:"p_appointments"`
+	// BranchIDs limits the branch the booking may be made at; nil allows any.
+	BranchIDs []string `json:"p_branch_ids"`
+}
+
+// createBooking writes the booking, its appointments and the slot
+// reservations in one database transaction.
+func createBooking(client *supa.Client, params createBookingParams) (*models.Booking, error) {
+	var booking models.Booking
+	if err := callRPC(client, "create_booking", params, &booking); err != nil {
+		return nil, err
+	}
+	return &booking, nil
+}
+
+// bookingErrorResponses maps booking state errors raised by the cancellation
+// and update functions to HTTP responses.
+var bookingErrorResponses = map[string]struct {
+	status  int
+	message string
+}{
+	"booking_not_found":        {http.StatusNotFound, "Booking not found"},
+	"appointment_not_found":    {http.StatusNotFound, "Appointment not found"},
+	"already_cancelled":        {http.StatusConflict, "Booking is already cancelled"},
+	"booking_not_cancellable":  {http.StatusConflict, "Only pending or confirmed bookings can be cancelled"},
+	"status_changed":           {http.StatusConflict, "Status was changed by someone else, please reload and try again"},
+	"booking_not_editable":     {http.StatusConflict, "Only pending or confirmed bookings can be edited"},
+	"appointment_not_editable": {http.StatusConflict, "Only pending or confirmed appointments can be moved or removed"},
+	"no_appointments":          {http.StatusUnprocessableEntity, "A booking needs at least one appointment"},
+	"branch_not_allowed":       {http.StatusForbidden, "You do not have access to this branch"},
+}
+
+// respondBookingError writes the response for a known booking state error
+// and reports whether it did so.
+func respondBookingError(c *gin.Context, err error) bool {
+	rpcErr, ok := err.(*rpcError)
+	if !ok {
+		return false
+	}
+	resp, ok := bookingErrorResponses[rpcErr.Reason()]
+	if !ok {
+		return false
+	}
+
+	c.JSON(resp.status, models.Response{
+		Success: false,
+		Error:   resp.message,
+	})
+	return true
+}
+
+// cancelBooking cancels the booking and its active appointments, releasing
+// their slot capacity.
+func cancelBooking(client *supa.Client, bookingID, cancelledBy, reason string) (*models.Booking, error) {
+	params := map[string]interface{}{
+		"p_booking_id":   bookingID,
+		"p_cancelled_by": cancelledBy,
+		"p_reason":       nullableString(reason),
+	}
+
+	var booking models.Booking
+	if err := callRPC(client, "cancel_booking", params, &booking); err != nil {
+		return nil, err
+	}
+	return &booking, nil
+}
+
+// cancelAppointment cancels a single appointment and releases its slot.
+func cancelAppointment(client *supa.Client, appointmentID, cancelledBy, reason string) (*models.Appointment, error) {
+	params := map[string]interface{}{
+		"p_appointment_id": appointmentID,
+		"p_cancelled_by":   cancelledBy,
+		"p_reason":         nullableString(reason),
+	}
+
+	var appointment models.Appointment
+	if err := callRPC(client, "cancel_appointment", params, &appointment); err != nil {
+		return nil, err
+	}
+	return &appointment, nil
+}
+
+// updateBookingParams is the argument list of the update_booking function.
+// Nil fields leave that part of the booking unchanged.
+type updateBookingParams struct {
+	BookingID       string                     `json:"p_booking_id"`
+	UpdatedBy       string                     `json:"p_updated_by"`
+	AppointmentDate *string                    `json:"p_appointment_date"`
+	Changes         []models.AppointmentChange `json:"p_changes"`
+	ExpectedStatus  string                     `json:"p_expected_status"`
+	Status          *string                    `json:"p_status"`
+	Notes           *string                    `json:"p_notes"`
+}
+
+// updateBooking applies appointment moves, additions and removals, a status
+// change and notes to a booking in one transaction. Callers must check the
+// status transition from ExpectedStatus first.
+func updateBooking(client *supa.Client, params updateBookingParams) (*models.Booking, error) {
+	if params.Changes == nil {
+		params.Changes = []models.AppointmentChange{}
+	}
+
+	var booking models.Booking
+	if err := callRPC(client, "update_booking", params, &booking); err != nil {
+		return nil, err
+	}
+	return &booking, nil
+}
+
+// softDeleteBooking cancels an open booking and hides it from listings.
+func softDeleteBooking(client *supa.Client, bookingID, deletedBy, reason string) (*models.Booking, error) {
+	params := map[string]interface{}{
+		"p_booking_id": bookingID,
+		"p_deleted_by": deletedBy,
+		"p_reason":     reason,
+	}
+
+	var booking models.Booking
+	if err := callRPC(client, "soft_delete_booking", params, &booking); err != nil {
+		return nil, err
+	}
+	return &booking, nil
+}
+
+// setBookingStatus moves a booking, and the appointments sharing its current
+// status, to a new status. Callers must check the transition first.
+func setBookingStatus(client *supa.Client, bookingID, expectedStatus, status, updatedBy string) (*models.Booking, error) {
+	params := map[string]interface{}{
+		"p_booking_id":      bookingID,
+		"p_expected_status": expectedStatus,
+		"p_status":          status,
+		"p_updated_by":      updatedBy,
+	}
+
+	var booking models.Booking
+	if err := callRPC(client, "set_booking_status", params, &booking); err != nil {
+		return nil, err
+	}
+	return &booking, nil
+}
+
+// setAppointmentStatus moves one appointment to a new status and rolls the
+// change up to its booking. Callers must check the transition first.
+func setAppointmentStatus(client *supa.Client, appointmentID, expectedStatus, status, updatedBy string) (*models.Appointment, error) {
+	params := map[string]interface{}{
+		"p_appointment_id":  appointmentID,
+		"p_expected_status": expectedStatus,
+		"p_status":          status,
+		"p_updated_by":      updatedBy,
+	}
+
+	var appointment models.Appointment
+	if err := callRPC(client, "set_appointment_status", params, &appointment); err != nil {
+		return nil, err
+	}
+	return &appointment, nil
+}
+
+// respondIllegalTransition writes a 422 response for a status change that the
+// lifecycle does not allow for the caller's permissions.
+func respondIllegalTransition(c *gin.Context, from, to string) {
+	if !models.IsValidStatus(to) {
+		c.JSON(http.StatusUnprocessableEntity, models.Response{
+			Success: false,
+			Error:   fmt.Sprintf("Unknown status %q", to),
+		})
+		return
+	}
+	c.JSON(http.StatusUnprocessableEntity, models.Response{
+		Success: false,
+		Error:   fmt.Sprintf("Cannot change status from %s to %s", from, to),
+	})
+}
+
+// nullableString turns an empty string into a JSON null.
+func nullableString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// doctorErrorResponses maps errors raised by save_doctor and
+// deactivate_doctor to HTTP responses.
+var doctorErrorResponses = map[string]struct {
+	status  int
+	message string
+}{
+	"doctor_not_found":        {http.StatusNotFound, "Doctor not found"},
+	"doctor_inactive":         {http.StatusConflict, "Doctor is already inactive"},
+	"specialty_not_found":     {http.StatusUnprocessableEntity, "Specialty not found in the catalogue"},
+	"no_specialties":          {http.StatusUnprocessableEntity, "A doctor needs at least one specialty"},
+	"reassign_doctor_invalid": {http.StatusUnprocessableEntity, "reassign_to must be another active doctor"},
+	"no_matching_slot":        {http.StatusConflict, "The replacement doctor has no slot at the same time for an appointment"},
+	"branch_not_found":        {http.StatusUnprocessableEntity, "Branch not found or inactive"},
+	"branch_required":         {http.StatusUnprocessableEntity, "branch_id is required"},
+	"slot_branch_mismatch":    {http.StatusConflict, "The replacement doctor's matching slot is at another branch"},
+}
+
+// respondDoctorError writes the response for a known doctor management error
+// and reports whether it did so.
+func respondDoctorError(c *gin.Context, err error) bool {
+	rpcErr, ok := err.(*rpcError)
+	if !ok {
+		return false
+	}
+
+	if rpcErr.Reason() == "doctor_has_appointments" {
+		c.JSON(http.StatusConflict, models.Response{
+			Success: false,
+			Error:   fmt.Sprintf("Doctor has %s upcoming appointments; pass reassign_to to move them to another doctor", rpcErr.Detail()),
+		})
+		return true
+	}
+
+	resp, ok := doctorErrorResponses[rpcErr.Reason()]
+	if !ok {
+		return false
+	}
+
+	data := gin.H{}
+	if detail := rpcErr.Detail(); detail != "" {
+		data["id"] = detail
+	}
+	c.JSON(resp.status, models.Response{
+		Success: false,
+		Error:   resp.message,
+		Data:    data,
+	})
+	return true
+}
+
+// saveDoctor creates a doctor when doctorID is empty, otherwise updates it.
+// Specialties are replaced only when req.SpecialtyIDs is set. Reactivating a
+// doctor reopens the schedules from today on that deactivation closed.
+func saveDoctor(client *supa.Client, doctorID string, req models.DoctorRequest, updatedBy, today string) (*models.Doctor, error) {
+	var fullName *string
+	if req.FullName != nil {
+		trimmed := strings.TrimSpace(*req.FullName)
+		fullName = &trimmed
+	}
+
+	params := map[string]interface{}{
+		"p_doctor_id":     nullableString(doctorID),
+		"p_full_name":     fullName,
+		"p_title":         req.Title,
+		"p_title_set":     req.TitleSet,
+		"p_specialty_ids": req.SpecialtyIDs,
+		"p_is_active":     req.IsActive,
+		"p_updated_by":    updatedBy,
+		"p_branch_id":     req.BranchID,
+		"p_today":         today,
+	}
+
+	var doctor models.Doctor
+	if err := callRPC(client, "save_doctor", params, &doctor); err != nil {
+		return nil, err
+	}
+	return &doctor, nil
+}
@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sittawut/backend-appointment/config"
+	"github.com/sittawut/backend-appointment/middleware"
+	"github.com/sittawut/backend-appointment/models"
+	"github.com/sittawut/backend-appointment/services"
+	"github.com/supabase-community/postgrest-go"
+	supa "github.com/supabase-community/supabase-go"
+)
+
+// maxScheduleDays caps how far ahead a single preview or apply may generate.
+const maxScheduleDays = 180
+
+type ScheduleHandler struct {
+	supabase  *supa.Client
+	config    *config.Config
+	generator *services.ScheduleGenerator
+}
+
+func NewScheduleHandler(supabase *supa.Client, cfg *config.Config, generator *services.ScheduleGenerator) *ScheduleHandler {
+	return &ScheduleHandler{
+		supabase:  supabase,
+		config:    cfg,
+		generator: generator,
+	}
+}
+
+func (h *ScheduleHandler) GetTemplates(c *gin.Context) {
+	doctorID := c.Query("doctor_id")
+	branchIDs, ok := branchScope(c, c.Query("branch_id"))
+	if !ok {
+		return
+	}
+
+	query := h.supabase.From("schedule_templates").
+		Select("*", "", false).
+		Order("doctor_id", &postgrest.OrderOpts{Ascending: true}).
+		Order("day_of_week", &postgrest.OrderOpts{Ascending: true}).
+		Order("start_time", &postgrest.OrderOpts{Ascending: true})
+
+	if doctorID != "" {
+		query = query.Eq("doctor_id", doctorID)
+	}
+	if branchIDs != nil {
+		query = query.In("branch_id", branchIDs)
+	}
+	if c.Query("include_inactive") != "true" {
+		query = query.Eq("is_active", "true")
+	}
+
+	var templates []models.ScheduleTemplate
+	data, _, err := query.Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &templates)
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to fetch schedule templates",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    templates,
+	})
+}
+
+func (h *ScheduleHandler) CreateTemplate(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req models.ScheduleTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	templateData, err := h.templateData(req)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	if !middleware.InBranchScope(c, templateData["branch_id"].(string)) {
+		respondBranchForbidden(c)
+		return
+	}
+	templateData["created_by"] = userID.(string)
+	templateData["updated_by"] = userID.(string)
+
+	var created []models.ScheduleTemplate
+	data, _, err := h.supabase.From("schedule_templates").
+		Insert(templateData, false, "", "", "").
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &created)
+	}
+
+	if err != nil || len(created) == 0 {
+		fmt.Printf("[CreateTemplate] Insert error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to create schedule template",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.Response{
+		Success: true,
+		Message: "Schedule template created successfully",
+		Data:    created[0],
+	})
+}
+
+func (h *ScheduleHandler) UpdateTemplate(c *gin.Context) {
+	templateID := c.Param("id")
+	userID, _ := c.Get("user_id")
+
+	var req models.ScheduleTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+
+	templateData, err := h.templateData(req)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	if !middleware.InBranchScope(c, templateData["branch_id"].(string)) {
+		respondBranchForbidden(c)
+		return
+	}
+	templateData["updated_by"] = userID.(string)
+	templateData["updated_at"] = time.Now()
+
+	// Only templates at the caller's branches can be edited
+	branchIDs, ok := branchScope(c, "")
+	if !ok {
+		return
+	}
+	query := h.supabase.From("schedule_templates").
+		Update(templateData, "", "").
+		Eq("id", templateID)
+	if branchIDs != nil {
+		query = query.In("branch_id", branchIDs)
+	}
+
+	var updated []models.ScheduleTemplate
+	data, _, err := query.Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &updated)
+	}
+
+	if err != nil || len(updated) == 0 {
+		c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Schedule template not found or update failed",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Schedule template updated successfully",
+		Data:    updated[0],
+	})
+}
+
+// DeleteTemplate deactivates a template. Schedules it already generated are
+// kept; only future generation stops.
+func (h *ScheduleHandler) DeleteTemplate(c *gin.Context) {
+	templateID := c.Param("id")
+	userID, _ := c.Get("user_id")
+
+	branchIDs, ok := branchScope(c, "")
+	if !ok {
+		return
+	}
+	query := h.supabase.From("schedule_templates").
+		Update(map[string]interface{}{
+			"is_active":  false,
+			"updated_by": userID.(string),
+			"updated_at": time.Now(),
+		}, "", "").
+		Eq("id", templateID)
+	if branchIDs != nil {
+		query = query.In("branch_id", branchIDs)
+	}
+
+	var updated []models.ScheduleTemplate
+	data, _, err := query.Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &updated)
+	}
+
+	if err != nil || len(updated) == 0 {
+		c.JSON(http.StatusNotFound, models.Response{
+			Success: false,
+			Error:   "Schedule template not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Schedule template deactivated",
+		Data:    updated[0],
+	})
+}
+
+// PreviewSchedules shows the schedules and slots the templates would
+// generate, marking days that already exist.
+func (h *ScheduleHandler) PreviewSchedules(c *gin.Context) {
+	plan, ok := h.buildPlan(c)
+	if !ok {
+		return
+	}
+
+	if err := h.markExisting(plan); err != nil {
+		fmt.Printf("[PreviewSchedules] Existing schedule lookup error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to check existing schedules",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data:    plan,
+	})
+}
+
+// ApplySchedules generates schedules and slots from the templates. Re-running
+// it for the same horizon only fills in what is missing.
+func (h *ScheduleHandler) ApplySchedules(c *gin.Context) {
+	plan, ok := h.buildPlan(c)
+	if !ok {
+		return
+	}
+
+	result, err := h.generator.Apply(plan)
+	if err != nil {
+		fmt.Printf("[ApplySchedules] Apply error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to generate schedules",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: fmt.Sprintf("Created %d schedules and %d time slots", result.SchedulesCreated, result.SlotsCreated),
+		Data:    result,
+	})
+}
+
+// buildPlan reads the generation horizon from the request body and expands
+// the matching templates at the caller's branches. It writes an error
+// response and reports false on failure.
+func (h *ScheduleHandler) buildPlan(c *gin.Context) ([]models.PlannedSchedule, bool) {
+	var req models.ScheduleGenerateRequest
+	if err := c.ShouldBindJSON(&req); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return nil, false
+	}
+
+	if req.DateFrom == "" {
+		req.DateFrom = time.Now().In(h.config.ClinicLocation).Format("2006-01-02")
+	}
+	if req.Days == 0 {
+		req.Days = h.config.ScheduleHorizonDays
+	}
+
+	from, err := time.Parse("2006-01-02", req.DateFrom)
+	if err != nil || req.Days < 1 || req.Days > maxScheduleDays {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   fmt.Sprintf("date_from must be YYYY-MM-DD and days between 1 and %d", maxScheduleDays),
+		})
+		return nil, false
+	}
+
+	branchIDs, ok := branchScope(c, req.BranchID)
+	if !ok {
+		return nil, false
+	}
+
+	templates, err := h.generator.LoadTemplates(req.DoctorID, branchIDs)
+	if err != nil {
+		fmt.Printf("[Schedules] Template load error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to load schedule templates",
+		})
+		return nil, false
+	}
+
+	plan, err := services.BuildSchedulePlan(templates, from, req.Days)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return nil, false
+	}
+	return plan, true
+}
+
+// markExisting flags planned days for which the doctor already has a schedule.
+func (h *ScheduleHandler) markExisting(plan []models.PlannedSchedule) error {
+	if len(plan) == 0 {
+		return nil
+	}
+
+	doctorIDs := []string{}
+	seen := map[string]bool{}
+	for _, day := range plan {
+		if !seen[day.DoctorID] {
+			seen[day.DoctorID] = true
+			doctorIDs = append(doctorIDs, day.DoctorID)
+		}
+	}
+
+	var existing []models.DoctorSchedule
+	data, _, err := h.supabase.From("doctor_schedules").
+		Select("doctor_id, schedule_date", "", false).
+		In("doctor_id", doctorIDs).
+		And(fmt.Sprintf("schedule_date.gte.%s,schedule_date.lte.%s", plan[0].ScheduleDate, plan[len(plan)-1].ScheduleDate), "").
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &existing)
+	}
+	if err != nil {
+		return err
+	}
+
+	exists := map[string]bool{}
+	for _, s := range existing {
+		exists[s.DoctorID+"|"+s.ScheduleDate] = true
+	}
+	for i := range plan {
+		plan[i].Exists = exists[plan[i].DoctorID+"|"+plan[i].ScheduleDate]
+	}
+	return nil
+}
+
+// templateData validates a template request and converts it to a row. The
+// branch defaults to the doctor's.
+func (h *ScheduleHandler) templateData(req models.ScheduleTemplateRequest) (map[string]interface{}, error) {
+	if *req.DayOfWeek < 0 || *req.DayOfWeek > 6 {
+		return nil, fmt.Errorf("day_of_week must be between 0 (Sunday) and 6 (Saturday)")
+	}
+	if req.MaxCapacity == 0 {
+		req.MaxCapacity = 1
+	}
+	if req.MaxCapacity < 0 {
+		return nil, fmt.Errorf("max_capacity must be positive")
+	}
+	if req.Breaks == nil {
+		req.Breaks = []models.ScheduleBreak{}
+	}
+	for _, d := range []*string{req.EffectiveFrom, req.EffectiveTo} {
+		if d == nil {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", *d); err != nil {
+			return nil, fmt.Errorf("effective_from and effective_to must be in YYYY-MM-DD format")
+		}
+	}
+
+	slots, err := services.TemplateSlots(models.ScheduleTemplate{
+		StartTime:   req.StartTime,
+		EndTime:     req.EndTime,
+		SlotMinutes: req.SlotMinutes,
+		MaxCapacity: req.MaxCapacity,
+		Breaks:      req.Breaks,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(slots) == 0 {
+		return nil, fmt.Errorf("template does not produce any slots")
+	}
+
+	var doctors []models.Doctor
+	data, _, err := h.supabase.From("doctors").
+		Select("id, branch_id", "", false).
+		Eq("id", req.DoctorID).
+		Execute()
+	if err != nil || json.Unmarshal(data, &doctors) != nil || len(doctors) == 0 {
+		return nil, fmt.Errorf("doctor not found")
+	}
+
+	branchID := doctors[0].BranchID
+	if req.BranchID != "" {
+		var branches []models.Branch
+		data, _, err := h.supabase.From("branches").
+			Select("id", "", false).
+			Eq("id", req.BranchID).
+			Eq("is_active", "true").
+			Execute()
+		if err != nil || json.Unmarshal(data, &branches) != nil || len(branches) == 0 {
+			return nil, fmt.Errorf("branch not found")
+		}
+		branchID = req.BranchID
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	return map[string]interface{}{
+		"doctor_id":      req.DoctorID,
+		"branch_id":      branchID,
+		"day_of_week":    *req.DayOfWeek,
+		"start_time":     req.StartTime,
+		"end_time":       req.EndTime,
+		"slot_minutes":   req.SlotMinutes,
+		"max_capacity":   req.MaxCapacity,
+		"breaks":         req.Breaks,
+		"effective_from": req.EffectiveFrom,
+		"effective_to":   req.EffectiveTo,
+		"is_active":      isActive,
+	}, nil
+}
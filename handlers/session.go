@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sittawut/backend-appointment/config"
+	"github.com/sittawut/backend-appointment/middleware"
+	"github.com/sittawut/backend-appointment/models"
+	"github.com/sittawut/backend-appointment/services"
+	supa "github.com/supabase-community/supabase-go"
+)
+
+// refreshCookiePath limits the refresh token cookie to the auth routes.
+const refreshCookiePath = "/api/v1/auth"
+
+// SessionHandler refreshes and ends login sessions.
+type SessionHandler struct {
+	supabase *supa.Client
+	config   *config.Config
+	sessions *services.SessionService
+}
+
+func NewSessionHandler(supabase *supa.Client, cfg *config.Config, sessions *services.SessionService) *SessionHandler {
+	return &SessionHandler{
+		supabase: supabase,
+		config:   cfg,
+		sessions: sessions,
+	}
+}
+
+// Refresh exchanges a refresh token, from the body or the refresh_token
+// cookie, for a new access token and refresh token. The user is reloaded so
+// role changes apply and deactivated accounts are logged out.
+func (h *SessionHandler) Refresh(c *gin.Context) {
+	var req models.RefreshRequest
+	_ = c.ShouldBindJSON(&req)
+	fromCookie := false
+	if req.RefreshToken == "" {
+		req.RefreshToken, _ = c.Cookie("refresh_token")
+		fromCookie = true
+	}
+	if req.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, models.Response{
+			Success: false,
+			Error:   "refresh_token is required",
+		})
+		return
+	}
+
+	session, refreshToken, err := h.sessions.Rotate(req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, services.ErrSessionInvalid) || errors.Is(err, services.ErrSessionReused) {
+			c.JSON(http.StatusUnauthorized, models.Response{
+				Success: false,
+				Error:   "Session has ended, please log in again",
+			})
+			return
+		}
+		fmt.Printf("[Session] Refresh error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to refresh session",
+		})
+		return
+	}
+
+	var users []models.User
+	data, _, err := h.supabase.From("users").
+		Select("*", "", false).
+		Eq("id", session.UserID).
+		Eq("is_active", "true").
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &users)
+	}
+	if err != nil || len(users) == 0 {
+		_ = h.sessions.Revoke(session.ID, "user_inactive")
+		c.JSON(http.StatusUnauthorized, models.Response{
+			Success: false,
+			Error:   "Session has ended, please log in again",
+		})
+		return
+	}
+	user := &users[0]
+
+	if err := loadUserBranches(h.supabase, user); err != nil {
+		fmt.Printf("[Session] Branch lookup error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to refresh session",
+		})
+		return
+	}
+
+	accessToken, err := newAccessToken(h.config, h.sessions.Keys(), user, session.Provider, session.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to generate token",
+		})
+		return
+	}
+
+	resp := &models.LoginResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(h.config.AccessTokenTTL.Seconds()),
+		User:         user,
+	}
+	if fromCookie {
+		domain, secure := cookieDomain(c)
+		setSessionCookies(c, h.config, h.sessions, resp, domain, secure)
+	}
+
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Message: "Session refreshed",
+		Data:    resp,
+	})
+}
+
+// Logout revokes the session identified by the refresh token or by the
+// access token, which may already be expired, and clears the auth cookies.
+func (h *SessionHandler) Logout(c *gin.Context) {
+	var req models.RefreshRequest
+	_ = c.ShouldBindJSON(&req)
+	if req.RefreshToken == "" {
+		req.RefreshToken, _ = c.Cookie("refresh_token")
+	}
+	if req.RefreshToken != "" {
+		if err := h.sessions.RevokeByRefreshToken(req.RefreshToken, "logout"); err != nil {
+			fmt.Printf("[Session] Logout revoke error: %v\n", err)
+		}
+	}
+	if tokenString, ok := middleware.TokenFromRequest(c); ok && tokenString != "" {
+		if claims, err := middleware.ParseToken(h.sessions.Keys(), tokenString, true); err == nil && claims.SessionID != "" {
+			if err := h.sessions.Revoke(claims.SessionID, "logout"); err != nil {
+				fmt.Printf("[Session] Logout revoke error: %v\n", err)
+			}
+		}
+	}
+
+	secure := !strings.Contains(c.Request.Host, "localhost")
+	domain, _ := cookieDomain(c)
+	for _, name := range []string{"token", "user"} {
+		c.SetCookie(name, "", -1, "/", "", secure, name == "token")
+		if domain != "" {
+			c.SetCookie(name, "", -1, "/", domain, secure, name == "token")
+		}
+	}
+	c.SetCookie("refresh_token", "", -1, refreshCookiePath, domain, secure, true)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// startSession creates a session for a user who has just logged in and
+// returns the first access and refresh tokens.
+func startSession(c *gin.Context, supabase *supa.Client, cfg *config.Config, sessions *services.SessionService, user *models.User, provider string) (*models.LoginResponse, error) {
+	if err := loadUserBranches(supabase, user); err != nil {
+		return nil, err
+	}
+
+	session, refreshToken, err := sessions.Create(user, provider, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := newAccessToken(cfg, sessions.Keys(), user, provider, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.LoginResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(cfg.AccessTokenTTL.Seconds()),
+		User:         user,
+	}, nil
+}
+
+// newAccessToken mints a short-lived JWT for user bound to a session.
+func newAccessToken(cfg *config.Config, keys *services.TokenKeys, user *models.User, provider, sessionID string) (string, error) {
+	deref := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+
+	now := time.Now()
+	claims := middleware.Claims{
+		UserID:     user.ID,
+		Phone:      user.Phone,
+		Role:       user.Role,
+		Email:      deref(user.Email),
+		FullName:   user.FullName,
+		EmployeeID: deref(user.EmployeeID),
+		Department: deref(user.Department),
+		JobTitle:   deref(user.JobTitle),
+		Provider:   provider,
+		SessionID:  sessionID,
+		Branches:   user.BranchIDs,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    keys.Issuer(),
+			Audience:  jwt.ClaimStrings{keys.Audience()},
+			ExpiresAt: jwt.NewNumericDate(now.Add(cfg.AccessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	return keys.Sign(claims)
+}
+
+// loadUserBranches fills in the branches a staff member is assigned to.
+// Customers are not assigned to branches.
+func loadUserBranches(client *supa.Client, user *models.User) error {
+	user.BranchIDs = nil
+	if user.Role == models.RoleCustomer {
+		return nil
+	}
+
+	var rows []struct {
+		BranchID string `json:"branch_id"`
+	}
+	data, _, err := client.From("user_branches").
+		Select("branch_id", "", false).
+		Eq("user_id", user.ID).
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &rows)
+	}
+	if err != nil {
+		return err
+	}
+
+	user.BranchIDs = make([]string, 0, len(rows))
+	for _, row := range rows {
+		user.BranchIDs = append(user.BranchIDs, row.BranchID)
+	}
+	return nil
+}
+
+// cookieDomain picks the cookie domain and Secure flag for the request host:
+// the parent domain in production for cross-domain sharing, none on
+// localhost.
+func cookieDomain(c *gin.Context) (string, bool) {
+	secure := c.Request.Host != "localhost:3000" && c.Request.Host != "127.0.0.1:3000" && c.Request.Host != "localhost:8080" && c.Request.Host != "127.0.0.1:8080"
+	if !secure {
+		return "", false
+	}
+	if strings.Contains(c.Request.Host, "railway.app") {
+		return ".up.railway.app", true
+	}
+	if strings.Contains(c.Request.Host, "vercel.app") {
+		return ".vercel.app", true
+	}
+	return "", true
+}
+
+// setSessionCookies stores the access token in the "token" cookie and the
+// refresh token in an HttpOnly cookie sent only to the auth routes.
+func setSessionCookies(c *gin.Context, cfg *config.Config, sessions *services.SessionService, resp *models.LoginResponse, domain string, secure bool) {
+	c.SetCookie("token", resp.Token, int(cfg.AccessTokenTTL.Seconds()), "/", domain, secure, true)
+	c.SetCookie("refresh_token", resp.RefreshToken, int(sessions.RefreshTTL().Seconds()), refreshCookiePath, domain, secure, true)
+}
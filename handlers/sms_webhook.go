@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sittawut/backend-appointment/config"
+	"github.com/sittawut/backend-appointment/models"
+	"github.com/sittawut/backend-appointment/services"
+)
+
+// SMSWebhookHandler receives delivery reports pushed by SMS providers.
+type SMSWebhookHandler struct {
+	config  *config.Config
+	sms     *services.SMSService
+	tracker *services.SMSTracker
+}
+
+func NewSMSWebhookHandler(cfg *config.Config, smsService *services.SMSService, tracker *services.SMSTracker) *SMSWebhookHandler {
+	return &SMSWebhookHandler{
+		config:  cfg,
+		sms:     smsService,
+		tracker: tracker,
+	}
+}
+
+// webhookTokenHeader carries SMS_WEBHOOK_SECRET for providers that can set
+// request headers.
+const webhookTokenHeader = "X-Webhook-Token"
+
+// DeliveryReport updates sms_messages from a provider's delivery report. The
+// provider must send SMS_WEBHOOK_SECRET in the X-Webhook-Token header, or as
+// ?token= when it cannot set headers.
+func (h *SMSWebhookHandler) DeliveryReport(c *gin.Context) {
+	if h.config.SMSWebhookSecret == "" {
+		c.JSON(http.StatusServiceUnavailable, models.Response{
+			Success: false,
+			Error:   "SMS webhook is not configured",
+		})
+		return
+	}
+	token := c.GetHeader(webhookTokenHeader)
+	if token == "" {
+		token = c.Query("token")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.config.SMSWebhookSecret)) != 1 {
+		c.JSON(http.StatusUnauthorized, models.Response{
+			Success: false,
+			Error:   "Invalid webhook token",
+		})
+		return
+	}
+
+	provider := c.Param("provider")
+	reports, err := h.sms.ParseDeliveryReports(provider, c.Request)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrNoDeliveryReports) {
+			status = http.StatusNotFound
+		}
+		fmt.Printf("[SMS] Rejected %s delivery report: %v\n", provider, err)
+		c.JSON(status, models.Response{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	matched, err := h.tracker.ApplyDeliveryReports(provider, reports)
+	if err != nil {
+		fmt.Printf("[SMS] Failed to apply %s delivery reports: %v\n", provider, err)
+		c.JSON(http.StatusInternalServerError, models.Response{
+			Success: false,
+			Error:   "Failed to update SMS status",
+		})
+		return
+	}
+
+	fmt.Printf("[SMS] %s delivery reports: %d received, %d matched\n", provider, len(reports), matched)
+	c.JSON(http.StatusOK, models.Response{
+		Success: true,
+		Data: gin.H{
+			"received": len(reports),
+			"matched":  matched,
+		},
+	})
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sittawut/backend-appointment/config"
+	"github.com/sittawut/backend-appointment/services"
+	supa "github.com/supabase-community/supabase-go"
+)
+
+const testWebhookSecret = "hook-secret"
+
+// fakeSMSMessages stands in for PostgREST and records the delivery report
+// updates made to sms_messages.
+type fakeSMSMessages struct {
+	mu      sync.Mutex
+	filters []url.Values
+	reports []map[string]interface{}
+}
+
+func (s *fakeSMSMessages) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPatch || r.URL.Path != "/rest/v1/sms_messages" {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"code":"PGRST205","message":"unexpected request"}`)
+		return
+	}
+
+	var update struct {
+		Report map[string]interface{} `json:"report"`
+	}
+	json.NewDecoder(r.Body).Decode(&update)
+	s.filters = append(s.filters, r.URL.Query())
+	s.reports = append(s.reports, update.Report)
+
+	if r.URL.Query().Get("message_id") == "eq.msg-1" {
+		io.WriteString(w, `[{"id":"row-1","provider":"sms2pro","message_id":"msg-1"}]`)
+		return
+	}
+	io.WriteString(w, `[]`)
+}
+
+func newSMSWebhookTest(t *testing.T) (*gin.Engine, *fakeSMSMessages) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	store := &fakeSMSMessages{}
+	db := httptest.NewServer(http.HandlerFunc(store.serve))
+	t.Cleanup(db.Close)
+	client, err := supa.NewClient(db.URL, "service-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		SMS2ProAPIKey:    "sms2pro-key",
+		SMS2ProBaseURL:   "http://sms2pro.invalid",
+		SMSOTPProviders:  []string{"sms2pro"},
+		SMSWebhookSecret: testWebhookSecret,
+	}
+	sms, err := services.NewSMSService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewSMSWebhookHandler(cfg, sms, services.NewSMSTracker(client))
+	router := gin.New()
+	router.POST("/api/v1/webhooks/sms/:provider", handler.DeliveryReport)
+	return router, store
+}
+
+func postDeliveryReport(router *gin.Engine, target string, form url.Values, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestDeliveryReportKeepsSecretOutOfReport(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		header http.Header
+	}{
+		{
+			name:   "query token",
+			target: "/api/v1/webhooks/sms/sms2pro?token=" + testWebhookSecret,
+		},
+		{
+			name:   "header token",
+			target: "/api/v1/webhooks/sms/sms2pro",
+			header: http.Header{"X-Webhook-Token": {testWebhookSecret}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, store := newSMSWebhookTest(t)
+			form := url.Values{"message_id": {"msg-1"}, "status": {"DELIVRD"}}
+
+			w := postDeliveryReport(router, tt.target, form, tt.header)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			var resp struct {
+				Data struct {
+					Received int `json:"received"`
+					Matched  int `json:"matched"`
+				} `json:"data"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if resp.Data.Received != 1 || resp.Data.Matched != 1 {
+				t.Errorf("received %d, matched %d, want 1 and 1", resp.Data.Received, resp.Data.Matched)
+			}
+
+			if len(store.filters) != 1 {
+				t.Fatalf("%d updates, want 1", len(store.filters))
+			}
+			if got := store.filters[0].Get("message_id"); got != "eq.msg-1" {
+				t.Errorf("matched message_id %q, want eq.msg-1", got)
+			}
+			if got := store.filters[0].Get("provider"); got != "eq.sms2pro" {
+				t.Errorf("matched provider %q, want eq.sms2pro", got)
+			}
+			want := map[string]interface{}{"message_id": "msg-1", "status": "DELIVRD"}
+			if len(store.reports[0]) != len(want) {
+				t.Errorf("stored report %v, want %v", store.reports[0], want)
+			}
+			for key, value := range want {
+				if store.reports[0][key] != value {
+					t.Errorf("stored report %v, want %v", store.reports[0], want)
+				}
+			}
+		})
+	}
+}
+
+func TestDeliveryReportRejectsWrongToken(t *testing.T) {
+	router, store := newSMSWebhookTest(t)
+	form := url.Values{"message_id": {"msg-1"}, "status": {"DELIVRD"}}
+
+	for _, target := range []string{
+		"/api/v1/webhooks/sms/sms2pro",
+		"/api/v1/webhooks/sms/sms2pro?token=wrong",
+	} {
+		if w := postDeliveryReport(router, target, form, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("POST %s: status = %d, want 401", target, w.Code)
+		}
+	}
+	if len(store.filters) != 0 {
+		t.Errorf("%d updates made without a valid token", len(store.filters))
+	}
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sittawut/backend-appointment/config"
+	"github.com/sittawut/backend-appointment/models"
+	supa "github.com/supabase-community/supabase-go"
+)
+
+// fakeUsers stands in for PostgREST with a users table whose staff are
+// assigned to branches. It records the list queries and the updates made.
+type fakeUsers struct {
+	mu       sync.Mutex
+	users    map[string]string   // id -> role
+	branches map[string][]string // id -> branch IDs
+	lists    []url.Values
+	updates  []string
+}
+
+func (s *fakeUsers) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.URL.Path != "/rest/v1/users" {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"code":"PGRST205","message":"unexpected request"}`)
+		return
+	}
+	query := r.URL.Query()
+	id := strings.TrimPrefix(query.Get("id"), "eq.")
+
+	switch r.Method {
+	case http.MethodGet:
+		if id == "" {
+			s.lists = append(s.lists, query)
+			w.Header().Set("Content-Range", "0-0/0")
+			io.WriteString(w, `[]`)
+			return
+		}
+		role, ok := s.users[id]
+		if !ok {
+			io.WriteString(w, `[]`)
+			return
+		}
+		branches := []map[string]string{}
+		for _, branchID := range s.branches[id] {
+			branches = append(branches, map[string]string{"branch_id": branchID})
+		}
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"id": id, "role": role, "user_branches": branches},
+		})
+	case http.MethodPatch:
+		s.updates = append(s.updates, id)
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"id": id, "role": s.users[id], "full_name": "Updated"},
+		})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// newUserScopeTest serves the user admin routes to a caller at branches with
+// permissions, as AuthMiddleware would set them.
+func newUserScopeTest(t *testing.T, branches []string, permissions ...string) (*gin.Engine, *fakeUsers) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	store := &fakeUsers{
+		users: map[string]string{
+			"nurse-a":  "nurse",
+			"nurse-b":  "nurse",
+			"customer": models.RoleCustomer,
+		},
+		branches: map[string][]string{
+			"nurse-a": {"branch-a"},
+			"nurse-b": {"branch-b"},
+		},
+	}
+	db := httptest.NewServer(http.HandlerFunc(store.serve))
+	t.Cleanup(db.Close)
+	client, err := supa.NewClient(db.URL, "service-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	granted := map[string]bool{}
+	for _, permission := range permissions {
+		granted[permission] = true
+	}
+	handler := NewNurseHandler(client, &config.Config{}, nil, nil, nil, nil)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", "caller")
+		c.Set("branches", branches)
+		c.Set("permissions", granted)
+	})
+	router.GET("/api/v1/nurse/users", handler.GetAllUsers)
+	router.PUT("/api/v1/nurse/users/:id", handler.UpdateUser)
+	return router, store
+}
+
+func TestGetAllUsersFiltersByBranch(t *testing.T) {
+	router, store := newUserScopeTest(t, []string{"branch-a"})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/nurse/users", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	if len(store.lists) != 1 {
+		t.Fatalf("%d list queries, want 1", len(store.lists))
+	}
+	query := store.lists[0]
+	if got := query.Get("scope.branch_id"); got != "in.(branch-a)" {
+		t.Errorf("scope.branch_id = %q, want in.(branch-a)", got)
+	}
+	if got := query.Get("or"); got != "(role.eq.customer,scope.not.is.null)" {
+		t.Errorf("or = %q, want customers or staff of the caller's branches", got)
+	}
+}
+
+func TestGetAllUsersUnscopedWithBranchAny(t *testing.T) {
+	router, store := newUserScopeTest(t, nil, models.PermBranchAny)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/nurse/users", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	if query := store.lists[0]; query.Has("scope.branch_id") || query.Has("or") {
+		t.Errorf("branch:any caller got a scoped query: %v", query)
+	}
+}
+
+func TestUpdateUserOutsideBranchScope(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions []string
+		target      string
+		want        int
+	}{
+		{"staff at another branch", nil, "nurse-b", http.StatusNotFound},
+		{"staff at the caller's branch", nil, "nurse-a", http.StatusOK},
+		{"customer", nil, "customer", http.StatusOK},
+		{"branch:any caller", []string{models.PermBranchAny}, "nurse-b", http.StatusOK},
+		{"unknown user", nil, "nobody", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			permissions := append([]string{models.PermUserWriteStaff}, tt.permissions...)
+			router, store := newUserScopeTest(t, []string{"branch-a"}, permissions...)
+
+			req := httptest.NewRequest(http.MethodPut, "/api/v1/nurse/users/"+tt.target, strings.NewReader(`{"full_name":"Updated"}`))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.want, w.Body)
+			}
+			if updated := len(store.updates) > 0; updated != (tt.want == http.StatusOK) {
+				t.Errorf("updates = %v", store.updates)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -23,17 +24,80 @@ func main() {
 	// Initialize Supabase client
 	supabaseClient := config.NewSupabaseClient(cfg)
 
-	// Initialize SMS client - using THSMS
-	var smsClient services.SMSClient
-	if cfg.THSMSToken != "" {
-		smsClient = services.NewTHSMSClientImpl(cfg.THSMSToken, cfg.THSMSBaseURL, cfg.THSMSSender)
-	} else {
-		// Fallback to SMSMKT if THSMS not configured
-		smsClient = &services.SMSMKTClient{
-			APIKey:     cfg.SMSMKTKey,
-			SecretKey:  cfg.SMSMKTSecretKey,
-			ProjectKey: cfg.SMSMKTProjectKey,
-			URL:        cfg.SMSMKTURL,
+	// Initialize SMS providers selected in config
+	smsService, err := services.NewSMSService(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize SMS providers: %v", err)
+	}
+	if !smsService.HasTextProvider() && !smsService.HasOTPProvider() {
+		log.Println("Warning: no SMS provider configured, OTP login is disabled")
+	}
+	smsTracker := services.NewSMSTracker(supabaseClient)
+	smsService.SetRecorder(smsTracker)
+	otpService := services.NewOTPService(supabaseClient, smsService, services.OTPOptions{
+		MaxAttempts: cfg.OTPMaxAttempts,
+		RequestLimits: map[string]config.RateLimit{
+			"user":  cfg.OTPRequestLimitUser,
+			"phone": cfg.OTPRequestLimitPhone,
+			"ip":    cfg.OTPRequestLimitIP,
+		},
+		VerifyLimits: map[string]config.RateLimit{
+			"user": cfg.OTPVerifyLimitUser,
+			"ip":   cfg.OTPVerifyLimitIP,
+		},
+	})
+
+	// Initialize customer notifications and their sender
+	notifier := services.NewNotifier(supabaseClient, smsService, cfg.ClinicLocation, services.NotifierOptions{
+		MaxAttempts:  cfg.NotificationMaxAttempts,
+		ReminderHour: cfg.NotificationReminderHour,
+	})
+	if cfg.NotificationWorkerEnabled {
+		notifier.Start(cfg.NotificationPollInterval)
+	}
+
+	// Initialize schedule generator and keep the rolling horizon filled
+	scheduleGenerator := services.NewScheduleGenerator(supabaseClient, cfg.ClinicLocation)
+	if cfg.ScheduleAutoGenerate {
+		scheduleGenerator.StartRollingHorizon(cfg.ScheduleHorizonDays, 24*time.Hour)
+	}
+
+	// Initialize request rate limit buckets
+	rateLimitStore, err := services.NewRateLimitStore(cfg.RateLimitRedisURL)
+	if err != nil {
+		log.Fatalf("Failed to initialize rate limit store: %v", err)
+	}
+
+	// Initialize access token keys and login sessions
+	tokenKeys, err := services.LoadTokenKeys(cfg.JWTSigningKeyFile, cfg.JWTVerifyKeyFiles, cfg.JWTSecret, cfg.JWTIssuer, cfg.JWTAudience)
+	if err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+	if !tokenKeys.Asymmetric() {
+		log.Println("Warning: JWT_SIGNING_KEY_FILE not set, signing access tokens with HS256 and JWT_SECRET")
+	}
+	sessionService := services.NewSessionService(supabaseClient, tokenKeys, services.SessionOptions{
+		RefreshTTL:    cfg.RefreshTokenTTL,
+		RefreshGrace:  cfg.RefreshTokenGrace,
+		CheckCacheTTL: cfg.SessionCheckCacheTTL,
+	})
+
+	// Initialize role permissions
+	permissionService := services.NewPermissionService(supabaseClient, cfg.PermissionCacheTTL)
+
+	// Initialize staff login identity providers
+	oidcProviders, err := services.NewOIDCProviders(cfg.OIDCProviders)
+	if err != nil {
+		log.Fatalf("Failed to configure OIDC providers: %v", err)
+	}
+	for _, provider := range cfg.OIDCProviders {
+		if len(provider.RoleMap) == 0 {
+			log.Printf("Warning: OIDC provider %s has no role map, none of its users can log in", provider.Name)
+		}
+		for value, role := range provider.RoleMap {
+			if exists, err := permissionService.RoleExists(role); err == nil && !exists {
+				log.Printf("Warning: OIDC provider %s maps %s to role %s, which is not in the roles table", provider.Name, value, role)
+			}
 		}
 	}
 
@@ -44,12 +108,15 @@ func main() {
 
 	// Create Gin router
 	router := gin.Default()
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 
 	// Setup CORS middleware
 	router.Use(config.CORSMiddleware(cfg))
 
 	// Setup routes
-	routes.SetupRoutes(router, supabaseClient, cfg, smsClient)
+	routes.SetupRoutes(router, supabaseClient, cfg, smsService, otpService, smsTracker, rateLimitStore, sessionService, permissionService, oidcProviders, notifier, scheduleGenerator)
 
 	// Start server
 	port := os.Getenv("PORT")
@@ -3,11 +3,11 @@ package middleware
 import (
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/sittawut/backend-appointment/config"
 )
 
 type Claims struct {
@@ -20,118 +20,144 @@ type Claims struct {
 	Department string `json:"department,omitempty"`
 	JobTitle   string `json:"job_title,omitempty"`
 	Provider   string `json:"provider,omitempty"`
+	// SessionID links the token to its row in sessions.
+	SessionID string `json:"sid,omitempty"`
+	// Branches are the clinic branches a staff member is assigned to.
+	Branches []string `json:"branches,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		fmt.Printf("[AuthMiddleware] Request: %s %s\n", c.Request.Method, c.Request.URL.Path)
-		authHeader := c.GetHeader("Authorization")
-		tokenString := ""
-		if authHeader != "" {
-			// Extract token from "Bearer <token>"
-			parts := strings.Split(authHeader, " ")
-			if len(parts) != 2 || parts[0] != "Bearer" {
-				c.JSON(http.StatusUnauthorized, gin.H{
-					"success": false,
-					"error":   "Invalid authorization header format",
-				})
-				c.Abort()
-				return
-			}
-			tokenString = parts[1]
-		} else {
-			// Fallback to HttpOnly cookie (used by Azure login flow)
-			cookieToken, err := c.Cookie("token")
-			if err != nil || cookieToken == "" {
-				c.JSON(http.StatusUnauthorized, gin.H{
-					"success": false,
-					"error":   "Authorization required",
-				})
-				c.Abort()
-				return
-			}
-			tokenString = cookieToken
-		}
+// SessionValidator reports whether the session an access token belongs to
+// is still active.
+type SessionValidator interface {
+	SessionActive(sessionID string) (bool, error)
+}
+
+// TokenVerifier checks an access token's signature. It pins the accepted
+// algorithms, so a token cannot choose how it is verified. Issuer and
+// Audience are the iss and aud claims a token must carry.
+type TokenVerifier interface {
+	Parse(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error)
+	Issuer() string
+	Audience() string
+}
+
+// TokenFromRequest returns the bearer token, falling back to the HttpOnly
+// "token" cookie. ok is false when the Authorization header is malformed.
+func TokenFromRequest(c *gin.Context) (token string, ok bool) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		cookieToken, _ := c.Cookie("token")
+		return cookieToken, true
+	}
 
-		// Parse and validate token
-		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(cfg.JWTSecret), nil
-		})
+	// Extract token from "Bearer <token>"
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// ParseToken verifies an access token's signature and claims, including its
+// issuer and audience. With allowExpired an expired token is still accepted,
+// for logout.
+func ParseToken(keys TokenVerifier, tokenString string, allowExpired bool) (*Claims, error) {
+	opts := []jwt.ParserOption{jwt.WithIssuer(keys.Issuer()), jwt.WithAudience(keys.Audience())}
+	if allowExpired {
+		opts = append(opts, jwt.WithoutClaimsValidation())
+	}
 
-		if err != nil || !token.Valid {
+	token, err := keys.Parse(tokenString, &Claims{}, opts...)
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid or expired token: %v", err)
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+	// WithoutClaimsValidation skips the issuer and audience checks too.
+	if allowExpired && (claims.Issuer != keys.Issuer() || !slices.Contains(claims.Audience, keys.Audience())) {
+		return nil, fmt.Errorf("invalid token issuer or audience")
+	}
+	return claims, nil
+}
+
+// AuthMiddleware accepts a valid access token whose session is still active.
+func AuthMiddleware(keys TokenVerifier, sessions SessionValidator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fmt.Printf("[AuthMiddleware] Request: %s %s\n", c.Request.Method, c.Request.URL.Path)
+		tokenString, ok := TokenFromRequest(c)
+		if !ok {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"success": false,
-				"error":   "Invalid or expired token",
+				"error":   "Invalid authorization header format",
 			})
 			c.Abort()
 			return
 		}
-
-		claims, ok := token.Claims.(*Claims)
-		if !ok {
+		if tokenString == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"success": false,
-				"error":   "Invalid token claims",
+				"error":   "Authorization required",
 			})
 			c.Abort()
 			return
 		}
 
-		// Set user info in context
-		c.Set("user_id", claims.UserID)
-		c.Set("phone", claims.Phone)
-		c.Set("role", claims.Role)
-		c.Set("email", claims.Email)
-		c.Set("full_name", claims.FullName)
-		c.Set("employee_id", claims.EmployeeID)
-		c.Set("department", claims.Department)
-		c.Set("job_title", claims.JobTitle)
-		c.Set("provider", claims.Provider)
-
-		c.Next()
-	}
-}
-
-func RoleMiddleware(allowedRoles ...string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		role, exists := c.Get("role")
-		if !exists {
-			c.JSON(http.StatusForbidden, gin.H{
+		claims, err := ParseToken(keys, tokenString, false)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
 				"success": false,
-				"error":   "User role not found",
+				"error":   "Invalid or expired token",
 			})
 			c.Abort()
 			return
 		}
 
-		userRole, ok := role.(string)
-		if !ok {
-			c.JSON(http.StatusForbidden, gin.H{
+		// Tokens minted before sessions existed carry no session ID and
+		// cannot be revoked, so they are refused.
+		if claims.SessionID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{
 				"success": false,
-				"error":   "Invalid user role type",
+				"error":   "Session required, please log in again",
 			})
 			c.Abort()
 			return
 		}
-
-		allowed := false
-		for _, allowedRole := range allowedRoles {
-			if userRole == allowedRole {
-				allowed = true
-				break
-			}
+		active, err := sessions.SessionActive(claims.SessionID)
+		if err != nil {
+			fmt.Printf("[AuthMiddleware] Session check error: %v\n", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"success": false,
+				"error":   "Unable to verify session",
+			})
+			c.Abort()
+			return
 		}
-
-		if !allowed {
-			c.JSON(http.StatusForbidden, gin.H{
+		if !active {
+			c.JSON(http.StatusUnauthorized, gin.H{
 				"success": false,
-				"error":   "Insufficient permissions",
+				"error":   "Session has ended, please log in again",
 			})
 			c.Abort()
 			return
 		}
 
+		// Set user info in context
+		c.Set("user_id", claims.UserID)
+		c.Set("phone", claims.Phone)
+		c.Set("role", claims.Role)
+		c.Set("email", claims.Email)
+		c.Set("full_name", claims.FullName)
+		c.Set("employee_id", claims.EmployeeID)
+		c.Set("department", claims.Department)
+		c.Set("job_title", claims.JobTitle)
+		c.Set("provider", claims.Provider)
+		c.Set("session_id", claims.SessionID)
+		c.Set("branches", claims.Branches)
+
 		c.Next()
 	}
 }
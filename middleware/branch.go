@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sittawut/backend-appointment/models"
+)
+
+// BranchScope returns the branches the authenticated user works at, from
+// the access token. all is true for users whose role has branch:any, who
+// are not limited to their branches.
+func BranchScope(c *gin.Context) (branchIDs []string, all bool) {
+	if HasPermission(c, models.PermBranchAny) {
+		return nil, true
+	}
+	branches, _ := c.Get("branches")
+	branchIDs, _ = branches.([]string)
+	return branchIDs, false
+}
+
+// InBranchScope reports whether the authenticated user may work with
+// records at branchID.
+func InBranchScope(c *gin.Context, branchID string) bool {
+	branchIDs, all := BranchScope(c)
+	if all {
+		return true
+	}
+	for _, id := range branchIDs {
+		if id == branchID {
+			return true
+		}
+	}
+	return false
+}
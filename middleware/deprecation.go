@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deprecated marks a route as deprecated for clients still calling it. It
+// sets the Deprecation header, Sunset when sunset is not zero, and a Link to
+// the successor route.
+func Deprecated(successor string, sunset time.Time) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		if !sunset.IsZero() {
+			c.Header("Sunset", sunset.UTC().Format(http.TimeFormat))
+		}
+		c.Header("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		fmt.Printf("[Deprecated] %s %s called from %s (use %s)\n", c.Request.Method, c.Request.URL.Path, c.ClientIP(), successor)
+		c.Next()
+	}
+}
+
+// Gone answers retired routes with 410 and points to the successor.
+func Gone(successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		c.JSON(http.StatusGone, gin.H{
+			"success": false,
+			"error":   fmt.Sprintf("This endpoint has been retired, use %s", successor),
+		})
+		c.Abort()
+	}
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PermissionSource returns the permissions a role grants.
+type PermissionSource interface {
+	RolePermissions(role string) (map[string]bool, error)
+}
+
+// LoadPermissions resolves the permissions of the authenticated user's role
+// and stores them in the context for RequirePermission and HasPermission.
+// It must run after AuthMiddleware.
+func LoadPermissions(source PermissionSource) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		permissions, err := source.RolePermissions(c.GetString("role"))
+		if err != nil {
+			fmt.Printf("[Permissions] Load error: %v\n", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"success": false,
+				"error":   "Unable to load permissions",
+			})
+			c.Abort()
+			return
+		}
+		c.Set("permissions", permissions)
+		c.Next()
+	}
+}
+
+// RequirePermission lets the request through when the user holds any one
+// of permissions.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, permission := range permissions {
+			if HasPermission(c, permission) {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"error":   "Insufficient permissions",
+		})
+		c.Abort()
+	}
+}
+
+// HasPermission reports whether the authenticated user holds permission.
+func HasPermission(c *gin.Context, permission string) bool {
+	permissions, _ := c.Get("permissions")
+	granted, _ := permissions.(map[string]bool)
+	return granted[permission]
+}
+
+// CanAccessOwned reports whether the user may act on a record: either
+// through anyPermission, or through ownPermission when owns reports the
+// record belongs to them. owns is only called when needed, so it may query
+// the database.
+func CanAccessOwned(c *gin.Context, anyPermission, ownPermission string, owns func(userID string) bool) bool {
+	if HasPermission(c, anyPermission) {
+		return true
+	}
+	userID := c.GetString("user_id")
+	return HasPermission(c, ownPermission) && userID != "" && owns(userID)
+}
+
+// PermissionCheck returns HasPermission bound to c, as expected by
+// models.CanTransition and models.IsInitialStatus.
+func PermissionCheck(c *gin.Context) func(permission string) bool {
+	return func(permission string) bool {
+		return HasPermission(c, permission)
+	}
+}
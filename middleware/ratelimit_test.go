@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sittawut/backend-appointment/config"
+	"github.com/sittawut/backend-appointment/services"
+)
+
+func TestKeyByPhoneSharesBucketAcrossSpellings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limit := config.RateLimit{Limit: 1, Window: time.Hour}
+	router := gin.New()
+	router.POST("/otp", RateLimit(services.NewMemoryRateLimitStore(), "auth_phone", limit, KeyByPhone), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	post := func(phone string) int {
+		req := httptest.NewRequest(http.MethodPost, "/otp", strings.NewReader(`{"phone":"`+phone+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := post("0812345678"); code != http.StatusOK {
+		t.Fatalf("first request: status = %d, want 200", code)
+	}
+	for _, phone := range []string{"+66812345678", "081-234-5678", "+66 81 234 5678", " 0812345678 "} {
+		if code := post(phone); code != http.StatusTooManyRequests {
+			t.Errorf("%q: status = %d, want 429", phone, code)
+		}
+	}
+	if code := post("0899999999"); code != http.StatusOK {
+		t.Errorf("another number: status = %d, want 200", code)
+	}
+}
+
+func TestKeyByPhoneKeepsBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	body := `{"phone":"+66812345678","otp_code":"123456"}`
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/otp", strings.NewReader(body))
+
+	if key := KeyByPhone(c); key != "0812345678" {
+		t.Errorf("key = %q, want 0812345678", key)
+	}
+	var req struct {
+		OTPCode string `json:"otp_code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.OTPCode != "123456" {
+		t.Errorf("body after KeyByPhone: %+v, %v", req, err)
+	}
+}
@@ -0,0 +1,108 @@
+-- Migration: Atomic booking creation
+-- Description: Create booking, appointments and time slot reservations in a single transaction
+
+-- Reserve one seat on a time slot. Locks the slot row so concurrent bookings
+-- are serialised, and raises a tagged exception when the slot cannot be used.
+-- Exception messages have the form '<reason>:<time_slot_id>' so the API can
+-- report which slot was rejected.
+CREATE OR REPLACE FUNCTION public.reserve_time_slot(
+  p_time_slot_id UUID,
+  p_doctor_id UUID,
+  p_appointment_date DATE
+) RETURNS VOID
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_slot RECORD;
+BEGIN
+  SELECT ts.id, ts.status, ts.max_capacity, ts.current_bookings,
+         ds.doctor_id, ds.schedule_date, ds.is_available
+    INTO v_slot
+    FROM public.time_slots ts
+    JOIN public.doctor_schedules ds ON ds.id = ts.doctor_schedule_id
+   WHERE ts.id = p_time_slot_id
+     FOR UPDATE OF ts;
+
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'slot_not_found:%', p_time_slot_id;
+  END IF;
+
+  IF v_slot.doctor_id <> p_doctor_id THEN
+    RAISE EXCEPTION 'slot_doctor_mismatch:%', p_time_slot_id;
+  END IF;
+
+  IF v_slot.schedule_date <> p_appointment_date THEN
+    RAISE EXCEPTION 'slot_date_mismatch:%', p_time_slot_id;
+  END IF;
+
+  IF v_slot.status IN ('blocked', 'inactive') OR NOT v_slot.is_available THEN
+    RAISE EXCEPTION 'slot_blocked:%', p_time_slot_id;
+  END IF;
+
+  IF v_slot.current_bookings >= v_slot.max_capacity THEN
+    RAISE EXCEPTION 'slot_full:%', p_time_slot_id;
+  END IF;
+
+  UPDATE public.time_slots
+     SET current_bookings = current_bookings + 1,
+         status = CASE WHEN current_bookings + 1 >= max_capacity THEN 'booked' ELSE status END,
+         updated_at = NOW()
+   WHERE id = p_time_slot_id;
+END;
+$$;
+
+-- Create a booking with all of its appointments. Either every slot is
+-- reserved and every row is written, or the whole call is rolled back.
+CREATE OR REPLACE FUNCTION public.create_booking(
+  p_customer_id UUID,
+  p_appointment_date DATE,
+  p_status TEXT,
+  p_notes TEXT,
+  p_created_by UUID,
+  p_appointments JSONB
+) RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_booking public.bookings;
+  v_apt JSONB;
+BEGIN
+  IF p_appointments IS NULL OR jsonb_array_length(p_appointments) = 0 THEN
+    RAISE EXCEPTION 'no_appointments';
+  END IF;
+
+  INSERT INTO public.bookings (customer_id, appointment_date, status, notes, created_by, updated_by)
+  VALUES (p_customer_id, p_appointment_date, p_status, p_notes, p_created_by, p_created_by)
+  RETURNING * INTO v_booking;
+
+  -- Lock slots in a stable order so two overlapping bookings cannot deadlock.
+  FOR v_apt IN
+    SELECT value FROM jsonb_array_elements(p_appointments) ORDER BY value->>'time_slot_id'
+  LOOP
+    PERFORM public.reserve_time_slot(
+      (v_apt->>'time_slot_id')::UUID,
+      (v_apt->>'doctor_id')::UUID,
+      p_appointment_date
+    );
+
+    INSERT INTO public.appointments (booking_id, time_slot_id, doctor_id, service_type, location, status)
+    VALUES (
+      v_booking.id,
+      (v_apt->>'time_slot_id')::UUID,
+      (v_apt->>'doctor_id')::UUID,
+      v_apt->>'service_type',
+      v_apt->>'location',
+      p_status
+    );
+  END LOOP;
+
+  RETURN to_jsonb(v_booking);
+END;
+$$;
+
+-- PostgREST exposes every function in public. Only the API, which connects
+-- with the service role key, may reserve slots or create bookings.
+REVOKE ALL ON FUNCTION public.reserve_time_slot(UUID, UUID, DATE) FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.create_booking(UUID, DATE, TEXT, TEXT, UUID, JSONB) FROM PUBLIC, anon, authenticated;
+GRANT EXECUTE ON FUNCTION public.reserve_time_slot(UUID, UUID, DATE) TO service_role;
+GRANT EXECUTE ON FUNCTION public.create_booking(UUID, DATE, TEXT, TEXT, UUID, JSONB) TO service_role;
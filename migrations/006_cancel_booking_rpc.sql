@@ -0,0 +1,136 @@
+-- Migration: Booking cancellation
+-- Description: Cancel bookings and appointments as a status change and release slot capacity
+
+ALTER TABLE public.bookings
+ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMP WITH TIME ZONE,
+ADD COLUMN IF NOT EXISTS cancelled_by UUID REFERENCES public.users(id),
+ADD COLUMN IF NOT EXISTS cancellation_reason TEXT;
+
+ALTER TABLE public.appointments
+ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMP WITH TIME ZONE,
+ADD COLUMN IF NOT EXISTS cancelled_by UUID REFERENCES public.users(id),
+ADD COLUMN IF NOT EXISTS cancellation_reason TEXT;
+
+-- Give back one seat on a time slot. A slot that was closed only because it
+-- was full becomes available again; blocked slots stay blocked.
+CREATE OR REPLACE FUNCTION public.release_time_slot(p_time_slot_id UUID)
+RETURNS VOID
+LANGUAGE plpgsql
+AS $$
+BEGIN
+  UPDATE public.time_slots
+     SET current_bookings = GREATEST(current_bookings - 1, 0),
+         status = CASE WHEN status = 'booked' THEN 'available' ELSE status END,
+         updated_at = NOW()
+   WHERE id = p_time_slot_id;
+END;
+$$;
+
+-- Cancel a single appointment and release its slot. The parent booking is
+-- cancelled too once it has no remaining active appointments.
+CREATE OR REPLACE FUNCTION public.cancel_appointment(
+  p_appointment_id UUID,
+  p_cancelled_by UUID,
+  p_reason TEXT
+) RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_apt public.appointments;
+BEGIN
+  SELECT * INTO v_apt FROM public.appointments WHERE id = p_appointment_id FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'appointment_not_found:%', p_appointment_id;
+  END IF;
+  IF v_apt.status = 'cancelled' THEN
+    RAISE EXCEPTION 'already_cancelled:%', p_appointment_id;
+  END IF;
+
+  PERFORM public.release_time_slot(v_apt.time_slot_id);
+
+  UPDATE public.appointments
+     SET status = 'cancelled',
+         cancelled_at = NOW(),
+         cancelled_by = p_cancelled_by,
+         cancellation_reason = p_reason,
+         updated_at = NOW()
+   WHERE id = p_appointment_id
+  RETURNING * INTO v_apt;
+
+  IF NOT EXISTS (
+    SELECT 1 FROM public.appointments
+     WHERE booking_id = v_apt.booking_id AND status <> 'cancelled'
+  ) THEN
+    UPDATE public.bookings
+       SET status = 'cancelled',
+           cancelled_at = NOW(),
+           cancelled_by = p_cancelled_by,
+           cancellation_reason = p_reason,
+           updated_by = p_cancelled_by,
+           updated_at = NOW()
+     WHERE id = v_apt.booking_id AND status <> 'cancelled';
+  END IF;
+
+  RETURN to_jsonb(v_apt);
+END;
+$$;
+
+-- Cancel a booking with all of its active appointments. Rows are kept for
+-- reporting; only their status and cancellation details change.
+CREATE OR REPLACE FUNCTION public.cancel_booking(
+  p_booking_id UUID,
+  p_cancelled_by UUID,
+  p_reason TEXT
+) RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_booking public.bookings;
+  v_apt public.appointments;
+BEGIN
+  SELECT * INTO v_booking FROM public.bookings WHERE id = p_booking_id FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'booking_not_found:%', p_booking_id;
+  END IF;
+  IF v_booking.status = 'cancelled' THEN
+    RAISE EXCEPTION 'already_cancelled:%', p_booking_id;
+  END IF;
+
+  FOR v_apt IN
+    SELECT * FROM public.appointments
+     WHERE booking_id = p_booking_id AND status <> 'cancelled'
+     ORDER BY time_slot_id
+       FOR UPDATE
+  LOOP
+    PERFORM public.release_time_slot(v_apt.time_slot_id);
+  END LOOP;
+
+  UPDATE public.appointments
+     SET status = 'cancelled',
+         cancelled_at = NOW(),
+         cancelled_by = p_cancelled_by,
+         cancellation_reason = p_reason,
+         updated_at = NOW()
+   WHERE booking_id = p_booking_id AND status <> 'cancelled';
+
+  UPDATE public.bookings
+     SET status = 'cancelled',
+         cancelled_at = NOW(),
+         cancelled_by = p_cancelled_by,
+         cancellation_reason = p_reason,
+         updated_by = p_cancelled_by,
+         updated_at = NOW()
+   WHERE id = p_booking_id
+  RETURNING * INTO v_booking;
+
+  RETURN to_jsonb(v_booking);
+END;
+$$;
+
+-- Service role only; see 005.
+REVOKE ALL ON FUNCTION public.release_time_slot(UUID) FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.cancel_appointment(UUID, UUID, TEXT) FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.cancel_booking(UUID, UUID, TEXT) FROM PUBLIC, anon, authenticated;
+GRANT EXECUTE ON FUNCTION public.release_time_slot(UUID) TO service_role;
+GRANT EXECUTE ON FUNCTION public.cancel_appointment(UUID, UUID, TEXT) TO service_role;
+GRANT EXECUTE ON FUNCTION public.cancel_booking(UUID, UUID, TEXT) TO service_role;
@@ -0,0 +1,109 @@
+-- Migration: Booking status machine
+-- Description: Status changes for bookings and appointments with appointment roll-up
+-- Allowed transitions and role checks are enforced by the API (models/booking_status.go).
+
+-- Derive a booking's status from its appointments.
+CREATE OR REPLACE FUNCTION public.rollup_booking_status(p_booking_id UUID)
+RETURNS TEXT
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_statuses TEXT[];
+BEGIN
+  SELECT array_agg(status) INTO v_statuses
+    FROM public.appointments
+   WHERE booking_id = p_booking_id AND status <> 'cancelled';
+
+  IF v_statuses IS NULL THEN
+    RETURN 'cancelled';
+  ELSIF 'no_show' = ALL(v_statuses) THEN
+    RETURN 'no_show';
+  ELSIF v_statuses <@ ARRAY['completed', 'no_show'] THEN
+    RETURN 'completed';
+  ELSIF v_statuses && ARRAY['checked_in', 'completed'] THEN
+    RETURN 'checked_in';
+  ELSIF 'pending' = ANY(v_statuses) THEN
+    RETURN 'pending';
+  END IF;
+  RETURN 'confirmed';
+END;
+$$;
+
+-- Move a booking to a new status. Appointments that were in the booking's
+-- previous status follow it. p_expected_status guards against a concurrent
+-- change between the API's transition check and this update.
+CREATE OR REPLACE FUNCTION public.set_booking_status(
+  p_booking_id UUID,
+  p_expected_status TEXT,
+  p_status TEXT,
+  p_updated_by UUID
+) RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_booking public.bookings;
+BEGIN
+  SELECT * INTO v_booking FROM public.bookings WHERE id = p_booking_id FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'booking_not_found:%', p_booking_id;
+  END IF;
+  IF v_booking.status <> p_expected_status THEN
+    RAISE EXCEPTION 'status_changed:%', p_booking_id;
+  END IF;
+
+  UPDATE public.appointments
+     SET status = p_status, updated_at = NOW()
+   WHERE booking_id = p_booking_id AND status = p_expected_status;
+
+  UPDATE public.bookings
+     SET status = p_status, updated_by = p_updated_by, updated_at = NOW()
+   WHERE id = p_booking_id
+  RETURNING * INTO v_booking;
+
+  RETURN to_jsonb(v_booking);
+END;
+$$;
+
+-- Move one appointment to a new status and roll the change up to its booking.
+CREATE OR REPLACE FUNCTION public.set_appointment_status(
+  p_appointment_id UUID,
+  p_expected_status TEXT,
+  p_status TEXT,
+  p_updated_by UUID
+) RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_apt public.appointments;
+BEGIN
+  SELECT * INTO v_apt FROM public.appointments WHERE id = p_appointment_id FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'appointment_not_found:%', p_appointment_id;
+  END IF;
+  IF v_apt.status <> p_expected_status THEN
+    RAISE EXCEPTION 'status_changed:%', p_appointment_id;
+  END IF;
+
+  UPDATE public.appointments
+     SET status = p_status, updated_at = NOW()
+   WHERE id = p_appointment_id
+  RETURNING * INTO v_apt;
+
+  UPDATE public.bookings
+     SET status = public.rollup_booking_status(v_apt.booking_id),
+         updated_by = p_updated_by,
+         updated_at = NOW()
+   WHERE id = v_apt.booking_id;
+
+  RETURN to_jsonb(v_apt);
+END;
+$$;
+
+-- The API checks transitions before calling these, so clients must not
+-- reach them directly.
+REVOKE ALL ON FUNCTION public.rollup_booking_status(UUID) FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.set_booking_status(UUID, TEXT, TEXT, UUID) FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.set_appointment_status(UUID, TEXT, TEXT, UUID) FROM PUBLIC, anon, authenticated;
+GRANT EXECUTE ON FUNCTION public.rollup_booking_status(UUID) TO service_role;
+GRANT EXECUTE ON FUNCTION public.set_booking_status(UUID, TEXT, TEXT, UUID) TO service_role;
+GRANT EXECUTE ON FUNCTION public.set_appointment_status(UUID, TEXT, TEXT, UUID) TO service_role;
@@ -0,0 +1,170 @@
+-- Migration: Nurse booking management
+-- Description: Soft delete bookings and edit booking appointments atomically
+
+ALTER TABLE public.bookings
+ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE,
+ADD COLUMN IF NOT EXISTS deleted_by UUID REFERENCES public.users(id),
+ADD COLUMN IF NOT EXISTS deletion_reason TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_bookings_deleted_at ON public.bookings(deleted_at);
+
+-- Apply a list of appointment changes to a booking in one transaction.
+-- Each element of p_changes is one of:
+--   {"appointment_id": ..., "remove": true, "reason": ...}          cancel the appointment
+--   {"appointment_id": ..., "time_slot_id": ..., "doctor_id": ...}  move it to another slot
+--   {"time_slot_id": ..., "doctor_id": ..., "service_type": ...}    add a new appointment
+-- service_type and location are updated when present. When p_appointment_date
+-- is given every active appointment must end up on a slot of that date.
+CREATE OR REPLACE FUNCTION public.update_booking_appointments(
+  p_booking_id UUID,
+  p_updated_by UUID,
+  p_appointment_date DATE,
+  p_changes JSONB
+) RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_booking public.bookings;
+  v_apt public.appointments;
+  v_change JSONB;
+  v_slot_id UUID;
+  v_doctor_id UUID;
+  v_mismatch UUID;
+BEGIN
+  SELECT * INTO v_booking FROM public.bookings
+   WHERE id = p_booking_id AND deleted_at IS NULL
+     FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'booking_not_found:%', p_booking_id;
+  END IF;
+  IF v_booking.status NOT IN ('pending', 'confirmed') THEN
+    RAISE EXCEPTION 'booking_not_editable:%', p_booking_id;
+  END IF;
+
+  IF p_appointment_date IS NOT NULL THEN
+    UPDATE public.bookings SET appointment_date = p_appointment_date
+     WHERE id = p_booking_id
+    RETURNING * INTO v_booking;
+  END IF;
+
+  FOR v_change IN SELECT value FROM jsonb_array_elements(COALESCE(p_changes, '[]'::JSONB))
+  LOOP
+    IF v_change ? 'appointment_id' THEN
+      SELECT * INTO v_apt FROM public.appointments
+       WHERE id = (v_change->>'appointment_id')::UUID
+         AND booking_id = p_booking_id
+         AND status <> 'cancelled'
+         FOR UPDATE;
+      IF NOT FOUND THEN
+        RAISE EXCEPTION 'appointment_not_found:%', v_change->>'appointment_id';
+      END IF;
+
+      IF COALESCE((v_change->>'remove')::BOOLEAN, FALSE) THEN
+        PERFORM public.release_time_slot(v_apt.time_slot_id);
+        UPDATE public.appointments
+           SET status = 'cancelled',
+               cancelled_at = NOW(),
+               cancelled_by = p_updated_by,
+               cancellation_reason = v_change->>'reason',
+               updated_at = NOW()
+         WHERE id = v_apt.id;
+        CONTINUE;
+      END IF;
+
+      v_slot_id := COALESCE((v_change->>'time_slot_id')::UUID, v_apt.time_slot_id);
+      v_doctor_id := COALESCE((v_change->>'doctor_id')::UUID, v_apt.doctor_id);
+      IF v_slot_id <> v_apt.time_slot_id OR v_doctor_id <> v_apt.doctor_id THEN
+        PERFORM public.release_time_slot(v_apt.time_slot_id);
+        PERFORM public.reserve_time_slot(v_slot_id, v_doctor_id, v_booking.appointment_date);
+      END IF;
+
+      UPDATE public.appointments
+         SET time_slot_id = v_slot_id,
+             doctor_id = v_doctor_id,
+             service_type = COALESCE(v_change->>'service_type', service_type),
+             location = CASE WHEN v_change ? 'location' THEN v_change->>'location' ELSE location END,
+             updated_at = NOW()
+       WHERE id = v_apt.id;
+    ELSE
+      PERFORM public.reserve_time_slot(
+        (v_change->>'time_slot_id')::UUID,
+        (v_change->>'doctor_id')::UUID,
+        v_booking.appointment_date
+      );
+      INSERT INTO public.appointments (booking_id, time_slot_id, doctor_id, service_type, location, status)
+      VALUES (
+        p_booking_id,
+        (v_change->>'time_slot_id')::UUID,
+        (v_change->>'doctor_id')::UUID,
+        v_change->>'service_type',
+        v_change->>'location',
+        v_booking.status
+      );
+    END IF;
+  END LOOP;
+
+  -- Appointments left on another day after a date change
+  SELECT a.time_slot_id INTO v_mismatch
+    FROM public.appointments a
+    JOIN public.time_slots ts ON ts.id = a.time_slot_id
+    JOIN public.doctor_schedules ds ON ds.id = ts.doctor_schedule_id
+   WHERE a.booking_id = p_booking_id
+     AND a.status <> 'cancelled'
+     AND ds.schedule_date <> v_booking.appointment_date
+   LIMIT 1;
+  IF FOUND THEN
+    RAISE EXCEPTION 'slot_date_mismatch:%', v_mismatch;
+  END IF;
+
+  UPDATE public.bookings
+     SET status = public.rollup_booking_status(p_booking_id),
+         updated_by = p_updated_by,
+         updated_at = NOW()
+   WHERE id = p_booking_id
+  RETURNING * INTO v_booking;
+
+  RETURN to_jsonb(v_booking);
+END;
+$$;
+
+-- Hide a booking from normal listings. Open bookings are cancelled first
+-- so their slots are released; the row itself is kept for reporting.
+CREATE OR REPLACE FUNCTION public.soft_delete_booking(
+  p_booking_id UUID,
+  p_deleted_by UUID,
+  p_reason TEXT
+) RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_booking public.bookings;
+BEGIN
+  SELECT * INTO v_booking FROM public.bookings
+   WHERE id = p_booking_id AND deleted_at IS NULL
+     FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'booking_not_found:%', p_booking_id;
+  END IF;
+
+  IF v_booking.status IN ('pending', 'confirmed') THEN
+    PERFORM public.cancel_booking(p_booking_id, p_deleted_by, p_reason);
+  END IF;
+
+  UPDATE public.bookings
+     SET deleted_at = NOW(),
+         deleted_by = p_deleted_by,
+         deletion_reason = p_reason,
+         updated_by = p_deleted_by,
+         updated_at = NOW()
+   WHERE id = p_booking_id
+  RETURNING * INTO v_booking;
+
+  RETURN to_jsonb(v_booking);
+END;
+$$;
+
+-- Nurse-only operations; callable by the API's service role alone.
+REVOKE ALL ON FUNCTION public.update_booking_appointments(UUID, UUID, DATE, JSONB) FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.soft_delete_booking(UUID, UUID, TEXT) FROM PUBLIC, anon, authenticated;
+GRANT EXECUTE ON FUNCTION public.update_booking_appointments(UUID, UUID, DATE, JSONB) TO service_role;
+GRANT EXECUTE ON FUNCTION public.soft_delete_booking(UUID, UUID, TEXT) TO service_role;
@@ -0,0 +1,115 @@
+-- Migration: Nurse dashboard
+-- Description: Daily operational metrics for the nurse dashboard (based on section 9 of supabase_queries.sql)
+
+CREATE OR REPLACE FUNCTION public.get_nurse_dashboard(
+  p_date_from DATE,
+  p_date_to DATE,
+  p_now TIMESTAMP WITH TIME ZONE,
+  p_timezone TEXT
+) RETURNS JSONB
+LANGUAGE plpgsql
+STABLE
+AS $$
+DECLARE
+  v_local_now TIMESTAMP := p_now AT TIME ZONE p_timezone;
+  v_by_status JSONB;
+  v_total INTEGER;
+  v_utilisation JSONB;
+  v_upcoming JSONB;
+  v_by_company JSONB;
+  v_no_show INTEGER;
+  v_attended INTEGER;
+BEGIN
+  -- Bookings by status
+  SELECT COALESCE(jsonb_object_agg(status, cnt), '{}'::JSONB), COALESCE(SUM(cnt), 0)
+    INTO v_by_status, v_total
+    FROM (
+      SELECT status, COUNT(*) AS cnt
+        FROM public.bookings
+       WHERE appointment_date BETWEEN p_date_from AND p_date_to
+         AND deleted_at IS NULL
+       GROUP BY status
+    ) s;
+
+  -- Booked seats vs. capacity per doctor
+  SELECT COALESCE(jsonb_agg(u ORDER BY u.doctor_name), '[]'::JSONB)
+    INTO v_utilisation
+    FROM (
+      SELECT d.id AS doctor_id,
+             d.full_name AS doctor_name,
+             SUM(ts.current_bookings)::INTEGER AS booked,
+             SUM(ts.max_capacity)::INTEGER AS capacity,
+             CASE WHEN SUM(ts.max_capacity) > 0
+                  THEN ROUND(SUM(ts.current_bookings)::NUMERIC / SUM(ts.max_capacity), 4)
+                  ELSE 0 END AS utilisation
+        FROM public.doctors d
+        JOIN public.doctor_schedules ds ON ds.doctor_id = d.id
+        JOIN public.time_slots ts ON ts.doctor_schedule_id = ds.id
+       WHERE ds.schedule_date BETWEEN p_date_from AND p_date_to
+         AND ts.status NOT IN ('blocked', 'inactive')
+       GROUP BY d.id, d.full_name
+    ) u;
+
+  -- Appointments starting within the next hour that still need check-in
+  SELECT COALESCE(jsonb_agg(x ORDER BY x.schedule_date, x.start_time), '[]'::JSONB)
+    INTO v_upcoming
+    FROM (
+      SELECT a.id AS appointment_id,
+             b.id AS booking_id,
+             b.booking_number,
+             u.full_name AS customer_name,
+             u.phone AS customer_phone,
+             d.full_name AS doctor_name,
+             a.service_type,
+             a.status,
+             ds.schedule_date,
+             ts.start_time
+        FROM public.appointments a
+        JOIN public.bookings b ON b.id = a.booking_id
+        JOIN public.users u ON u.id = b.customer_id
+        JOIN public.doctors d ON d.id = a.doctor_id
+        JOIN public.time_slots ts ON ts.id = a.time_slot_id
+        JOIN public.doctor_schedules ds ON ds.id = ts.doctor_schedule_id
+       WHERE a.status IN ('pending', 'confirmed')
+         AND b.deleted_at IS NULL
+         AND ds.schedule_date + ts.start_time BETWEEN v_local_now AND v_local_now + INTERVAL '1 hour'
+    ) x;
+
+  -- No-show rate over appointments that have reached an outcome
+  SELECT COUNT(*) FILTER (WHERE a.status = 'no_show'),
+         COUNT(*) FILTER (WHERE a.status IN ('checked_in', 'completed', 'no_show'))
+    INTO v_no_show, v_attended
+    FROM public.appointments a
+    JOIN public.bookings b ON b.id = a.booking_id
+   WHERE b.appointment_date BETWEEN p_date_from AND p_date_to
+     AND b.deleted_at IS NULL;
+
+  -- Bookings by company
+  SELECT COALESCE(jsonb_agg(c ORDER BY c.booking_count DESC), '[]'::JSONB)
+    INTO v_by_company
+    FROM (
+      SELECT COALESCE(u.company_name, '') AS company_name, COUNT(b.id)::INTEGER AS booking_count
+        FROM public.bookings b
+        JOIN public.users u ON u.id = b.customer_id
+       WHERE b.appointment_date BETWEEN p_date_from AND p_date_to
+         AND b.deleted_at IS NULL
+       GROUP BY COALESCE(u.company_name, '')
+    ) c;
+
+  RETURN jsonb_build_object(
+    'date_from', p_date_from,
+    'date_to', p_date_to,
+    'total_bookings', v_total,
+    'bookings_by_status', v_by_status,
+    'doctor_utilisation', v_utilisation,
+    'upcoming_check_ins', v_upcoming,
+    'no_show_count', v_no_show,
+    'no_show_rate', CASE WHEN v_attended > 0 THEN ROUND(v_no_show::NUMERIC / v_attended, 4) ELSE 0 END,
+    'bookings_by_company', v_by_company
+  );
+END;
+$$;
+
+-- Dashboard figures cover every customer; service role only.
+REVOKE ALL ON FUNCTION public.get_nurse_dashboard(DATE, DATE, TIMESTAMP WITH TIME ZONE, TEXT) FROM PUBLIC, anon, authenticated;
+GRANT EXECUTE ON FUNCTION public.get_nurse_dashboard(DATE, DATE, TIMESTAMP WITH TIME ZONE, TEXT) TO service_role;
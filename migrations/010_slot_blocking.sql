@@ -0,0 +1,129 @@
+-- Migration: Bulk slot blocking
+-- Description: Block and unblock time slots in bulk and flag appointments that need rescheduling
+
+ALTER TABLE public.time_slots
+ADD COLUMN IF NOT EXISTS blocked_at TIMESTAMP WITH TIME ZONE,
+ADD COLUMN IF NOT EXISTS blocked_by UUID REFERENCES public.users(id),
+ADD COLUMN IF NOT EXISTS blocked_reason TEXT;
+
+ALTER TABLE public.appointments
+ADD COLUMN IF NOT EXISTS needs_reschedule BOOLEAN NOT NULL DEFAULT FALSE,
+ADD COLUMN IF NOT EXISTS reschedule_reason TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_appointments_needs_reschedule
+  ON public.appointments(needs_reschedule) WHERE needs_reschedule;
+
+-- Moving an appointment to another slot resolves its reschedule flag.
+CREATE OR REPLACE FUNCTION public.clear_needs_reschedule()
+RETURNS TRIGGER
+LANGUAGE plpgsql
+AS $$
+BEGIN
+  IF NEW.time_slot_id IS DISTINCT FROM OLD.time_slot_id THEN
+    NEW.needs_reschedule := FALSE;
+    NEW.reschedule_reason := NULL;
+  END IF;
+  RETURN NEW;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS trg_appointments_clear_needs_reschedule ON public.appointments;
+CREATE TRIGGER trg_appointments_clear_needs_reschedule
+  BEFORE UPDATE OF time_slot_id ON public.appointments
+  FOR EACH ROW EXECUTE FUNCTION public.clear_needs_reschedule();
+
+-- Block a set of slots. Without p_force the call fails if any slot still has
+-- active appointments; with it those appointments are flagged for
+-- rescheduling. Returns the flagged appointments so customers can be told.
+CREATE OR REPLACE FUNCTION public.block_time_slots(
+  p_slot_ids UUID[],
+  p_blocked_by UUID,
+  p_reason TEXT,
+  p_force BOOLEAN
+) RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_busy UUID;
+  v_blocked INTEGER;
+  v_affected JSONB;
+BEGIN
+  PERFORM 1 FROM public.time_slots WHERE id = ANY(p_slot_ids) ORDER BY id FOR UPDATE;
+
+  IF NOT p_force THEN
+    SELECT time_slot_id INTO v_busy
+      FROM public.appointments
+     WHERE time_slot_id = ANY(p_slot_ids) AND status IN ('pending', 'confirmed')
+     LIMIT 1;
+    IF FOUND THEN
+      RAISE EXCEPTION 'slot_has_bookings:%', v_busy;
+    END IF;
+  END IF;
+
+  UPDATE public.time_slots
+     SET status = 'blocked',
+         blocked_at = NOW(),
+         blocked_by = p_blocked_by,
+         blocked_reason = p_reason,
+         updated_at = NOW()
+   WHERE id = ANY(p_slot_ids) AND status <> 'blocked';
+  GET DIAGNOSTICS v_blocked = ROW_COUNT;
+
+  WITH flagged AS (
+    UPDATE public.appointments
+       SET needs_reschedule = TRUE,
+           reschedule_reason = p_reason,
+           updated_at = NOW()
+     WHERE time_slot_id = ANY(p_slot_ids) AND status IN ('pending', 'confirmed')
+    RETURNING id, booking_id, time_slot_id
+  )
+  SELECT COALESCE(jsonb_agg(jsonb_build_object(
+           'appointment_id', f.id,
+           'booking_id', f.booking_id,
+           'booking_number', b.booking_number,
+           'time_slot_id', f.time_slot_id,
+           'schedule_date', ds.schedule_date,
+           'start_time', ts.start_time,
+           'customer_id', u.id,
+           'customer_name', u.full_name,
+           'customer_phone', u.phone
+         )), '[]'::JSONB)
+    INTO v_affected
+    FROM flagged f
+    JOIN public.bookings b ON b.id = f.booking_id
+    JOIN public.users u ON u.id = b.customer_id
+    JOIN public.time_slots ts ON ts.id = f.time_slot_id
+    JOIN public.doctor_schedules ds ON ds.id = ts.doctor_schedule_id;
+
+  RETURN jsonb_build_object('blocked_count', v_blocked, 'affected_appointments', v_affected);
+END;
+$$;
+
+-- Reopen blocked slots. Slots that are already at capacity become 'booked'.
+CREATE OR REPLACE FUNCTION public.unblock_time_slots(p_slot_ids UUID[])
+RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_unblocked INTEGER;
+BEGIN
+  UPDATE public.time_slots
+     SET status = CASE WHEN current_bookings >= max_capacity THEN 'booked' ELSE 'available' END,
+         blocked_at = NULL,
+         blocked_by = NULL,
+         blocked_reason = NULL,
+         updated_at = NOW()
+   WHERE id = ANY(p_slot_ids) AND status = 'blocked';
+  GET DIAGNOSTICS v_unblocked = ROW_COUNT;
+
+  RETURN jsonb_build_object('unblocked_count', v_unblocked);
+END;
+$$;
+
+-- Service role only. The trigger function needs no grant: EXECUTE is
+-- checked when the trigger is created, not when it fires.
+REVOKE ALL ON FUNCTION public.block_time_slots(UUID[], UUID, TEXT, BOOLEAN) FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.unblock_time_slots(UUID[]) FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.clear_needs_reschedule() FROM PUBLIC, anon, authenticated;
+GRANT EXECUTE ON FUNCTION public.block_time_slots(UUID[], UUID, TEXT, BOOLEAN) TO service_role;
+GRANT EXECUTE ON FUNCTION public.unblock_time_slots(UUID[]) TO service_role;
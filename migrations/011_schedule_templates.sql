@@ -0,0 +1,91 @@
+-- Migration: Recurring schedule templates
+-- Description: Weekly per-doctor schedule patterns that generate doctor_schedules and time_slots
+
+CREATE TABLE IF NOT EXISTS public.schedule_templates (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  doctor_id UUID NOT NULL REFERENCES public.doctors(id) ON DELETE CASCADE,
+  day_of_week SMALLINT NOT NULL CHECK (day_of_week BETWEEN 0 AND 6), -- 0 = Sunday
+  start_time TIME NOT NULL,
+  end_time TIME NOT NULL,
+  slot_minutes INTEGER NOT NULL CHECK (slot_minutes > 0),
+  max_capacity INTEGER NOT NULL DEFAULT 1 CHECK (max_capacity > 0),
+  breaks JSONB NOT NULL DEFAULT '[]'::JSONB, -- [{"start_time": "12:00", "end_time": "13:00"}]
+  effective_from DATE,
+  effective_to DATE,
+  is_active BOOLEAN NOT NULL DEFAULT TRUE,
+  created_by UUID REFERENCES public.users(id),
+  updated_by UUID REFERENCES public.users(id),
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  CHECK (end_time > start_time)
+);
+
+CREATE INDEX IF NOT EXISTS idx_schedule_templates_doctor ON public.schedule_templates(doctor_id);
+
+-- Generation is idempotent because a doctor has at most one schedule per day
+-- and a schedule at most one slot per start time. Existing duplicates must be
+-- merged before these indexes can be created.
+CREATE UNIQUE INDEX IF NOT EXISTS uq_doctor_schedules_doctor_date
+  ON public.doctor_schedules(doctor_id, schedule_date);
+CREATE UNIQUE INDEX IF NOT EXISTS uq_time_slots_schedule_start
+  ON public.time_slots(doctor_schedule_id, start_time);
+
+-- Materialise a generated plan. Days and slots that already exist are left
+-- untouched, so bookings, blocks and manual edits survive a re-run.
+-- p_plan: [{"doctor_id", "schedule_date", "day_of_week",
+--           "slots": [{"start_time", "end_time", "max_capacity"}]}]
+CREATE OR REPLACE FUNCTION public.apply_schedule_plan(p_plan JSONB)
+RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_day JSONB;
+  v_schedule_id UUID;
+  v_rows INTEGER;
+  v_schedules INTEGER := 0;
+  v_slots INTEGER := 0;
+BEGIN
+  FOR v_day IN SELECT value FROM jsonb_array_elements(COALESCE(p_plan, '[]'::JSONB))
+  LOOP
+    v_schedule_id := NULL;
+
+    INSERT INTO public.doctor_schedules (doctor_id, schedule_date, day_of_week, is_available)
+    VALUES (
+      (v_day->>'doctor_id')::UUID,
+      (v_day->>'schedule_date')::DATE,
+      v_day->>'day_of_week',
+      TRUE
+    )
+    ON CONFLICT (doctor_id, schedule_date) DO NOTHING
+    RETURNING id INTO v_schedule_id;
+
+    IF v_schedule_id IS NULL THEN
+      SELECT id INTO v_schedule_id
+        FROM public.doctor_schedules
+       WHERE doctor_id = (v_day->>'doctor_id')::UUID
+         AND schedule_date = (v_day->>'schedule_date')::DATE;
+    ELSE
+      v_schedules := v_schedules + 1;
+    END IF;
+
+    INSERT INTO public.time_slots (doctor_schedule_id, start_time, end_time, status, max_capacity, current_bookings)
+    SELECT v_schedule_id,
+           (s->>'start_time')::TIME,
+           (s->>'end_time')::TIME,
+           'available',
+           (s->>'max_capacity')::INTEGER,
+           0
+      FROM jsonb_array_elements(COALESCE(v_day->'slots', '[]'::JSONB)) s
+    ON CONFLICT (doctor_schedule_id, start_time) DO NOTHING;
+    GET DIAGNOSTICS v_rows = ROW_COUNT;
+    v_slots := v_slots + v_rows;
+  END LOOP;
+
+  RETURN jsonb_build_object('schedules_created', v_schedules, 'slots_created', v_slots);
+END;
+$$;
+
+-- Templates are only managed through the API.
+ALTER TABLE public.schedule_templates ENABLE ROW LEVEL SECURITY;
+REVOKE ALL ON FUNCTION public.apply_schedule_plan(JSONB) FROM PUBLIC, anon, authenticated;
+GRANT EXECUTE ON FUNCTION public.apply_schedule_plan(JSONB) TO service_role;
@@ -0,0 +1,280 @@
+-- Migration: Doctor management
+-- Description: Specialty catalogue, multi-specialty doctors, doctor deactivation and next available slot lookup
+
+CREATE TABLE IF NOT EXISTS public.specialties (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  name TEXT NOT NULL UNIQUE,
+  name_en TEXT,
+  description TEXT,
+  is_active BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS public.doctor_specialties (
+  doctor_id UUID NOT NULL REFERENCES public.doctors(id) ON DELETE CASCADE,
+  specialty_id UUID NOT NULL REFERENCES public.specialties(id),
+  is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  PRIMARY KEY (doctor_id, specialty_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_doctor_specialties_specialty ON public.doctor_specialties(specialty_id);
+
+ALTER TABLE public.doctors
+ADD COLUMN IF NOT EXISTS deactivated_at TIMESTAMP WITH TIME ZONE,
+ADD COLUMN IF NOT EXISTS deactivated_by UUID REFERENCES public.users(id),
+ADD COLUMN IF NOT EXISTS updated_by UUID REFERENCES public.users(id);
+
+-- Seed the catalogue from the free text specialties already in use.
+INSERT INTO public.specialties (name)
+SELECT DISTINCT specialty FROM public.doctors
+ WHERE specialty IS NOT NULL AND specialty <> ''
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.doctor_specialties (doctor_id, specialty_id, is_primary)
+SELECT d.id, s.id, TRUE
+  FROM public.doctors d
+  JOIN public.specialties s ON s.name = d.specialty
+ON CONFLICT DO NOTHING;
+
+-- Keep doctors.specialty in step when a primary specialty is renamed.
+CREATE OR REPLACE FUNCTION public.sync_doctor_specialty_name()
+RETURNS TRIGGER
+LANGUAGE plpgsql
+AS $$
+BEGIN
+  UPDATE public.doctors d
+     SET specialty = NEW.name,
+         updated_at = NOW()
+    FROM public.doctor_specialties dsp
+   WHERE dsp.doctor_id = d.id
+     AND dsp.specialty_id = NEW.id
+     AND dsp.is_primary;
+  RETURN NEW;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS trg_specialties_sync_doctor_name ON public.specialties;
+CREATE TRIGGER trg_specialties_sync_doctor_name
+  AFTER UPDATE OF name ON public.specialties
+  FOR EACH ROW
+  WHEN (NEW.name IS DISTINCT FROM OLD.name)
+  EXECUTE FUNCTION public.sync_doctor_specialty_name();
+
+-- Create or update a doctor together with their specialties. p_doctor_id is
+-- NULL for a new doctor. When p_specialty_ids is given it replaces the
+-- doctor's specialties; the first one becomes the primary specialty and is
+-- copied to doctors.specialty for display.
+CREATE OR REPLACE FUNCTION public.save_doctor(
+  p_doctor_id UUID,
+  p_full_name TEXT,
+  p_title TEXT,
+  p_specialty_ids UUID[],
+  p_is_active BOOLEAN,
+  p_updated_by UUID
+) RETURNS public.doctors
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_doctor public.doctors;
+  v_missing UUID;
+  v_primary TEXT;
+BEGIN
+  IF p_specialty_ids IS NOT NULL THEN
+    IF cardinality(p_specialty_ids) = 0 THEN
+      RAISE EXCEPTION 'no_specialties';
+    END IF;
+
+    SELECT req.specialty_id INTO v_missing
+      FROM unnest(p_specialty_ids) AS req(specialty_id)
+     WHERE NOT EXISTS (
+       SELECT 1 FROM public.specialties s WHERE s.id = req.specialty_id AND s.is_active
+     )
+     LIMIT 1;
+    IF FOUND THEN
+      RAISE EXCEPTION 'specialty_not_found:%', v_missing;
+    END IF;
+
+    SELECT name INTO v_primary FROM public.specialties WHERE id = p_specialty_ids[1];
+  END IF;
+
+  IF p_doctor_id IS NULL THEN
+    IF p_specialty_ids IS NULL THEN
+      RAISE EXCEPTION 'no_specialties';
+    END IF;
+
+    INSERT INTO public.doctors (full_name, title, specialty, is_active, updated_by)
+    VALUES (p_full_name, p_title, v_primary, COALESCE(p_is_active, TRUE), p_updated_by)
+    RETURNING * INTO v_doctor;
+  ELSE
+    UPDATE public.doctors
+       SET full_name = COALESCE(p_full_name, full_name),
+           title = COALESCE(p_title, title),
+           specialty = COALESCE(v_primary, specialty),
+           is_active = COALESCE(p_is_active, is_active),
+           deactivated_at = CASE WHEN p_is_active THEN NULL ELSE deactivated_at END,
+           deactivated_by = CASE WHEN p_is_active THEN NULL ELSE deactivated_by END,
+           updated_by = p_updated_by,
+           updated_at = NOW()
+     WHERE id = p_doctor_id
+    RETURNING * INTO v_doctor;
+
+    IF NOT FOUND THEN
+      RAISE EXCEPTION 'doctor_not_found:%', p_doctor_id;
+    END IF;
+  END IF;
+
+  IF p_specialty_ids IS NOT NULL THEN
+    DELETE FROM public.doctor_specialties WHERE doctor_id = v_doctor.id;
+    INSERT INTO public.doctor_specialties (doctor_id, specialty_id, is_primary)
+    SELECT v_doctor.id, s.id, s.ord = 1
+      FROM unnest(p_specialty_ids) WITH ORDINALITY AS s(id, ord)
+    ON CONFLICT DO NOTHING;
+  END IF;
+
+  RETURN v_doctor;
+END;
+$$;
+
+-- Deactivate a doctor. Upcoming pending or confirmed appointments block the
+-- deactivation unless p_reassign_to names another active doctor, in which
+-- case each appointment moves to that doctor's slot with the same date and
+-- start time. Future schedules of the deactivated doctor are closed.
+CREATE OR REPLACE FUNCTION public.deactivate_doctor(
+  p_doctor_id UUID,
+  p_deactivated_by UUID,
+  p_reassign_to UUID,
+  p_today DATE
+) RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_doctor public.doctors;
+  v_apt RECORD;
+  v_count INTEGER;
+  v_slot_id UUID;
+  v_reassigned INTEGER := 0;
+BEGIN
+  SELECT * INTO v_doctor FROM public.doctors WHERE id = p_doctor_id FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'doctor_not_found:%', p_doctor_id;
+  END IF;
+  IF NOT v_doctor.is_active THEN
+    RAISE EXCEPTION 'doctor_inactive:%', p_doctor_id;
+  END IF;
+
+  SELECT COUNT(*) INTO v_count
+    FROM public.appointments a
+    JOIN public.time_slots ts ON ts.id = a.time_slot_id
+    JOIN public.doctor_schedules ds ON ds.id = ts.doctor_schedule_id
+   WHERE a.doctor_id = p_doctor_id
+     AND a.status IN ('pending', 'confirmed')
+     AND ds.schedule_date >= p_today;
+
+  IF v_count > 0 AND p_reassign_to IS NULL THEN
+    RAISE EXCEPTION 'doctor_has_appointments:%', v_count;
+  END IF;
+
+  IF v_count > 0 THEN
+    IF p_reassign_to = p_doctor_id OR NOT EXISTS (
+      SELECT 1 FROM public.doctors WHERE id = p_reassign_to AND is_active
+    ) THEN
+      RAISE EXCEPTION 'reassign_doctor_invalid:%', p_reassign_to;
+    END IF;
+
+    FOR v_apt IN
+      SELECT a.id, a.time_slot_id, ds.schedule_date, ts.start_time
+        FROM public.appointments a
+        JOIN public.time_slots ts ON ts.id = a.time_slot_id
+        JOIN public.doctor_schedules ds ON ds.id = ts.doctor_schedule_id
+       WHERE a.doctor_id = p_doctor_id
+         AND a.status IN ('pending', 'confirmed')
+         AND ds.schedule_date >= p_today
+         FOR UPDATE OF a
+    LOOP
+      SELECT ts.id INTO v_slot_id
+        FROM public.time_slots ts
+        JOIN public.doctor_schedules ds ON ds.id = ts.doctor_schedule_id
+       WHERE ds.doctor_id = p_reassign_to
+         AND ds.schedule_date = v_apt.schedule_date
+         AND ts.start_time = v_apt.start_time;
+      IF NOT FOUND THEN
+        RAISE EXCEPTION 'no_matching_slot:%', v_apt.id;
+      END IF;
+
+      PERFORM public.release_time_slot(v_apt.time_slot_id);
+      PERFORM public.reserve_time_slot(v_slot_id, p_reassign_to, v_apt.schedule_date);
+      UPDATE public.appointments
+         SET time_slot_id = v_slot_id,
+             doctor_id = p_reassign_to,
+             updated_at = NOW()
+       WHERE id = v_apt.id;
+      v_reassigned := v_reassigned + 1;
+    END LOOP;
+  END IF;
+
+  UPDATE public.doctor_schedules
+     SET is_available = FALSE,
+         updated_at = NOW()
+   WHERE doctor_id = p_doctor_id
+     AND schedule_date >= p_today;
+
+  UPDATE public.doctors
+     SET is_active = FALSE,
+         deactivated_at = NOW(),
+         deactivated_by = p_deactivated_by,
+         updated_by = p_deactivated_by,
+         updated_at = NOW()
+   WHERE id = p_doctor_id;
+
+  RETURN jsonb_build_object('doctor_id', p_doctor_id, 'reassigned_count', v_reassigned);
+END;
+$$;
+
+-- Earliest bookable slot per doctor that starts after p_now in the clinic
+-- time zone.
+CREATE OR REPLACE FUNCTION public.get_next_available_slots(
+  p_doctor_ids UUID[],
+  p_now TIMESTAMP WITH TIME ZONE,
+  p_timezone TEXT
+) RETURNS JSONB
+LANGUAGE sql
+STABLE
+AS $$
+  SELECT COALESCE(jsonb_agg(n), '[]'::JSONB)
+    FROM (
+      SELECT DISTINCT ON (d.id)
+             ts.id AS time_slot_id,
+             d.id AS doctor_id,
+             d.full_name AS doctor_name,
+             d.title AS doctor_title,
+             d.specialty,
+             ds.schedule_date,
+             ts.start_time,
+             ts.end_time,
+             ts.max_capacity - ts.current_bookings AS available_slots
+        FROM public.doctors d
+        JOIN public.doctor_schedules ds ON ds.doctor_id = d.id
+        JOIN public.time_slots ts ON ts.doctor_schedule_id = ds.id
+       WHERE d.id = ANY(p_doctor_ids)
+         AND d.is_active
+         AND ds.is_available
+         AND ts.status NOT IN ('blocked', 'inactive')
+         AND ts.current_bookings < ts.max_capacity
+         AND ds.schedule_date + ts.start_time > (p_now AT TIME ZONE p_timezone)
+       ORDER BY d.id, ds.schedule_date, ts.start_time
+    ) n;
+$$;
+
+-- The specialty catalogue is edited through the API only, and doctors are
+-- saved and deactivated by the service role.
+ALTER TABLE public.specialties ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.doctor_specialties ENABLE ROW LEVEL SECURITY;
+REVOKE ALL ON FUNCTION public.save_doctor(UUID, TEXT, TEXT, UUID[], BOOLEAN, UUID) FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.deactivate_doctor(UUID, UUID, UUID, DATE) FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.get_next_available_slots(UUID[], TIMESTAMP WITH TIME ZONE, TEXT) FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.sync_doctor_specialty_name() FROM PUBLIC, anon, authenticated;
+GRANT EXECUTE ON FUNCTION public.save_doctor(UUID, TEXT, TEXT, UUID[], BOOLEAN, UUID) TO service_role;
+GRANT EXECUTE ON FUNCTION public.deactivate_doctor(UUID, UUID, UUID, DATE) TO service_role;
+GRANT EXECUTE ON FUNCTION public.get_next_available_slots(UUID[], TIMESTAMP WITH TIME ZONE, TEXT) TO service_role;
@@ -0,0 +1,11 @@
+-- Migration: SMS provider failover
+-- Description: Record which SMS provider delivered each OTP
+
+ALTER TABLE public.otp_audit_log
+ADD COLUMN IF NOT EXISTS provider VARCHAR(50);
+
+-- Managed OTP tokens must be validated by the provider that issued them.
+ALTER TABLE public.otp_codes
+ADD COLUMN IF NOT EXISTS provider VARCHAR(50);
+
+CREATE INDEX IF NOT EXISTS idx_audit_provider ON public.otp_audit_log(provider);
@@ -0,0 +1,101 @@
+-- Migration: Customer notifications
+-- Description: Queue of outbound SMS notifications with retries and de-duplication
+
+ALTER TABLE public.users
+ADD COLUMN IF NOT EXISTS preferred_language VARCHAR(2) NOT NULL DEFAULT 'th'
+  CHECK (preferred_language IN ('th', 'en'));
+
+CREATE TABLE IF NOT EXISTS public.notification_queue (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id UUID REFERENCES public.users(id) ON DELETE SET NULL,
+  phone VARCHAR(20) NOT NULL,
+  booking_id UUID REFERENCES public.bookings(id) ON DELETE SET NULL,
+  appointment_id UUID REFERENCES public.appointments(id) ON DELETE SET NULL,
+  event VARCHAR(50) NOT NULL,
+  language VARCHAR(2) NOT NULL DEFAULT 'th',
+  message TEXT NOT NULL,
+  dedupe_key TEXT NOT NULL UNIQUE,
+  status VARCHAR(20) NOT NULL DEFAULT 'pending'
+    CHECK (status IN ('pending', 'sending', 'sent', 'failed')),
+  attempts INTEGER NOT NULL DEFAULT 0,
+  max_attempts INTEGER NOT NULL DEFAULT 5,
+  next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  last_error TEXT,
+  provider VARCHAR(50),
+  message_id TEXT,
+  sent_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_queue_due
+  ON public.notification_queue(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_notification_queue_booking ON public.notification_queue(booking_id);
+CREATE INDEX IF NOT EXISTS idx_notification_queue_phone ON public.notification_queue(phone);
+
+-- Queue notifications, skipping any whose dedupe_key was already queued.
+-- Returns the number of rows actually inserted.
+CREATE OR REPLACE FUNCTION public.enqueue_notifications(p_notifications JSONB)
+RETURNS INTEGER
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_count INTEGER;
+BEGIN
+  INSERT INTO public.notification_queue (
+    user_id, phone, booking_id, appointment_id, event, language,
+    message, dedupe_key, max_attempts, next_attempt_at
+  )
+  SELECT (n->>'user_id')::UUID,
+         n->>'phone',
+         (n->>'booking_id')::UUID,
+         (n->>'appointment_id')::UUID,
+         n->>'event',
+         COALESCE(n->>'language', 'th'),
+         n->>'message',
+         n->>'dedupe_key',
+         COALESCE((n->>'max_attempts')::INTEGER, 5),
+         COALESCE((n->>'next_attempt_at')::TIMESTAMPTZ, NOW())
+    FROM jsonb_array_elements(p_notifications) AS n
+  ON CONFLICT (dedupe_key) DO NOTHING;
+
+  GET DIAGNOSTICS v_count = ROW_COUNT;
+  RETURN v_count;
+END;
+$$;
+
+-- Claim due notifications for sending. Rows are locked with SKIP LOCKED so
+-- several workers never pick the same message, and rows stuck in 'sending'
+-- (a worker died mid-send) are retried after p_stale_after.
+CREATE OR REPLACE FUNCTION public.claim_notifications(
+  p_limit INTEGER,
+  p_now TIMESTAMP WITH TIME ZONE,
+  p_stale_after INTERVAL DEFAULT INTERVAL '10 minutes'
+) RETURNS SETOF public.notification_queue
+LANGUAGE plpgsql
+AS $$
+BEGIN
+  RETURN QUERY
+  UPDATE public.notification_queue q
+     SET status = 'sending',
+         attempts = q.attempts + 1,
+         updated_at = p_now
+   WHERE q.id IN (
+     SELECT id FROM public.notification_queue
+      WHERE (status = 'pending' AND next_attempt_at <= p_now)
+         OR (status = 'sending' AND updated_at <= p_now - p_stale_after)
+      ORDER BY next_attempt_at
+      LIMIT p_limit
+        FOR UPDATE SKIP LOCKED
+   )
+  RETURNING q.*;
+END;
+$$;
+
+-- The queue holds customer phone numbers; only the API and its worker
+-- may read or claim it.
+ALTER TABLE public.notification_queue ENABLE ROW LEVEL SECURITY;
+REVOKE ALL ON FUNCTION public.enqueue_notifications(JSONB) FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.claim_notifications(INTEGER, TIMESTAMP WITH TIME ZONE, INTERVAL) FROM PUBLIC, anon, authenticated;
+GRANT EXECUTE ON FUNCTION public.enqueue_notifications(JSONB) TO service_role;
+GRANT EXECUTE ON FUNCTION public.claim_notifications(INTEGER, TIMESTAMP WITH TIME ZONE, INTERVAL) TO service_role;
@@ -0,0 +1,24 @@
+-- Migration: SMS delivery tracking
+-- Description: One row per SMS send attempt, updated by provider delivery reports
+
+CREATE TABLE IF NOT EXISTS public.sms_messages (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  provider VARCHAR(50) NOT NULL,
+  message_id TEXT,
+  phone VARCHAR(20) NOT NULL,
+  purpose VARCHAR(30) NOT NULL,
+  status VARCHAR(20) NOT NULL DEFAULT 'sent'
+    CHECK (status IN ('sent', 'send_failed', 'delivered', 'failed', 'expired')),
+  error TEXT,
+  report JSONB,
+  status_updated_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sms_messages_provider_message
+  ON public.sms_messages(provider, message_id) WHERE message_id IS NOT NULL AND message_id <> '';
+CREATE INDEX IF NOT EXISTS idx_sms_messages_phone_created
+  ON public.sms_messages(phone, created_at DESC);
+
+-- Message bodies and delivery reports are not for API clients.
+ALTER TABLE public.sms_messages ENABLE ROW LEVEL SECURITY;
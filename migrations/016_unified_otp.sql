@@ -0,0 +1,10 @@
+-- Migration: Unified OTP storage
+-- Description: Both OTP flows store the same row shape. Self-generated codes
+-- keep a bcrypt otp_hash; provider-managed codes keep the provider token in
+-- provider_token instead of overwriting id.
+
+ALTER TABLE public.otp_codes
+ADD COLUMN IF NOT EXISTS provider_token TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_otp_codes_phone_unused
+  ON public.otp_codes(phone, created_at DESC) WHERE NOT is_used;
@@ -0,0 +1,96 @@
+-- Migration: Atomic OTP rate limits and attempt counting
+-- Description: Fixed-window counters keyed by scope (user, phone, IP) that are
+-- checked and incremented in one statement, and OTP attempts that are
+-- claimed before the code is checked. Replaces the read-then-write logic on
+-- rate_limits, which is no longer used.
+
+CREATE TABLE IF NOT EXISTS public.rate_limit_counters (
+  key TEXT PRIMARY KEY,
+  hits INTEGER NOT NULL,
+  reset_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_counters_reset_at
+  ON public.rate_limit_counters(reset_at);
+
+-- Count one hit against each limit in p_limits, an array of
+-- {"key", "limit", "window_seconds"}. Every counter is incremented, so
+-- blocked callers keep counting until their window ends. Returns
+-- {"allowed", "exceeded", "retry_after_seconds"} where exceeded is the first
+-- key over its limit.
+CREATE OR REPLACE FUNCTION public.hit_rate_limits(
+  p_limits JSONB,
+  p_now TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+) RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_limit JSONB;
+  v_hits INTEGER;
+  v_reset TIMESTAMP WITH TIME ZONE;
+  v_exceeded TEXT;
+  v_retry INTEGER := 0;
+BEGIN
+  -- Lock counters in key order so concurrent calls cannot deadlock.
+  FOR v_limit IN
+    SELECT value FROM jsonb_array_elements(p_limits) ORDER BY value->>'key'
+  LOOP
+    INSERT INTO public.rate_limit_counters AS r (key, hits, reset_at)
+    VALUES (v_limit->>'key', 1,
+            p_now + make_interval(secs => (v_limit->>'window_seconds')::INTEGER))
+    ON CONFLICT (key) DO UPDATE
+       SET hits = CASE WHEN r.reset_at <= p_now THEN 1 ELSE r.hits + 1 END,
+           reset_at = CASE WHEN r.reset_at <= p_now THEN EXCLUDED.reset_at ELSE r.reset_at END
+    RETURNING hits, reset_at INTO v_hits, v_reset;
+
+    IF v_hits > (v_limit->>'limit')::INTEGER THEN
+      v_exceeded := COALESCE(v_exceeded, v_limit->>'key');
+      v_retry := GREATEST(v_retry, CEIL(EXTRACT(EPOCH FROM v_reset - p_now))::INTEGER);
+    END IF;
+  END LOOP;
+
+  -- Expired counters are only needed until their next hit.
+  IF random() < 0.01 THEN
+    DELETE FROM public.rate_limit_counters WHERE reset_at < p_now - INTERVAL '1 day';
+  END IF;
+
+  RETURN jsonb_build_object(
+    'allowed', v_exceeded IS NULL,
+    'exceeded', v_exceeded,
+    'retry_after_seconds', v_retry
+  );
+END;
+$$;
+
+-- Count a verification attempt before the code is checked so concurrent
+-- guesses cannot exceed p_max_attempts. Returns the attempt number, or NULL
+-- when the OTP is used or out of attempts.
+CREATE OR REPLACE FUNCTION public.claim_otp_attempt(
+  p_otp_id TEXT,
+  p_phone TEXT,
+  p_max_attempts INTEGER
+) RETURNS INTEGER
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_attempts INTEGER;
+BEGIN
+  UPDATE public.otp_codes
+     SET attempts = attempts + 1
+   WHERE phone = p_phone
+     AND id::TEXT = p_otp_id
+     AND NOT is_used
+     AND attempts < p_max_attempts
+  RETURNING attempts INTO v_attempts;
+
+  RETURN v_attempts;
+END;
+$$;
+
+-- Counting must not be reachable with the anon key, or a client could
+-- reset its own limits.
+ALTER TABLE public.rate_limit_counters ENABLE ROW LEVEL SECURITY;
+REVOKE ALL ON FUNCTION public.hit_rate_limits(JSONB, TIMESTAMP WITH TIME ZONE) FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.claim_otp_attempt(TEXT, TEXT, INTEGER) FROM PUBLIC, anon, authenticated;
+GRANT EXECUTE ON FUNCTION public.hit_rate_limits(JSONB, TIMESTAMP WITH TIME ZONE) TO service_role;
+GRANT EXECUTE ON FUNCTION public.claim_otp_attempt(TEXT, TEXT, INTEGER) TO service_role;
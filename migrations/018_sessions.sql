@@ -0,0 +1,80 @@
+-- Migration: Sessions and refresh tokens
+-- Description: Every login starts a session. Access tokens carry the session
+-- ID and stay valid only while the session is; refresh tokens rotate on use
+-- and are stored as SHA-256 hashes.
+
+CREATE TABLE IF NOT EXISTS public.sessions (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id TEXT NOT NULL,
+  provider VARCHAR(30) NOT NULL,
+  -- Claims to mint new access tokens from for users without a users row.
+  user_snapshot JSONB NOT NULL,
+  refresh_token_hash TEXT NOT NULL UNIQUE,
+  previous_token_hash TEXT,
+  user_agent TEXT,
+  ip_address VARCHAR(64),
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  last_used_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  revoked_at TIMESTAMP WITH TIME ZONE,
+  revoked_reason VARCHAR(50)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_active
+  ON public.sessions(user_id) WHERE revoked_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_sessions_previous_token
+  ON public.sessions(previous_token_hash) WHERE previous_token_hash IS NOT NULL;
+
+-- Swap a session's refresh token for a new one and extend it to
+-- p_expires_at. Presenting a token that was already rotated out means it
+-- was copied, so the session is revoked. Returns {"status", "session"} where
+-- status is ok, not_found, revoked, expired or reused; the revocation must
+-- commit, so failures are reported in the result rather than raised.
+CREATE OR REPLACE FUNCTION public.rotate_session(
+  p_token_hash TEXT,
+  p_new_token_hash TEXT,
+  p_expires_at TIMESTAMP WITH TIME ZONE
+) RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_session public.sessions;
+BEGIN
+  SELECT * INTO v_session
+    FROM public.sessions
+   WHERE refresh_token_hash = p_token_hash
+   FOR UPDATE;
+
+  IF NOT FOUND THEN
+    UPDATE public.sessions
+       SET revoked_at = NOW(), revoked_reason = 'refresh_token_reused'
+     WHERE previous_token_hash = p_token_hash AND revoked_at IS NULL;
+    IF FOUND THEN
+      RETURN jsonb_build_object('status', 'reused');
+    END IF;
+    RETURN jsonb_build_object('status', 'not_found');
+  END IF;
+
+  IF v_session.revoked_at IS NOT NULL THEN
+    RETURN jsonb_build_object('status', 'revoked');
+  END IF;
+  IF v_session.expires_at <= NOW() THEN
+    RETURN jsonb_build_object('status', 'expired');
+  END IF;
+
+  UPDATE public.sessions
+     SET previous_token_hash = refresh_token_hash,
+         refresh_token_hash = p_new_token_hash,
+         last_used_at = NOW(),
+         expires_at = p_expires_at
+   WHERE id = v_session.id
+  RETURNING * INTO v_session;
+
+  RETURN jsonb_build_object('status', 'ok', 'session', to_jsonb(v_session));
+END;
+$$;
+
+-- Refresh token hashes stay server side; only the API rotates sessions.
+ALTER TABLE public.sessions ENABLE ROW LEVEL SECURITY;
+REVOKE ALL ON FUNCTION public.rotate_session(TEXT, TEXT, TIMESTAMP WITH TIME ZONE) FROM PUBLIC, anon, authenticated;
+GRANT EXECUTE ON FUNCTION public.rotate_session(TEXT, TEXT, TIMESTAMP WITH TIME ZONE) TO service_role;
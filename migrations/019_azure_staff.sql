@@ -0,0 +1,87 @@
+-- Migration: Azure AD staff accounts
+-- Description: Staff who log in with Azure AD get a real users row, keyed
+-- by their directory object ID, so bookings and audit columns can reference
+-- them. Their role comes from Azure AD group / app role claims.
+
+ALTER TABLE public.users
+ADD COLUMN IF NOT EXISTS azure_oid TEXT,
+ADD COLUMN IF NOT EXISTS last_login_at TIMESTAMP WITH TIME ZONE;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_azure_oid
+  ON public.users(azure_oid) WHERE azure_oid IS NOT NULL;
+
+-- Staff may have no phone number in the directory.
+ALTER TABLE public.users ALTER COLUMN phone DROP NOT NULL;
+
+ALTER TABLE public.users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE public.users
+ADD CONSTRAINT users_role_check CHECK (role IN ('customer', 'nurse', 'admin', 'doctor'));
+
+-- Earlier Azure sessions identify the user by object ID instead of users.id
+-- and cannot be refreshed any more.
+UPDATE public.sessions
+   SET revoked_at = NOW(), revoked_reason = 'azure_staff_migration'
+ WHERE provider = 'azure' AND revoked_at IS NULL;
+
+-- Create or update the staff row for an Azure AD object ID. Directory
+-- fields and the mapped role are refreshed on every login; is_active is
+-- left alone so a deactivated account stays locked out. The phone number is
+-- kept only while no other user has it.
+CREATE OR REPLACE FUNCTION public.upsert_azure_staff(
+  p_azure_oid TEXT,
+  p_full_name TEXT,
+  p_role TEXT,
+  p_email TEXT,
+  p_phone TEXT,
+  p_employee_id TEXT,
+  p_department TEXT,
+  p_job_title TEXT
+) RETURNS public.users
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_user public.users;
+  v_phone TEXT := NULLIF(p_phone, '');
+BEGIN
+  SELECT * INTO v_user
+    FROM public.users
+   WHERE azure_oid = p_azure_oid
+   FOR UPDATE;
+
+  IF v_phone IS NOT NULL AND EXISTS (
+    SELECT 1 FROM public.users
+     WHERE phone = v_phone
+       AND azure_oid IS DISTINCT FROM p_azure_oid
+  ) THEN
+    v_phone := NULL;
+  END IF;
+
+  IF v_user.id IS NULL THEN
+    INSERT INTO public.users (azure_oid, full_name, role, email, phone, employee_id,
+                              department, job_title, is_active, last_login_at)
+    VALUES (p_azure_oid, p_full_name, p_role, p_email, v_phone, p_employee_id,
+            p_department, p_job_title, TRUE, NOW())
+    RETURNING * INTO v_user;
+    RETURN v_user;
+  END IF;
+
+  UPDATE public.users
+     SET full_name = COALESCE(NULLIF(p_full_name, ''), full_name),
+         role = p_role,
+         email = COALESCE(p_email, email),
+         phone = COALESCE(v_phone, phone),
+         employee_id = COALESCE(p_employee_id, employee_id),
+         department = COALESCE(p_department, department),
+         job_title = COALESCE(p_job_title, job_title),
+         last_login_at = NOW(),
+         updated_at = NOW()
+   WHERE id = v_user.id
+  RETURNING * INTO v_user;
+
+  RETURN v_user;
+END;
+$$;
+
+-- Only the API may create staff accounts.
+REVOKE ALL ON FUNCTION public.upsert_azure_staff(TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT) FROM PUBLIC, anon, authenticated;
+GRANT EXECUTE ON FUNCTION public.upsert_azure_staff(TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT) TO service_role;
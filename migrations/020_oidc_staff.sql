@@ -0,0 +1,84 @@
+-- Migration: Staff from any OIDC provider
+-- Description: Staff accounts are keyed by identity provider and subject
+-- instead of the Azure AD object ID alone, so Google Workspace, Keycloak and
+-- other OpenID Connect providers can be used next to Azure AD.
+
+ALTER TABLE public.users
+ADD COLUMN IF NOT EXISTS idp_provider VARCHAR(50),
+ADD COLUMN IF NOT EXISTS idp_subject TEXT;
+
+UPDATE public.users
+   SET idp_provider = 'azure', idp_subject = azure_oid
+ WHERE azure_oid IS NOT NULL AND idp_subject IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_idp_identity
+  ON public.users(idp_provider, idp_subject) WHERE idp_subject IS NOT NULL;
+
+DROP FUNCTION IF EXISTS public.upsert_azure_staff(TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT);
+DROP INDEX IF EXISTS public.idx_users_azure_oid;
+ALTER TABLE public.users DROP COLUMN IF EXISTS azure_oid;
+
+-- Create or update the staff row for a provider's subject. Profile fields
+-- and the mapped role are refreshed on every login; is_active is left alone
+-- so a deactivated account stays locked out. The phone number is kept only
+-- while no other user has it.
+CREATE OR REPLACE FUNCTION public.upsert_oidc_staff(
+  p_provider TEXT,
+  p_subject TEXT,
+  p_full_name TEXT,
+  p_role TEXT,
+  p_email TEXT,
+  p_phone TEXT,
+  p_employee_id TEXT,
+  p_department TEXT,
+  p_job_title TEXT
+) RETURNS public.users
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_user public.users;
+  v_phone TEXT := NULLIF(p_phone, '');
+BEGIN
+  SELECT * INTO v_user
+    FROM public.users
+   WHERE idp_provider = p_provider AND idp_subject = p_subject
+   FOR UPDATE;
+
+  IF v_phone IS NOT NULL AND EXISTS (
+    SELECT 1 FROM public.users
+     WHERE phone = v_phone
+       AND (idp_provider IS DISTINCT FROM p_provider OR idp_subject IS DISTINCT FROM p_subject)
+  ) THEN
+    v_phone := NULL;
+  END IF;
+
+  IF v_user.id IS NULL THEN
+    INSERT INTO public.users (idp_provider, idp_subject, full_name, role, email, phone,
+                              employee_id, department, job_title, is_active, last_login_at)
+    VALUES (p_provider, p_subject, p_full_name, p_role, p_email, v_phone,
+            p_employee_id, p_department, p_job_title, TRUE, NOW())
+    RETURNING * INTO v_user;
+    RETURN v_user;
+  END IF;
+
+  UPDATE public.users
+     SET full_name = COALESCE(NULLIF(p_full_name, ''), full_name),
+         role = p_role,
+         email = COALESCE(p_email, email),
+         phone = COALESCE(v_phone, phone),
+         employee_id = COALESCE(p_employee_id, employee_id),
+         department = COALESCE(p_department, department),
+         job_title = COALESCE(p_job_title, job_title),
+         last_login_at = NOW(),
+         updated_at = NOW()
+   WHERE id = v_user.id
+  RETURNING * INTO v_user;
+
+  RETURN v_user;
+END;
+$$;
+
+-- Only the API may create staff accounts, after it has verified the ID
+-- token.
+REVOKE ALL ON FUNCTION public.upsert_oidc_staff(TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT) FROM PUBLIC, anon, authenticated;
+GRANT EXECUTE ON FUNCTION public.upsert_oidc_staff(TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT) TO service_role;
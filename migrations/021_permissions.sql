@@ -0,0 +1,132 @@
+-- Migration: Role permissions
+-- Description: Access is checked against named permissions instead of role
+-- names. Roles and the permissions they grant live in tables admins can
+-- edit, so a new role such as receptionist needs no code change.
+
+CREATE TABLE IF NOT EXISTS public.permissions (
+  name VARCHAR(50) PRIMARY KEY,
+  description TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.roles (
+  name VARCHAR(30) PRIMARY KEY CHECK (name ~ '^[a-z][a-z0-9_]*$'),
+  description TEXT,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS public.role_permissions (
+  role VARCHAR(30) NOT NULL REFERENCES public.roles(name) ON UPDATE CASCADE ON DELETE CASCADE,
+  permission VARCHAR(50) NOT NULL REFERENCES public.permissions(name) ON UPDATE CASCADE ON DELETE CASCADE,
+  PRIMARY KEY (role, permission)
+);
+
+INSERT INTO public.permissions (name, description) VALUES
+  ('booking:read:own', 'View own bookings'),
+  ('booking:read:any', 'View any booking'),
+  ('booking:write:own', 'Create and edit own bookings'),
+  ('booking:write:any', 'Create and edit bookings for any customer'),
+  ('booking:cancel:own', 'Cancel own bookings before the cancellation cutoff'),
+  ('booking:cancel:any', 'Cancel any booking at any time'),
+  ('booking:status:manage', 'Confirm, check in, complete and mark no-shows'),
+  ('booking:delete', 'Delete bookings'),
+  ('dashboard:read', 'View the dashboard'),
+  ('sms:read', 'View SMS delivery history'),
+  ('slot:block', 'Block and unblock time slots'),
+  ('schedule:read', 'View schedule templates'),
+  ('schedule:write', 'Edit schedule templates and generate slots'),
+  ('doctor:write', 'Create, edit and deactivate doctors'),
+  ('user:read', 'View users'),
+  ('user:write', 'Create and edit customer accounts'),
+  ('user:write:staff', 'Create and edit staff accounts'),
+  ('user:role:assign', 'Change a user''s role'),
+  ('role:manage', 'Edit roles and their permissions')
+ON CONFLICT (name) DO UPDATE SET description = EXCLUDED.description;
+
+INSERT INTO public.roles (name, description) VALUES
+  ('customer', 'Patient booking for themselves'),
+  ('nurse', 'Clinic staff managing bookings and schedules'),
+  ('admin', 'Full access including staff and roles'),
+  ('doctor', 'Doctor viewing appointments'),
+  ('receptionist', 'Front desk with read-only booking access')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions (role, permission) VALUES
+  ('customer', 'booking:read:own'),
+  ('customer', 'booking:write:own'),
+  ('customer', 'booking:cancel:own'),
+  ('nurse', 'booking:read:any'),
+  ('nurse', 'booking:write:any'),
+  ('nurse', 'booking:cancel:any'),
+  ('nurse', 'booking:status:manage'),
+  ('nurse', 'booking:delete'),
+  ('nurse', 'dashboard:read'),
+  ('nurse', 'sms:read'),
+  ('nurse', 'slot:block'),
+  ('nurse', 'schedule:read'),
+  ('nurse', 'schedule:write'),
+  ('nurse', 'doctor:write'),
+  ('nurse', 'user:read'),
+  ('nurse', 'user:write'),
+  ('doctor', 'booking:read:any'),
+  ('receptionist', 'booking:read:any')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO public.role_permissions (role, permission)
+SELECT 'admin', name FROM public.permissions
+ON CONFLICT DO NOTHING;
+
+-- users.role now references the roles table instead of a fixed list.
+ALTER TABLE public.users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE public.users DROP CONSTRAINT IF EXISTS users_role_fkey;
+ALTER TABLE public.users
+ADD CONSTRAINT users_role_fkey FOREIGN KEY (role) REFERENCES public.roles(name) ON UPDATE CASCADE;
+
+-- Create a role or replace its description and permissions in one
+-- transaction. The admin role must keep role:manage so roles can always be
+-- edited again.
+CREATE OR REPLACE FUNCTION public.save_role(
+  p_name TEXT,
+  p_description TEXT,
+  p_permissions TEXT[]
+) RETURNS public.roles
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_role public.roles;
+  v_unknown TEXT;
+BEGIN
+  SELECT p INTO v_unknown
+    FROM unnest(p_permissions) AS p
+   WHERE NOT EXISTS (SELECT 1 FROM public.permissions WHERE name = p)
+   LIMIT 1;
+  IF v_unknown IS NOT NULL THEN
+    RAISE EXCEPTION 'unknown_permission:%', v_unknown;
+  END IF;
+
+  IF p_name = 'admin' AND NOT ('role:manage' = ANY(p_permissions)) THEN
+    RAISE EXCEPTION 'admin_lockout:%', p_name;
+  END IF;
+
+  INSERT INTO public.roles (name, description)
+  VALUES (p_name, p_description)
+  ON CONFLICT (name) DO UPDATE
+    SET description = COALESCE(EXCLUDED.description, public.roles.description),
+        updated_at = NOW()
+  RETURNING * INTO v_role;
+
+  DELETE FROM public.role_permissions WHERE role = p_name;
+  INSERT INTO public.role_permissions (role, permission)
+  SELECT DISTINCT p_name, p FROM unnest(p_permissions) AS p;
+
+  RETURN v_role;
+END;
+$$;
+
+-- With the anon key anyone could otherwise grant a role new permissions
+-- through PostgREST. Roles are managed through the API only.
+ALTER TABLE public.permissions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.roles ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.role_permissions ENABLE ROW LEVEL SECURITY;
+REVOKE ALL ON FUNCTION public.save_role(TEXT, TEXT, TEXT[]) FROM PUBLIC, anon, authenticated;
+GRANT EXECUTE ON FUNCTION public.save_role(TEXT, TEXT, TEXT[]) TO service_role;
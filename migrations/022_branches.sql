@@ -0,0 +1,532 @@
+-- Migration: Clinic branches
+-- Description: Doctors, schedules and bookings belong to a branch. Staff are
+-- assigned to one or more branches and only see those, unless their role
+-- has branch:any. Existing data moves to a default 'main' branch.
+
+CREATE TABLE IF NOT EXISTS public.branches (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  code VARCHAR(30) NOT NULL UNIQUE CHECK (code ~ '^[a-z0-9][a-z0-9_-]*$'),
+  name TEXT NOT NULL,
+  address TEXT,
+  phone VARCHAR(20),
+  is_active BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+INSERT INTO public.branches (code, name)
+VALUES ('main', 'Main clinic')
+ON CONFLICT (code) DO NOTHING;
+
+-- Doctors have a home branch; a schedule (and so its slots) defaults to it
+-- but may be at another branch.
+ALTER TABLE public.doctors ADD COLUMN IF NOT EXISTS branch_id UUID REFERENCES public.branches(id);
+UPDATE public.doctors
+   SET branch_id = (SELECT id FROM public.branches WHERE code = 'main')
+ WHERE branch_id IS NULL;
+ALTER TABLE public.doctors ALTER COLUMN branch_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_doctors_branch ON public.doctors(branch_id);
+
+ALTER TABLE public.schedule_templates ADD COLUMN IF NOT EXISTS branch_id UUID REFERENCES public.branches(id);
+UPDATE public.schedule_templates t
+   SET branch_id = d.branch_id
+  FROM public.doctors d
+ WHERE d.id = t.doctor_id AND t.branch_id IS NULL;
+ALTER TABLE public.schedule_templates ALTER COLUMN branch_id SET NOT NULL;
+
+ALTER TABLE public.doctor_schedules ADD COLUMN IF NOT EXISTS branch_id UUID REFERENCES public.branches(id);
+UPDATE public.doctor_schedules s
+   SET branch_id = d.branch_id
+  FROM public.doctors d
+ WHERE d.id = s.doctor_id AND s.branch_id IS NULL;
+ALTER TABLE public.doctor_schedules ALTER COLUMN branch_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_doctor_schedules_branch_date ON public.doctor_schedules(branch_id, schedule_date);
+
+-- Schedules and templates written without a branch take the doctor's.
+CREATE OR REPLACE FUNCTION public.default_doctor_branch()
+RETURNS TRIGGER
+LANGUAGE plpgsql
+AS $$
+BEGIN
+  IF NEW.branch_id IS NULL THEN
+    SELECT branch_id INTO NEW.branch_id FROM public.doctors WHERE id = NEW.doctor_id;
+  END IF;
+  RETURN NEW;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS trg_schedule_templates_branch ON public.schedule_templates;
+CREATE TRIGGER trg_schedule_templates_branch
+  BEFORE INSERT OR UPDATE OF doctor_id, branch_id ON public.schedule_templates
+  FOR EACH ROW EXECUTE FUNCTION public.default_doctor_branch();
+
+DROP TRIGGER IF EXISTS trg_doctor_schedules_branch ON public.doctor_schedules;
+CREATE TRIGGER trg_doctor_schedules_branch
+  BEFORE INSERT OR UPDATE OF doctor_id, branch_id ON public.doctor_schedules
+  FOR EACH ROW EXECUTE FUNCTION public.default_doctor_branch();
+
+-- A booking is at the branch of its slots.
+ALTER TABLE public.bookings ADD COLUMN IF NOT EXISTS branch_id UUID REFERENCES public.branches(id);
+UPDATE public.bookings b
+   SET branch_id = x.branch_id
+  FROM (
+    SELECT DISTINCT ON (a.booking_id) a.booking_id, ds.branch_id
+      FROM public.appointments a
+      JOIN public.time_slots ts ON ts.id = a.time_slot_id
+      JOIN public.doctor_schedules ds ON ds.id = ts.doctor_schedule_id
+     ORDER BY a.booking_id, a.created_at
+  ) x
+ WHERE x.booking_id = b.id AND b.branch_id IS NULL;
+UPDATE public.bookings
+   SET branch_id = (SELECT id FROM public.branches WHERE code = 'main')
+ WHERE branch_id IS NULL;
+ALTER TABLE public.bookings ALTER COLUMN branch_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_bookings_branch_date ON public.bookings(branch_id, appointment_date);
+
+-- Every appointment of a booking must use a slot at the booking's branch,
+-- whichever function adds or moves it.
+CREATE OR REPLACE FUNCTION public.check_appointment_branch()
+RETURNS TRIGGER
+LANGUAGE plpgsql
+AS $$
+BEGIN
+  IF NOT EXISTS (
+    SELECT 1
+      FROM public.bookings b
+      JOIN public.time_slots ts ON ts.id = NEW.time_slot_id
+      JOIN public.doctor_schedules ds ON ds.id = ts.doctor_schedule_id
+     WHERE b.id = NEW.booking_id AND ds.branch_id = b.branch_id
+  ) THEN
+    RAISE EXCEPTION 'slot_branch_mismatch:%', NEW.time_slot_id;
+  END IF;
+  RETURN NEW;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS trg_appointments_branch ON public.appointments;
+CREATE TRIGGER trg_appointments_branch
+  BEFORE INSERT OR UPDATE OF time_slot_id ON public.appointments
+  FOR EACH ROW EXECUTE FUNCTION public.check_appointment_branch();
+
+-- Staff branch assignments.
+CREATE TABLE IF NOT EXISTS public.user_branches (
+  user_id UUID NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+  branch_id UUID NOT NULL REFERENCES public.branches(id) ON DELETE CASCADE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  PRIMARY KEY (user_id, branch_id)
+);
+
+INSERT INTO public.user_branches (user_id, branch_id)
+SELECT u.id, b.id
+  FROM public.users u, public.branches b
+ WHERE u.role <> 'customer' AND b.code = 'main'
+ON CONFLICT DO NOTHING;
+
+-- Replace a user's branch assignments.
+CREATE OR REPLACE FUNCTION public.set_user_branches(
+  p_user_id UUID,
+  p_branch_ids UUID[]
+) RETURNS VOID
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_missing UUID;
+BEGIN
+  SELECT req.id INTO v_missing
+    FROM unnest(p_branch_ids) AS req(id)
+   WHERE NOT EXISTS (SELECT 1 FROM public.branches WHERE id = req.id)
+   LIMIT 1;
+  IF FOUND THEN
+    RAISE EXCEPTION 'branch_not_found:%', v_missing;
+  END IF;
+
+  DELETE FROM public.user_branches WHERE user_id = p_user_id;
+  INSERT INTO public.user_branches (user_id, branch_id)
+  SELECT DISTINCT p_user_id, id FROM unnest(p_branch_ids) AS req(id);
+END;
+$$;
+
+INSERT INTO public.permissions (name, description) VALUES
+  ('branch:any', 'Work across every branch regardless of branch assignments'),
+  ('branch:manage', 'Create and edit branches')
+ON CONFLICT (name) DO UPDATE SET description = EXCLUDED.description;
+
+INSERT INTO public.role_permissions (role, permission) VALUES
+  ('admin', 'branch:any'),
+  ('admin', 'branch:manage')
+ON CONFLICT DO NOTHING;
+
+-- save_doctor gains the doctor's home branch, required for new doctors.
+DROP FUNCTION IF EXISTS public.save_doctor(UUID, TEXT, TEXT, UUID[], BOOLEAN, UUID);
+CREATE OR REPLACE FUNCTION public.save_doctor(
+  p_doctor_id UUID,
+  p_full_name TEXT,
+  p_title TEXT,
+  p_specialty_ids UUID[],
+  p_is_active BOOLEAN,
+  p_updated_by UUID,
+  p_branch_id UUID
+) RETURNS public.doctors
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_doctor public.doctors;
+  v_missing UUID;
+  v_primary TEXT;
+BEGIN
+  IF p_branch_id IS NOT NULL AND NOT EXISTS (
+    SELECT 1 FROM public.branches WHERE id = p_branch_id AND is_active
+  ) THEN
+    RAISE EXCEPTION 'branch_not_found:%', p_branch_id;
+  END IF;
+
+  IF p_specialty_ids IS NOT NULL THEN
+    IF cardinality(p_specialty_ids) = 0 THEN
+      RAISE EXCEPTION 'no_specialties';
+    END IF;
+
+    SELECT req.specialty_id INTO v_missing
+      FROM unnest(p_specialty_ids) AS req(specialty_id)
+     WHERE NOT EXISTS (
+       SELECT 1 FROM public.specialties s WHERE s.id = req.specialty_id AND s.is_active
+     )
+     LIMIT 1;
+    IF FOUND THEN
+      RAISE EXCEPTION 'specialty_not_found:%', v_missing;
+    END IF;
+
+    SELECT name INTO v_primary FROM public.specialties WHERE id = p_specialty_ids[1];
+  END IF;
+
+  IF p_doctor_id IS NULL THEN
+    IF p_specialty_ids IS NULL THEN
+      RAISE EXCEPTION 'no_specialties';
+    END IF;
+    IF p_branch_id IS NULL THEN
+      RAISE EXCEPTION 'branch_required';
+    END IF;
+
+    INSERT INTO public.doctors (full_name, title, specialty, is_active, updated_by, branch_id)
+    VALUES (p_full_name, p_title, v_primary, COALESCE(p_is_active, TRUE), p_updated_by, p_branch_id)
+    RETURNING * INTO v_doctor;
+  ELSE
+    UPDATE public.doctors
+       SET full_name = COALESCE(p_full_name, full_name),
+           title = COALESCE(p_title, title),
+           specialty = COALESCE(v_primary, specialty),
+           is_active = COALESCE(p_is_active, is_active),
+           deactivated_at = CASE WHEN p_is_active THEN NULL ELSE deactivated_at END,
+           deactivated_by = CASE WHEN p_is_active THEN NULL ELSE deactivated_by END,
+           branch_id = COALESCE(p_branch_id, branch_id),
+           updated_by = p_updated_by,
+           updated_at = NOW()
+     WHERE id = p_doctor_id
+    RETURNING * INTO v_doctor;
+
+    IF NOT FOUND THEN
+      RAISE EXCEPTION 'doctor_not_found:%', p_doctor_id;
+    END IF;
+  END IF;
+
+  IF p_specialty_ids IS NOT NULL THEN
+    DELETE FROM public.doctor_specialties WHERE doctor_id = v_doctor.id;
+    INSERT INTO public.doctor_specialties (doctor_id, specialty_id, is_primary)
+    SELECT v_doctor.id, s.id, s.ord = 1
+      FROM unnest(p_specialty_ids) WITH ORDINALITY AS s(id, ord)
+    ON CONFLICT DO NOTHING;
+  END IF;
+
+  RETURN v_doctor;
+END;
+$$;
+
+-- create_booking takes the booking's branch from its first slot. With
+-- p_branch_ids set, the branch must be one of them.
+DROP FUNCTION IF EXISTS public.create_booking(UUID, DATE, TEXT, TEXT, UUID, JSONB);
+CREATE OR REPLACE FUNCTION public.create_booking(
+  p_customer_id UUID,
+  p_appointment_date DATE,
+  p_status TEXT,
+  p_notes TEXT,
+  p_created_by UUID,
+  p_appointments JSONB,
+  p_branch_ids UUID[] DEFAULT NULL
+) RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_booking public.bookings;
+  v_apt JSONB;
+  v_branch_id UUID;
+BEGIN
+  IF p_appointments IS NULL OR jsonb_array_length(p_appointments) = 0 THEN
+    RAISE EXCEPTION 'no_appointments';
+  END IF;
+
+  SELECT ds.branch_id INTO v_branch_id
+    FROM public.time_slots ts
+    JOIN public.doctor_schedules ds ON ds.id = ts.doctor_schedule_id
+   WHERE ts.id = (p_appointments->0->>'time_slot_id')::UUID;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'slot_not_found:%', p_appointments->0->>'time_slot_id';
+  END IF;
+  IF p_branch_ids IS NOT NULL AND NOT (v_branch_id = ANY(p_branch_ids)) THEN
+    RAISE EXCEPTION 'branch_not_allowed:%', v_branch_id;
+  END IF;
+
+  INSERT INTO public.bookings (customer_id, appointment_date, status, notes, created_by, updated_by, branch_id)
+  VALUES (p_customer_id, p_appointment_date, p_status, p_notes, p_created_by, p_created_by, v_branch_id)
+  RETURNING * INTO v_booking;
+
+  -- Lock slots in a stable order so two overlapping bookings cannot deadlock.
+  FOR v_apt IN
+    SELECT value FROM jsonb_array_elements(p_appointments) ORDER BY value->>'time_slot_id'
+  LOOP
+    PERFORM public.reserve_time_slot(
+      (v_apt->>'time_slot_id')::UUID,
+      (v_apt->>'doctor_id')::UUID,
+      p_appointment_date
+    );
+
+    INSERT INTO public.appointments (booking_id, time_slot_id, doctor_id, service_type, location, status)
+    VALUES (
+      v_booking.id,
+      (v_apt->>'time_slot_id')::UUID,
+      (v_apt->>'doctor_id')::UUID,
+      v_apt->>'service_type',
+      v_apt->>'location',
+      p_status
+    );
+  END LOOP;
+
+  RETURN to_jsonb(v_booking);
+END;
+$$;
+
+-- Schedules are created at the plan's branch, or the doctor's when absent.
+-- p_plan: [{"doctor_id", "branch_id", "schedule_date", "day_of_week",
+--           "slots": [{"start_time", "end_time", "max_capacity"}]}]
+CREATE OR REPLACE FUNCTION public.apply_schedule_plan(p_plan JSONB)
+RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_day JSONB;
+  v_schedule_id UUID;
+  v_rows INTEGER;
+  v_schedules INTEGER := 0;
+  v_slots INTEGER := 0;
+BEGIN
+  FOR v_day IN SELECT value FROM jsonb_array_elements(COALESCE(p_plan, '[]'::JSONB))
+  LOOP
+    v_schedule_id := NULL;
+
+    INSERT INTO public.doctor_schedules (doctor_id, branch_id, schedule_date, day_of_week, is_available)
+    VALUES (
+      (v_day->>'doctor_id')::UUID,
+      NULLIF(v_day->>'branch_id', '')::UUID,
+      (v_day->>'schedule_date')::DATE,
+      v_day->>'day_of_week',
+      TRUE
+    )
+    ON CONFLICT (doctor_id, schedule_date) DO NOTHING
+    RETURNING id INTO v_schedule_id;
+
+    IF v_schedule_id IS NULL THEN
+      SELECT id INTO v_schedule_id
+        FROM public.doctor_schedules
+       WHERE doctor_id = (v_day->>'doctor_id')::UUID
+         AND schedule_date = (v_day->>'schedule_date')::DATE;
+    ELSE
+      v_schedules := v_schedules + 1;
+    END IF;
+
+    INSERT INTO public.time_slots (doctor_schedule_id, start_time, end_time, status, max_capacity, current_bookings)
+    SELECT v_schedule_id,
+           (s->>'start_time')::TIME,
+           (s->>'end_time')::TIME,
+           'available',
+           (s->>'max_capacity')::INTEGER,
+           0
+      FROM jsonb_array_elements(COALESCE(v_day->'slots', '[]'::JSONB)) s
+    ON CONFLICT (doctor_schedule_id, start_time) DO NOTHING;
+    GET DIAGNOSTICS v_rows = ROW_COUNT;
+    v_slots := v_slots + v_rows;
+  END LOOP;
+
+  RETURN jsonb_build_object('schedules_created', v_schedules, 'slots_created', v_slots);
+END;
+$$;
+
+-- Next bookable slot per doctor, optionally at one branch only.
+DROP FUNCTION IF EXISTS public.get_next_available_slots(UUID[], TIMESTAMP WITH TIME ZONE, TEXT);
+CREATE OR REPLACE FUNCTION public.get_next_available_slots(
+  p_doctor_ids UUID[],
+  p_now TIMESTAMP WITH TIME ZONE,
+  p_timezone TEXT,
+  p_branch_id UUID DEFAULT NULL
+) RETURNS JSONB
+LANGUAGE sql
+STABLE
+AS $$
+  SELECT COALESCE(jsonb_agg(n), '[]'::JSONB)
+    FROM (
+      SELECT DISTINCT ON (d.id)
+             ts.id AS time_slot_id,
+             d.id AS doctor_id,
+             d.full_name AS doctor_name,
+             d.title AS doctor_title,
+             d.specialty,
+             ds.branch_id,
+             ds.schedule_date,
+             ts.start_time,
+             ts.end_time,
+             ts.max_capacity - ts.current_bookings AS available_slots
+        FROM public.doctors d
+        JOIN public.doctor_schedules ds ON ds.doctor_id = d.id
+        JOIN public.time_slots ts ON ts.doctor_schedule_id = ds.id
+       WHERE d.id = ANY(p_doctor_ids)
+         AND (p_branch_id IS NULL OR ds.branch_id = p_branch_id)
+         AND d.is_active
+         AND ds.is_available
+         AND ts.status NOT IN ('blocked', 'inactive')
+         AND ts.current_bookings < ts.max_capacity
+         AND ds.schedule_date + ts.start_time > (p_now AT TIME ZONE p_timezone)
+       ORDER BY d.id, ds.schedule_date, ts.start_time
+    ) n;
+$$;
+
+-- The dashboard covers the given branches, or every branch when
+-- p_branch_ids is NULL.
+DROP FUNCTION IF EXISTS public.get_nurse_dashboard(DATE, DATE, TIMESTAMP WITH TIME ZONE, TEXT);
+CREATE OR REPLACE FUNCTION public.get_nurse_dashboard(
+  p_date_from DATE,
+  p_date_to DATE,
+  p_now TIMESTAMP WITH TIME ZONE,
+  p_timezone TEXT,
+  p_branch_ids UUID[] DEFAULT NULL
+) RETURNS JSONB
+LANGUAGE plpgsql
+STABLE
+AS $$
+DECLARE
+  v_local_now TIMESTAMP := p_now AT TIME ZONE p_timezone;
+  v_by_status JSONB;
+  v_total INTEGER;
+  v_utilisation JSONB;
+  v_upcoming JSONB;
+  v_by_company JSONB;
+  v_no_show INTEGER;
+  v_attended INTEGER;
+BEGIN
+  -- Bookings by status
+  SELECT COALESCE(jsonb_object_agg(status, cnt), '{}'::JSONB), COALESCE(SUM(cnt), 0)
+    INTO v_by_status, v_total
+    FROM (
+      SELECT status, COUNT(*) AS cnt
+        FROM public.bookings
+       WHERE appointment_date BETWEEN p_date_from AND p_date_to
+         AND deleted_at IS NULL
+         AND (p_branch_ids IS NULL OR branch_id = ANY(p_branch_ids))
+       GROUP BY status
+    ) s;
+
+  -- Booked seats vs. capacity per doctor
+  SELECT COALESCE(jsonb_agg(u ORDER BY u.doctor_name), '[]'::JSONB)
+    INTO v_utilisation
+    FROM (
+      SELECT d.id AS doctor_id,
+             d.full_name AS doctor_name,
+             SUM(ts.current_bookings)::INTEGER AS booked,
+             SUM(ts.max_capacity)::INTEGER AS capacity,
+             CASE WHEN SUM(ts.max_capacity) > 0
+                  THEN ROUND(SUM(ts.current_bookings)::NUMERIC / SUM(ts.max_capacity), 4)
+                  ELSE 0 END AS utilisation
+        FROM public.doctors d
+        JOIN public.doctor_schedules ds ON ds.doctor_id = d.id
+        JOIN public.time_slots ts ON ts.doctor_schedule_id = ds.id
+       WHERE ds.schedule_date BETWEEN p_date_from AND p_date_to
+         AND ts.status NOT IN ('blocked', 'inactive')
+         AND (p_branch_ids IS NULL OR ds.branch_id = ANY(p_branch_ids))
+       GROUP BY d.id, d.full_name
+    ) u;
+
+  -- Appointments starting within the next hour that still need check-in
+  SELECT COALESCE(jsonb_agg(x ORDER BY x.schedule_date, x.start_time), '[]'::JSONB)
+    INTO v_upcoming
+    FROM (
+      SELECT a.id AS appointment_id,
+             b.id AS booking_id,
+             b.booking_number,
+             u.full_name AS customer_name,
+             u.phone AS customer_phone,
+             d.full_name AS doctor_name,
+             a.service_type,
+             a.status,
+             ds.schedule_date,
+             ts.start_time
+        FROM public.appointments a
+        JOIN public.bookings b ON b.id = a.booking_id
+        JOIN public.users u ON u.id = b.customer_id
+        JOIN public.doctors d ON d.id = a.doctor_id
+        JOIN public.time_slots ts ON ts.id = a.time_slot_id
+        JOIN public.doctor_schedules ds ON ds.id = ts.doctor_schedule_id
+       WHERE a.status IN ('pending', 'confirmed')
+         AND b.deleted_at IS NULL
+         AND (p_branch_ids IS NULL OR b.branch_id = ANY(p_branch_ids))
+         AND ds.schedule_date + ts.start_time BETWEEN v_local_now AND v_local_now + INTERVAL '1 hour'
+    ) x;
+
+  -- No-show rate over appointments that have reached an outcome
+  SELECT COUNT(*) FILTER (WHERE a.status = 'no_show'),
+         COUNT(*) FILTER (WHERE a.status IN ('checked_in', 'completed', 'no_show'))
+    INTO v_no_show, v_attended
+    FROM public.appointments a
+    JOIN public.bookings b ON b.id = a.booking_id
+   WHERE b.appointment_date BETWEEN p_date_from AND p_date_to
+     AND b.deleted_at IS NULL
+     AND (p_branch_ids IS NULL OR b.branch_id = ANY(p_branch_ids));
+
+  -- Bookings by company
+  SELECT COALESCE(jsonb_agg(c ORDER BY c.booking_count DESC), '[]'::JSONB)
+    INTO v_by_company
+    FROM (
+      SELECT COALESCE(u.company_name, '') AS company_name, COUNT(b.id)::INTEGER AS booking_count
+        FROM public.bookings b
+        JOIN public.users u ON u.id = b.customer_id
+       WHERE b.appointment_date BETWEEN p_date_from AND p_date_to
+         AND b.deleted_at IS NULL
+         AND (p_branch_ids IS NULL OR b.branch_id = ANY(p_branch_ids))
+       GROUP BY COALESCE(u.company_name, '')
+    ) c;
+
+  RETURN jsonb_build_object(
+    'date_from', p_date_from,
+    'date_to', p_date_to,
+    'total_bookings', v_total,
+    'bookings_by_status', v_by_status,
+    'doctor_utilisation', v_utilisation,
+    'upcoming_check_ins', v_upcoming,
+    'no_show_count', v_no_show,
+    'no_show_rate', CASE WHEN v_attended > 0 THEN ROUND(v_no_show::NUMERIC / v_attended, 4) ELSE 0 END,
+    'bookings_by_company', v_by_company
+  );
+END;
+$$;
+
+-- Branch assignments decide what staff can see, so clients must not edit
+-- them. The recreated functions above need their privileges set again.
+ALTER TABLE public.branches ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.user_branches ENABLE ROW LEVEL SECURITY;
+REVOKE ALL ON FUNCTION public.set_user_branches(UUID, UUID[]) FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.save_doctor(UUID, TEXT, TEXT, UUID[], BOOLEAN, UUID, UUID) FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.create_booking(UUID, DATE, TEXT, TEXT, UUID, JSONB, UUID[]) FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.get_next_available_slots(UUID[], TIMESTAMP WITH TIME ZONE, TEXT, UUID) FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.get_nurse_dashboard(DATE, DATE, TIMESTAMP WITH TIME ZONE, TEXT, UUID[]) FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.default_doctor_branch() FROM PUBLIC, anon, authenticated;
+REVOKE ALL ON FUNCTION public.check_appointment_branch() FROM PUBLIC, anon, authenticated;
+GRANT EXECUTE ON FUNCTION public.set_user_branches(UUID, UUID[]) TO service_role;
+GRANT EXECUTE ON FUNCTION public.save_doctor(UUID, TEXT, TEXT, UUID[], BOOLEAN, UUID, UUID) TO service_role;
+GRANT EXECUTE ON FUNCTION public.create_booking(UUID, DATE, TEXT, TEXT, UUID, JSONB, UUID[]) TO service_role;
+GRANT EXECUTE ON FUNCTION public.get_next_available_slots(UUID[], TIMESTAMP WITH TIME ZONE, TEXT, UUID) TO service_role;
+GRANT EXECUTE ON FUNCTION public.get_nurse_dashboard(DATE, DATE, TIMESTAMP WITH TIME ZONE, TEXT, UUID[]) TO service_role;
@@ -0,0 +1,109 @@
+-- Migration: Cancellable statuses
+-- Description: Only pending and confirmed bookings and appointments can be
+-- cancelled. Checked-in, completed and no-show rows are history; cancelling
+-- them would give back seats on slots that have already been used.
+
+-- Cancel a single appointment and release its slot, then roll the change up
+-- to the booking, which is cancelled once nothing active is left.
+CREATE OR REPLACE FUNCTION public.cancel_appointment(
+  p_appointment_id UUID,
+  p_cancelled_by UUID,
+  p_reason TEXT
+) RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_apt public.appointments;
+  v_status TEXT;
+BEGIN
+  SELECT * INTO v_apt FROM public.appointments WHERE id = p_appointment_id FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'appointment_not_found:%', p_appointment_id;
+  END IF;
+  IF v_apt.status = 'cancelled' THEN
+    RAISE EXCEPTION 'already_cancelled:%', p_appointment_id;
+  END IF;
+  IF v_apt.status NOT IN ('pending', 'confirmed') THEN
+    RAISE EXCEPTION 'booking_not_cancellable:%', p_appointment_id;
+  END IF;
+
+  PERFORM public.release_time_slot(v_apt.time_slot_id);
+
+  UPDATE public.appointments
+     SET status = 'cancelled',
+         cancelled_at = NOW(),
+         cancelled_by = p_cancelled_by,
+         cancellation_reason = p_reason,
+         updated_at = NOW()
+   WHERE id = p_appointment_id
+  RETURNING * INTO v_apt;
+
+  v_status := public.rollup_booking_status(v_apt.booking_id);
+  UPDATE public.bookings
+     SET status = v_status,
+         cancelled_at = CASE WHEN v_status = 'cancelled' THEN NOW() ELSE cancelled_at END,
+         cancelled_by = CASE WHEN v_status = 'cancelled' THEN p_cancelled_by ELSE cancelled_by END,
+         cancellation_reason = CASE WHEN v_status = 'cancelled' THEN p_reason ELSE cancellation_reason END,
+         updated_by = p_cancelled_by,
+         updated_at = NOW()
+   WHERE id = v_apt.booking_id AND status <> 'cancelled';
+
+  RETURN to_jsonb(v_apt);
+END;
+$$;
+
+-- Cancel a pending or confirmed booking with its pending and confirmed
+-- appointments. Appointments that already reached an outcome are kept as
+-- they are.
+CREATE OR REPLACE FUNCTION public.cancel_booking(
+  p_booking_id UUID,
+  p_cancelled_by UUID,
+  p_reason TEXT
+) RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_booking public.bookings;
+  v_apt public.appointments;
+BEGIN
+  SELECT * INTO v_booking FROM public.bookings WHERE id = p_booking_id FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'booking_not_found:%', p_booking_id;
+  END IF;
+  IF v_booking.status = 'cancelled' THEN
+    RAISE EXCEPTION 'already_cancelled:%', p_booking_id;
+  END IF;
+  IF v_booking.status NOT IN ('pending', 'confirmed') THEN
+    RAISE EXCEPTION 'booking_not_cancellable:%', p_booking_id;
+  END IF;
+
+  FOR v_apt IN
+    SELECT * FROM public.appointments
+     WHERE booking_id = p_booking_id AND status IN ('pending', 'confirmed')
+     ORDER BY time_slot_id
+       FOR UPDATE
+  LOOP
+    PERFORM public.release_time_slot(v_apt.time_slot_id);
+  END LOOP;
+
+  UPDATE public.appointments
+     SET status = 'cancelled',
+         cancelled_at = NOW(),
+         cancelled_by = p_cancelled_by,
+         cancellation_reason = p_reason,
+         updated_at = NOW()
+   WHERE booking_id = p_booking_id AND status IN ('pending', 'confirmed');
+
+  UPDATE public.bookings
+     SET status = 'cancelled',
+         cancelled_at = NOW(),
+         cancelled_by = p_cancelled_by,
+         cancellation_reason = p_reason,
+         updated_by = p_cancelled_by,
+         updated_at = NOW()
+   WHERE id = p_booking_id
+  RETURNING * INTO v_booking;
+
+  RETURN to_jsonb(v_booking);
+END;
+$$;
@@ -0,0 +1,110 @@
+-- Migration: Doctor title and reactivation
+-- Description: save_doctor can clear a doctor's title when the request sends
+-- an explicit null, and reactivating a doctor reopens the upcoming schedules
+-- that deactivate_doctor closed.
+
+-- p_title_set distinguishes "title": null (clear it) from a request that
+-- leaves the title out. On reactivation, schedules from p_today on that were
+-- closed in the deactivating transaction are reopened; deactivate_doctor
+-- stamps them with the same NOW() as deactivated_at, so schedules closed by
+-- hand, before or after, stay closed.
+DROP FUNCTION IF EXISTS public.save_doctor(UUID, TEXT, TEXT, UUID[], BOOLEAN, UUID, UUID);
+CREATE OR REPLACE FUNCTION public.save_doctor(
+  p_doctor_id UUID,
+  p_full_name TEXT,
+  p_title TEXT,
+  p_title_set BOOLEAN,
+  p_specialty_ids UUID[],
+  p_is_active BOOLEAN,
+  p_updated_by UUID,
+  p_branch_id UUID,
+  p_today DATE
+) RETURNS public.doctors
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_doctor public.doctors;
+  v_previous public.doctors;
+  v_missing UUID;
+  v_primary TEXT;
+BEGIN
+  IF p_branch_id IS NOT NULL AND NOT EXISTS (
+    SELECT 1 FROM public.branches WHERE id = p_branch_id AND is_active
+  ) THEN
+    RAISE EXCEPTION 'branch_not_found:%', p_branch_id;
+  END IF;
+
+  IF p_specialty_ids IS NOT NULL THEN
+    IF cardinality(p_specialty_ids) = 0 THEN
+      RAISE EXCEPTION 'no_specialties';
+    END IF;
+
+    SELECT req.specialty_id INTO v_missing
+      FROM unnest(p_specialty_ids) AS req(specialty_id)
+     WHERE NOT EXISTS (
+       SELECT 1 FROM public.specialties s WHERE s.id = req.specialty_id AND s.is_active
+     )
+     LIMIT 1;
+    IF FOUND THEN
+      RAISE EXCEPTION 'specialty_not_found:%', v_missing;
+    END IF;
+
+    SELECT name INTO v_primary FROM public.specialties WHERE id = p_specialty_ids[1];
+  END IF;
+
+  IF p_doctor_id IS NULL THEN
+    IF p_specialty_ids IS NULL THEN
+      RAISE EXCEPTION 'no_specialties';
+    END IF;
+    IF p_branch_id IS NULL THEN
+      RAISE EXCEPTION 'branch_required';
+    END IF;
+
+    INSERT INTO public.doctors (full_name, title, specialty, is_active, updated_by, branch_id)
+    VALUES (p_full_name, p_title, v_primary, COALESCE(p_is_active, TRUE), p_updated_by, p_branch_id)
+    RETURNING * INTO v_doctor;
+  ELSE
+    SELECT * INTO v_previous FROM public.doctors WHERE id = p_doctor_id FOR UPDATE;
+    IF NOT FOUND THEN
+      RAISE EXCEPTION 'doctor_not_found:%', p_doctor_id;
+    END IF;
+
+    UPDATE public.doctors
+       SET full_name = COALESCE(p_full_name, full_name),
+           title = CASE WHEN p_title_set THEN p_title ELSE title END,
+           specialty = COALESCE(v_primary, specialty),
+           is_active = COALESCE(p_is_active, is_active),
+           deactivated_at = CASE WHEN p_is_active THEN NULL ELSE deactivated_at END,
+           deactivated_by = CASE WHEN p_is_active THEN NULL ELSE deactivated_by END,
+           branch_id = COALESCE(p_branch_id, branch_id),
+           updated_by = p_updated_by,
+           updated_at = NOW()
+     WHERE id = p_doctor_id
+    RETURNING * INTO v_doctor;
+
+    IF p_is_active AND NOT v_previous.is_active AND v_previous.deactivated_at IS NOT NULL THEN
+      UPDATE public.doctor_schedules
+         SET is_available = TRUE,
+             updated_at = NOW()
+       WHERE doctor_id = p_doctor_id
+         AND schedule_date >= p_today
+         AND NOT is_available
+         AND updated_at = v_previous.deactivated_at;
+    END IF;
+  END IF;
+
+  IF p_specialty_ids IS NOT NULL THEN
+    DELETE FROM public.doctor_specialties WHERE doctor_id = v_doctor.id;
+    INSERT INTO public.doctor_specialties (doctor_id, specialty_id, is_primary)
+    SELECT v_doctor.id, s.id, s.ord = 1
+      FROM unnest(p_specialty_ids) WITH ORDINALITY AS s(id, ord)
+    ON CONFLICT DO NOTHING;
+  END IF;
+
+  RETURN v_doctor;
+END;
+$$;
+
+-- Recreating the function reset its privileges.
+REVOKE ALL ON FUNCTION public.save_doctor(UUID, TEXT, TEXT, BOOLEAN, UUID[], BOOLEAN, UUID, UUID, DATE) FROM PUBLIC, anon, authenticated;
+GRANT EXECUTE ON FUNCTION public.save_doctor(UUID, TEXT, TEXT, BOOLEAN, UUID[], BOOLEAN, UUID, UUID, DATE) TO service_role;
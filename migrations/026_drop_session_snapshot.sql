@@ -0,0 +1,6 @@
+-- Migration: Drop session user snapshots
+-- Description: Access tokens are minted from the users row, so the claims
+-- copied into sessions.user_snapshot were never read and sessions no longer
+-- write them.
+
+ALTER TABLE public.sessions DROP COLUMN IF EXISTS user_snapshot;
@@ -0,0 +1,60 @@
+-- Migration: Atomic nurse booking edits
+-- Description: A nurse edit can change appointments, status and notes in one
+-- request. Doing them in separate calls left the appointment changes applied
+-- when the status change was then refused, so they now share a transaction.
+
+-- Apply a nurse edit to a booking. Appointment changes go through
+-- update_booking_appointments when p_appointment_date or p_changes is given,
+-- then the booking moves to p_status. The API checked the transition from
+-- p_expected_status; if the appointment changes rolled the booking into
+-- another status the whole edit fails with status_changed. NULL arguments
+-- leave that part of the booking alone.
+CREATE OR REPLACE FUNCTION public.update_booking(
+  p_booking_id UUID,
+  p_updated_by UUID,
+  p_appointment_date DATE,
+  p_changes JSONB,
+  p_expected_status TEXT,
+  p_status TEXT,
+  p_notes TEXT
+) RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_booking public.bookings;
+BEGIN
+  IF p_appointment_date IS NOT NULL OR jsonb_array_length(COALESCE(p_changes, '[]'::JSONB)) > 0 THEN
+    PERFORM public.update_booking_appointments(p_booking_id, p_updated_by, p_appointment_date, p_changes);
+  END IF;
+
+  SELECT * INTO v_booking FROM public.bookings
+   WHERE id = p_booking_id AND deleted_at IS NULL
+     FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'booking_not_found:%', p_booking_id;
+  END IF;
+
+  IF p_status IS NOT NULL AND p_status <> v_booking.status THEN
+    IF v_booking.status <> p_expected_status THEN
+      RAISE EXCEPTION 'status_changed:%', p_booking_id;
+    END IF;
+    IF p_status = 'cancelled' THEN
+      PERFORM public.cancel_booking(p_booking_id, p_updated_by, NULL);
+    ELSE
+      PERFORM public.set_booking_status(p_booking_id, p_expected_status, p_status, p_updated_by);
+    END IF;
+  END IF;
+
+  IF p_notes IS NOT NULL THEN
+    UPDATE public.bookings
+       SET notes = p_notes, updated_by = p_updated_by, updated_at = NOW()
+     WHERE id = p_booking_id;
+  END IF;
+
+  SELECT * INTO v_booking FROM public.bookings WHERE id = p_booking_id;
+  RETURN to_jsonb(v_booking);
+END;
+$$;
+
+REVOKE ALL ON FUNCTION public.update_booking(UUID, UUID, DATE, JSONB, TEXT, TEXT, TEXT) FROM PUBLIC, anon, authenticated;
+GRANT EXECUTE ON FUNCTION public.update_booking(UUID, UUID, DATE, JSONB, TEXT, TEXT, TEXT) TO service_role;
@@ -0,0 +1,84 @@
+-- Migration: Session user FK and concurrent refreshes
+-- Description: Sessions now belong to users rows, so user_id becomes a UUID
+-- FK and a deleted user's sessions go with it. Two tabs refreshing with the
+-- same token used to look like a stolen token and ended the session;
+-- rotate_session now accepts the rotated-out token for a short grace window.
+
+-- Sessions of users that no longer exist (including the Azure directory IDs
+-- revoked in 019) cannot be refreshed any more.
+DELETE FROM public.sessions s
+ WHERE s.user_id !~* '^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$'
+    OR NOT EXISTS (SELECT 1 FROM public.users u WHERE u.id::TEXT = s.user_id);
+
+ALTER TABLE public.sessions
+  ALTER COLUMN user_id TYPE UUID USING user_id::UUID;
+ALTER TABLE public.sessions
+  DROP CONSTRAINT IF EXISTS sessions_user_id_fkey;
+ALTER TABLE public.sessions
+  ADD CONSTRAINT sessions_user_id_fkey
+  FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+DROP FUNCTION IF EXISTS public.rotate_session(TEXT, TEXT, TIMESTAMP WITH TIME ZONE);
+
+-- Swap a session's refresh token for a new one and extend it to
+-- p_expires_at. The token rotated out last is still accepted for
+-- p_grace_seconds after that rotation, so concurrent refreshes each get a
+-- working token; presenting it later means it was copied, and the session
+-- is revoked. Returns {"status", "session"} where status is ok, not_found,
+-- revoked, expired or reused; the revocation must commit, so failures are
+-- reported in the result rather than raised.
+CREATE OR REPLACE FUNCTION public.rotate_session(
+  p_token_hash TEXT,
+  p_new_token_hash TEXT,
+  p_expires_at TIMESTAMP WITH TIME ZONE,
+  p_grace_seconds INTEGER
+) RETURNS JSONB
+LANGUAGE plpgsql
+AS $$
+DECLARE
+  v_session public.sessions;
+BEGIN
+  SELECT * INTO v_session
+    FROM public.sessions
+   WHERE refresh_token_hash = p_token_hash OR previous_token_hash = p_token_hash
+   ORDER BY refresh_token_hash = p_token_hash DESC
+   LIMIT 1
+   FOR UPDATE;
+
+  IF NOT FOUND THEN
+    RETURN jsonb_build_object('status', 'not_found');
+  END IF;
+
+  IF v_session.revoked_at IS NOT NULL THEN
+    RETURN jsonb_build_object('status', 'revoked');
+  END IF;
+
+  IF v_session.refresh_token_hash <> p_token_hash
+     AND v_session.last_used_at <= NOW() - make_interval(secs => GREATEST(p_grace_seconds, 0)) THEN
+    UPDATE public.sessions
+       SET revoked_at = NOW(), revoked_reason = 'refresh_token_reused'
+     WHERE id = v_session.id;
+    RETURN jsonb_build_object('status', 'reused');
+  END IF;
+
+  IF v_session.expires_at <= NOW() THEN
+    RETURN jsonb_build_object('status', 'expired');
+  END IF;
+
+  -- A refresh with the previous token inside the grace window keeps the
+  -- token the concurrent refresh just received usable as the previous one.
+  UPDATE public.sessions
+     SET previous_token_hash = refresh_token_hash,
+         refresh_token_hash = p_new_token_hash,
+         last_used_at = NOW(),
+         expires_at = p_expires_at
+   WHERE id = v_session.id
+  RETURNING * INTO v_session;
+
+  RETURN jsonb_build_object('status', 'ok', 'session', to_jsonb(v_session));
+END;
+$$;
+
+-- Recreated, so the grants from 018 are gone.
+REVOKE ALL ON FUNCTION public.rotate_session(TEXT, TEXT, TIMESTAMP WITH TIME ZONE, INTEGER) FROM PUBLIC, anon, authenticated;
+GRANT EXECUTE ON FUNCTION public.rotate_session(TEXT, TEXT, TIMESTAMP WITH TIME ZONE, INTEGER) TO service_role;
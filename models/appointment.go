@@ -12,6 +12,10 @@ type Appointment struct {
 	Status      string    `json:"status" db:"status"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+
+	CancelledAt        *time.Time `json:"cancelled_at,omitempty" db:"cancelled_at"`
+	CancelledBy        *string    `json:"cancelled_by,omitempty" db:"cancelled_by"`
+	CancellationReason *string    `json:"cancellation_reason,omitempty" db:"cancellation_reason"`
 }
 
 type AppointmentWithDetails struct {
@@ -6,6 +6,7 @@ type Booking struct {
 	ID              string    `json:"id" db:"id"`
 	BookingNumber   string    `json:"booking_number" db:"booking_number"`
 	CustomerID      string    `json:"customer_id" db:"customer_id"`
+	BranchID        string    `json:"branch_id" db:"branch_id"`
 	AppointmentDate string    `json:"appointment_date" db:"appointment_date"`
 	Status          string    `json:"status" db:"status"`
 	Notes           *string   `json:"notes,omitempty" db:"notes"`
@@ -13,6 +14,13 @@ type Booking struct {
 	UpdatedBy       *string   `json:"updated_by,omitempty" db:"updated_by"`
 	CreatedAt       time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
+
+	CancelledAt        *time.Time `json:"cancelled_at,omitempty" db:"cancelled_at"`
+	CancelledBy        *string    `json:"cancelled_by,omitempty" db:"cancelled_by"`
+	CancellationReason *string    `json:"cancellation_reason,omitempty" db:"cancellation_reason"`
+	DeletedAt          *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	DeletedBy          *string    `json:"deleted_by,omitempty" db:"deleted_by"`
+	DeletionReason     *string    `json:"deletion_reason,omitempty" db:"deletion_reason"`
 }
 
 type BookingWithDetails struct {
@@ -35,3 +43,33 @@ type UpdateBookingRequest struct {
 	Status          *string `json:"status,omitempty"`
 	Notes           *string `json:"notes,omitempty"`
 }
+
+type CancelBookingRequest struct {
+	Reason string `json:"reason"`
+}
+
+// NurseUpdateBookingRequest lets staff edit any booking, including moving,
+// adding and removing its appointments.
+type NurseUpdateBookingRequest struct {
+	AppointmentDate *string             `json:"appointment_date,omitempty"`
+	Status          *string             `json:"status,omitempty"`
+	Notes           *string             `json:"notes,omitempty"`
+	Appointments    []AppointmentChange `json:"appointments,omitempty"`
+}
+
+// AppointmentChange edits one appointment of a booking. Without an
+// AppointmentID a new appointment is added; with Remove set the appointment
+// is cancelled and its slot released.
+type AppointmentChange struct {
+	AppointmentID string  `json:"appointment_id,omitempty"`
+	TimeSlotID    string  `json:"time_slot_id,omitempty"`
+	DoctorID      string  `json:"doctor_id,omitempty"`
+	ServiceType   string  `json:"service_type,omitempty"`
+	Location      *string `json:"location,omitempty"`
+	Remove        bool    `json:"remove,omitempty"`
+	Reason        string  `json:"reason,omitempty"`
+}
+
+type DeleteBookingRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
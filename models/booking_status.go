@@ -0,0 +1,70 @@
+package models
+
+// Lifecycle statuses shared by bookings and appointments.
+const (
+	StatusPending   = "pending"
+	StatusConfirmed = "confirmed"
+	StatusCheckedIn = "checked_in"
+	StatusCompleted = "completed"
+	StatusCancelled = "cancelled"
+	StatusNoShow    = "no_show"
+)
+
+// statusTransitions lists, for each status, the statuses it may move to and
+// the permissions, any one of which allows that move. Statuses without an
+// entry are final.
+var statusTransitions = map[string]map[string][]string{
+	StatusPending: {
+		StatusConfirmed: {PermBookingStatusManage},
+		StatusCancelled: {PermBookingCancelOwn, PermBookingCancelAny},
+	},
+	StatusConfirmed: {
+		StatusCheckedIn: {PermBookingStatusManage},
+		StatusCancelled: {PermBookingCancelOwn, PermBookingCancelAny},
+		StatusNoShow:    {PermBookingStatusManage},
+	},
+	StatusCheckedIn: {
+		StatusCompleted: {PermBookingStatusManage},
+	},
+}
+
+// IsValidStatus reports whether status is part of the booking lifecycle.
+func IsValidStatus(status string) bool {
+	switch status {
+	case StatusPending, StatusConfirmed, StatusCheckedIn, StatusCompleted, StatusCancelled, StatusNoShow:
+		return true
+	}
+	return false
+}
+
+// CanTransition reports whether a caller holding the permissions reported
+// by has may move a booking or appointment from one status to another.
+func CanTransition(from, to string, has func(permission string) bool) bool {
+	permissions, ok := statusTransitions[from][to]
+	if !ok {
+		return false
+	}
+	for _, permission := range permissions {
+		if has(permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsInitialStatus reports whether a new booking may start in status. Only
+// callers who may confirm bookings can create them already confirmed.
+func IsInitialStatus(status string, has func(permission string) bool) bool {
+	switch status {
+	case StatusPending:
+		return true
+	case StatusConfirmed:
+		return has(PermBookingStatusManage)
+	}
+	return false
+}
+
+type UpdateStatusRequest struct {
+	Status string `json:"status" binding:"required"`
+	Reason string `json:"reason"`
+}
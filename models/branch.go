@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Branch is a clinic site. Doctors, schedules and bookings belong to one.
+type Branch struct {
+	ID        string    `json:"id" db:"id"`
+	Code      string    `json:"code" db:"code"`
+	Name      string    `json:"name" db:"name"`
+	Address   *string   `json:"address,omitempty" db:"address"`
+	Phone     *string   `json:"phone,omitempty" db:"phone"`
+	IsActive  bool      `json:"is_active" db:"is_active"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type BranchRequest struct {
+	Code     *string `json:"code,omitempty"`
+	Name     *string `json:"name,omitempty"`
+	Address  *string `json:"address,omitempty"`
+	Phone    *string `json:"phone,omitempty"`
+	IsActive *bool   `json:"is_active,omitempty"`
+}
@@ -0,0 +1,39 @@
+package models
+
+type Dashboard struct {
+	DateFrom          string              `json:"date_from"`
+	DateTo            string              `json:"date_to"`
+	TotalBookings     int                 `json:"total_bookings"`
+	BookingsByStatus  map[string]int      `json:"bookings_by_status"`
+	DoctorUtilisation []DoctorUtilisation `json:"doctor_utilisation"`
+	UpcomingCheckIns  []UpcomingCheckIn   `json:"upcoming_check_ins"`
+	NoShowCount       int                 `json:"no_show_count"`
+	NoShowRate        float64             `json:"no_show_rate"`
+	BookingsByCompany []CompanyBookings   `json:"bookings_by_company"`
+}
+
+type DoctorUtilisation struct {
+	DoctorID    string  `json:"doctor_id"`
+	DoctorName  string  `json:"doctor_name"`
+	Booked      int     `json:"booked"`
+	Capacity    int     `json:"capacity"`
+	Utilisation float64 `json:"utilisation"`
+}
+
+type UpcomingCheckIn struct {
+	AppointmentID string  `json:"appointment_id"`
+	BookingID     string  `json:"booking_id"`
+	BookingNumber *string `json:"booking_number,omitempty"`
+	CustomerName  string  `json:"customer_name"`
+	CustomerPhone string  `json:"customer_phone"`
+	DoctorName    string  `json:"doctor_name"`
+	ServiceType   string  `json:"service_type"`
+	Status        string  `json:"status"`
+	ScheduleDate  string  `json:"schedule_date"`
+	StartTime     string  `json:"start_time"`
+}
+
+type CompanyBookings struct {
+	CompanyName  string `json:"company_name"`
+	BookingCount int    `json:"booking_count"`
+}
@@ -1,20 +1,70 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
+// Doctor.Specialty holds the name of the doctor's primary specialty and is
+// kept in sync with the catalogue; Specialties lists all of them when embedded.
 type Doctor struct {
-	ID        string    `json:"id" db:"id"`
-	FullName  string    `json:"full_name" db:"full_name"`
-	Title     *string   `json:"title,omitempty" db:"title"`
-	Specialty string    `json:"specialty" db:"specialty"`
-	IsActive  bool      `json:"is_active" db:"is_active"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID            string     `json:"id" db:"id"`
+	FullName      string     `json:"full_name" db:"full_name"`
+	Title         *string    `json:"title,omitempty" db:"title"`
+	Specialty     string     `json:"specialty" db:"specialty"`
+	BranchID      string     `json:"branch_id" db:"branch_id"`
+	IsActive      bool       `json:"is_active" db:"is_active"`
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty" db:"deactivated_at"`
+	DeactivatedBy *string    `json:"deactivated_by,omitempty" db:"deactivated_by"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+
+	Specialties       []Specialty    `json:"specialties,omitempty"`
+	NextAvailableSlot *AvailableSlot `json:"next_available_slot,omitempty"`
+}
+
+// DoctorRequest creates or updates a doctor. SpecialtyIDs come from the
+// specialty catalogue; the first one is the primary specialty. BranchID is
+// the doctor's home branch and is required for new doctors. TitleSet reports
+// whether the body had a title key, so that "title": null clears the title.
+type DoctorRequest struct {
+	FullName     *string  `json:"full_name,omitempty"`
+	Title        *string  `json:"title,omitempty"`
+	TitleSet     bool     `json:"-"`
+	SpecialtyIDs []string `json:"specialty_ids,omitempty"`
+	BranchID     *string  `json:"branch_id,omitempty"`
+	IsActive     *bool    `json:"is_active,omitempty"`
+}
+
+func (r *DoctorRequest) UnmarshalJSON(data []byte) error {
+	type plain DoctorRequest
+	if err := json.Unmarshal(data, (*plain)(r)); err != nil {
+		return err
+	}
+
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	_, r.TitleSet = keys["title"]
+	return nil
+}
+
+// DeactivateDoctorRequest is the optional body of a doctor delete. ReassignTo
+// moves upcoming appointments to another doctor's matching slots.
+type DeactivateDoctorRequest struct {
+	ReassignTo string `json:"reassign_to,omitempty"`
+}
+
+type DeactivateDoctorResult struct {
+	DoctorID        string `json:"doctor_id"`
+	ReassignedCount int    `json:"reassigned_count"`
 }
 
 type DoctorSchedule struct {
 	ID           string    `json:"id" db:"id"`
 	DoctorID     string    `json:"doctor_id" db:"doctor_id"`
+	BranchID     string    `json:"branch_id" db:"branch_id"`
 	ScheduleDate string    `json:"schedule_date" db:"schedule_date"`
 	DayOfWeek    *string   `json:"day_of_week,omitempty" db:"day_of_week"`
 	IsAvailable  bool      `json:"is_available" db:"is_available"`
@@ -32,6 +82,10 @@ type TimeSlot struct {
 	CurrentBookings  int       `json:"current_bookings" db:"current_bookings"`
 	CreatedAt        time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt        time.Time `json:"updated_at" db:"updated_at"`
+
+	BlockedAt     *time.Time `json:"blocked_at,omitempty" db:"blocked_at"`
+	BlockedBy     *string    `json:"blocked_by,omitempty" db:"blocked_by"`
+	BlockedReason *string    `json:"blocked_reason,omitempty" db:"blocked_reason"`
 }
 
 type TimeSlotWithDoctor struct {
@@ -42,13 +96,60 @@ type TimeSlotWithDoctor struct {
 	Specialty   string  `json:"specialty"`
 }
 
+// AvailableSlot.Specialty is the specialty the slot was found under;
+// Specialties lists all of the doctor's catalogue specialties when the slot
+// comes from an availability search.
 type AvailableSlot struct {
-	TimeSlotID      string  `json:"time_slot_id"`
-	DoctorID        string  `json:"doctor_id"`
-	DoctorName      string  `json:"doctor_name"`
-	DoctorTitle     *string `json:"doctor_title,omitempty"`
-	Specialty       string  `json:"specialty"`
-	StartTime       string  `json:"start_time"`
-	EndTime         string  `json:"end_time"`
-	AvailableSlots  int     `json:"available_slots"`
+	TimeSlotID     string      `json:"time_slot_id"`
+	DoctorID       string      `json:"doctor_id"`
+	DoctorName     string      `json:"doctor_name"`
+	DoctorTitle    *string     `json:"doctor_title,omitempty"`
+	Specialty      string      `json:"specialty"`
+	Specialties    []Specialty `json:"specialties,omitempty"`
+	BranchID       string      `json:"branch_id"`
+	ScheduleDate   string      `json:"schedule_date"`
+	StartTime      string      `json:"start_time"`
+	EndTime        string      `json:"end_time"`
+	AvailableSlots int         `json:"available_slots"`
+}
+
+// SlotBlockRequest selects time slots to block or unblock. Slots are chosen
+// either by explicit SlotIDs, or by a date range optionally narrowed to one
+// doctor or branch and a time-of-day window (omit DoctorID to cover every
+// doctor). Only slots at the caller's branches are matched.
+type SlotBlockRequest struct {
+	SlotIDs  []string `json:"slot_ids,omitempty"`
+	DoctorID string   `json:"doctor_id,omitempty"`
+	BranchID string   `json:"branch_id,omitempty"`
+	DateFrom string   `json:"date_from,omitempty"`
+	DateTo   string   `json:"date_to,omitempty"`
+	TimeFrom string   `json:"time_from,omitempty"`
+	TimeTo   string   `json:"time_to,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+	// DryRun lists the slots and bookings that would be affected without
+	// changing anything.
+	DryRun bool `json:"dry_run,omitempty"`
+	// Force blocks slots that still have bookings, flagging those
+	// appointments for rescheduling and notifying the customers.
+	Force bool `json:"force,omitempty"`
+}
+
+type AffectedAppointment struct {
+	AppointmentID string  `json:"appointment_id"`
+	BookingID     string  `json:"booking_id"`
+	BookingNumber *string `json:"booking_number,omitempty"`
+	TimeSlotID    string  `json:"time_slot_id"`
+	ScheduleDate  string  `json:"schedule_date"`
+	StartTime     string  `json:"start_time"`
+	CustomerID    string  `json:"customer_id"`
+	CustomerName  string  `json:"customer_name"`
+	CustomerPhone string  `json:"customer_phone"`
+}
+
+type SlotBlockResult struct {
+	SlotIDs              []string              `json:"slot_ids"`
+	BlockedCount         int                   `json:"blocked_count"`
+	UnblockedCount       int                   `json:"unblocked_count"`
+	AffectedAppointments []AffectedAppointment `json:"affected_appointments"`
+	DryRun               bool                  `json:"dry_run"`
 }
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Notification events sent to customers.
+const (
+	NotificationBookingConfirmed       = "booking_confirmed"
+	NotificationAppointmentReminder    = "appointment_reminder"
+	NotificationAppointmentRescheduled = "appointment_rescheduled"
+	NotificationRescheduleNeeded       = "reschedule_needed"
+	NotificationBookingCancelled       = "booking_cancelled"
+)
+
+// Notification queue statuses.
+const (
+	NotificationPending = "pending"
+	NotificationSending = "sending"
+	NotificationSent    = "sent"
+	NotificationFailed  = "failed"
+)
+
+// Notification is a row of notification_queue. DedupeKey is unique, so the
+// same event is never queued twice for the same booking or appointment.
+type Notification struct {
+	ID            string     `json:"id" db:"id"`
+	UserID        *string    `json:"user_id,omitempty" db:"user_id"`
+	Phone         string     `json:"phone" db:"phone"`
+	BookingID     *string    `json:"booking_id,omitempty" db:"booking_id"`
+	AppointmentID *string    `json:"appointment_id,omitempty" db:"appointment_id"`
+	Event         string     `json:"event" db:"event"`
+	Language      string     `json:"language" db:"language"`
+	Message       string     `json:"message" db:"message"`
+	DedupeKey     string     `json:"dedupe_key" db:"dedupe_key"`
+	Status        string     `json:"status" db:"status"`
+	Attempts      int        `json:"attempts" db:"attempts"`
+	MaxAttempts   int        `json:"max_attempts" db:"max_attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" db:"next_attempt_at"`
+	LastError     *string    `json:"last_error,omitempty" db:"last_error"`
+	Provider      *string    `json:"provider,omitempty" db:"provider"`
+	MessageID     *string    `json:"message_id,omitempty" db:"message_id"`
+	SentAt        *time.Time `json:"sent_at,omitempty" db:"sent_at"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+}
@@ -11,6 +11,8 @@ type OTP struct {
 	Attempts  int       `json:"attempts" db:"attempts"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	// Provider is the SMS provider that issued a managed OTP token.
+	Provider *string `json:"provider,omitempty" db:"provider"`
 }
 
 type RequestOTPRequest struct {
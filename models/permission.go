@@ -0,0 +1,51 @@
+package models
+
+// Permissions checked by the API. Roles are granted permissions in the
+// role_permissions table; the ":own" variants only cover the caller's own
+// records.
+const (
+	PermBookingReadOwn      = "booking:read:own"
+	PermBookingReadAny      = "booking:read:any"
+	PermBookingWriteOwn     = "booking:write:own"
+	PermBookingWriteAny     = "booking:write:any"
+	PermBookingCancelOwn    = "booking:cancel:own"
+	PermBookingCancelAny    = "booking:cancel:any"
+	PermBookingStatusManage = "booking:status:manage"
+	PermBookingDelete       = "booking:delete"
+	PermDashboardRead       = "dashboard:read"
+	PermSMSRead             = "sms:read"
+	PermSlotBlock           = "slot:block"
+	PermScheduleRead        = "schedule:read"
+	PermScheduleWrite       = "schedule:write"
+	PermDoctorWrite         = "doctor:write"
+	PermUserRead            = "user:read"
+	PermUserWrite           = "user:write"
+	PermUserWriteStaff      = "user:write:staff"
+	PermUserRoleAssign      = "user:role:assign"
+	PermRoleManage          = "role:manage"
+	PermBranchAny           = "branch:any"
+	PermBranchManage        = "branch:manage"
+)
+
+// RoleCustomer is the role of self-registered users.
+const RoleCustomer = "customer"
+
+// Permission is a row of the permissions catalogue.
+type Permission struct {
+	Name        string `json:"name" db:"name"`
+	Description string `json:"description" db:"description"`
+}
+
+// Role is a row of the roles table with the permissions it grants.
+type Role struct {
+	Name        string   `json:"name" db:"name"`
+	Description *string  `json:"description,omitempty" db:"description"`
+	Permissions []string `json:"permissions" db:"-"`
+}
+
+// SaveRoleRequest creates a role or replaces its description and
+// permissions.
+type SaveRoleRequest struct {
+	Description *string  `json:"description"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
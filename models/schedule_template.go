@@ -0,0 +1,78 @@
+package models
+
+import "time"
+
+// ScheduleTemplate is a weekly working pattern for one doctor at one branch.
+// DayOfWeek follows time.Weekday (0 = Sunday).
+type ScheduleTemplate struct {
+	ID            string          `json:"id" db:"id"`
+	DoctorID      string          `json:"doctor_id" db:"doctor_id"`
+	BranchID      string          `json:"branch_id" db:"branch_id"`
+	DayOfWeek     int             `json:"day_of_week" db:"day_of_week"`
+	StartTime     string          `json:"start_time" db:"start_time"`
+	EndTime       string          `json:"end_time" db:"end_time"`
+	SlotMinutes   int             `json:"slot_minutes" db:"slot_minutes"`
+	MaxCapacity   int             `json:"max_capacity" db:"max_capacity"`
+	Breaks        []ScheduleBreak `json:"breaks" db:"breaks"`
+	EffectiveFrom *string         `json:"effective_from,omitempty" db:"effective_from"`
+	EffectiveTo   *string         `json:"effective_to,omitempty" db:"effective_to"`
+	IsActive      bool            `json:"is_active" db:"is_active"`
+	CreatedBy     *string         `json:"created_by,omitempty" db:"created_by"`
+	UpdatedBy     *string         `json:"updated_by,omitempty" db:"updated_by"`
+	CreatedAt     time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// ScheduleBreak is a period inside a template's working hours with no slots.
+type ScheduleBreak struct {
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+}
+
+// ScheduleTemplateRequest creates or replaces a template. BranchID defaults
+// to the doctor's home branch.
+type ScheduleTemplateRequest struct {
+	DoctorID      string          `json:"doctor_id" binding:"required"`
+	BranchID      string          `json:"branch_id,omitempty"`
+	DayOfWeek     *int            `json:"day_of_week" binding:"required"`
+	StartTime     string          `json:"start_time" binding:"required"`
+	EndTime       string          `json:"end_time" binding:"required"`
+	SlotMinutes   int             `json:"slot_minutes" binding:"required"`
+	MaxCapacity   int             `json:"max_capacity"`
+	Breaks        []ScheduleBreak `json:"breaks"`
+	EffectiveFrom *string         `json:"effective_from,omitempty"`
+	EffectiveTo   *string         `json:"effective_to,omitempty"`
+	IsActive      *bool           `json:"is_active,omitempty"`
+}
+
+// ScheduleGenerateRequest selects the horizon to preview or apply. DateFrom
+// defaults to today and Days to the configured rolling horizon.
+type ScheduleGenerateRequest struct {
+	DoctorID string `json:"doctor_id,omitempty"`
+	BranchID string `json:"branch_id,omitempty"`
+	DateFrom string `json:"date_from,omitempty"`
+	Days     int    `json:"days,omitempty"`
+}
+
+// PlannedSchedule is one doctor's working day produced from a template.
+type PlannedSchedule struct {
+	DoctorID     string        `json:"doctor_id"`
+	BranchID     string        `json:"branch_id"`
+	ScheduleDate string        `json:"schedule_date"`
+	DayOfWeek    string        `json:"day_of_week"`
+	Slots        []PlannedSlot `json:"slots"`
+	// Exists is set in previews when the doctor already has a schedule that
+	// day; applying the plan only adds missing slots to it.
+	Exists bool `json:"exists"`
+}
+
+type PlannedSlot struct {
+	StartTime   string `json:"start_time"`
+	EndTime     string `json:"end_time"`
+	MaxCapacity int    `json:"max_capacity"`
+}
+
+type ScheduleApplyResult struct {
+	SchedulesCreated int `json:"schedules_created"`
+	SlotsCreated     int `json:"slots_created"`
+}
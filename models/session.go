@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Session is a login that can be refreshed until it expires or is revoked.
+// The refresh token hashes are never serialised.
+type Session struct {
+	ID            string     `json:"id" db:"id"`
+	UserID        string     `json:"user_id" db:"user_id"`
+	Provider      string     `json:"provider" db:"provider"`
+	UserAgent     *string    `json:"user_agent,omitempty" db:"user_agent"`
+	IPAddress     *string    `json:"ip_address,omitempty" db:"ip_address"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt    time.Time  `json:"last_used_at" db:"last_used_at"`
+	ExpiresAt     time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	RevokedReason *string    `json:"revoked_reason,omitempty" db:"revoked_reason"`
+}
+
+// RefreshRequest carries the refresh token when it is not sent as a cookie.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// SMS message statuses. send_failed means the provider rejected the request;
+// delivered, failed and expired come from provider delivery reports.
+const (
+	SMSStatusSent       = "sent"
+	SMSStatusSendFailed = "send_failed"
+	SMSStatusDelivered  = "delivered"
+	SMSStatusFailed     = "failed"
+	SMSStatusExpired    = "expired"
+)
+
+// SMSMessage is one send attempt through one provider. The message text is
+// not stored because it may contain an OTP code.
+type SMSMessage struct {
+	ID              string                 `json:"id" db:"id"`
+	Provider        string                 `json:"provider" db:"provider"`
+	MessageID       *string                `json:"message_id,omitempty" db:"message_id"`
+	Phone           string                 `json:"phone" db:"phone"`
+	Purpose         string                 `json:"purpose" db:"purpose"`
+	Status          string                 `json:"status" db:"status"`
+	Error           *string                `json:"error,omitempty" db:"error"`
+	Report          map[string]interface{} `json:"report,omitempty" db:"report"`
+	StatusUpdatedAt *time.Time             `json:"status_updated_at,omitempty" db:"status_updated_at"`
+	CreatedAt       time.Time              `json:"created_at" db:"created_at"`
+}
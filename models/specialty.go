@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Specialty is an entry in the managed specialty catalogue.
+type Specialty struct {
+	ID          string    `json:"id" db:"id"`
+	Name        string    `json:"name" db:"name"`
+	NameEn      *string   `json:"name_en,omitempty" db:"name_en"`
+	Description *string   `json:"description,omitempty" db:"description"`
+	IsActive    bool      `json:"is_active" db:"is_active"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type SpecialtyRequest struct {
+	Name        *string `json:"name,omitempty"`
+	NameEn      *string `json:"name_en,omitempty"`
+	Description *string `json:"description,omitempty"`
+	IsActive    *bool   `json:"is_active,omitempty"`
+}
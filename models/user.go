@@ -21,15 +21,29 @@ type User struct {
 	IsActive    bool      `json:"is_active" db:"is_active"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	// PreferredLanguage selects the notification language ("th" or "en").
+	PreferredLanguage string `json:"preferred_language,omitempty" db:"preferred_language"`
+	// IdentityProvider and IdentitySubject identify staff who log in with
+	// an OIDC provider such as Azure AD.
+	IdentityProvider *string    `json:"idp_provider,omitempty" db:"idp_provider"`
+	IdentitySubject  *string    `json:"idp_subject,omitempty" db:"idp_subject"`
+	LastLoginAt      *time.Time `json:"last_login_at,omitempty" db:"last_login_at"`
+	// BranchIDs lists the branches a staff member is assigned to, from
+	// user_branches. It is loaded when a session starts or is refreshed.
+	BranchIDs []string `json:"branch_ids,omitempty" db:"-"`
 }
 
 type LoginRequest struct {
 	Phone string `json:"phone" binding:"required"`
 }
 
+// LoginResponse returns a short-lived access token (Token, valid for
+// ExpiresIn seconds) and the refresh token that renews it.
 type LoginResponse struct {
-	Token string `json:"token"`
-	User  *User  `json:"user"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int    `json:"expires_in,omitempty"`
+	User         *User  `json:"user"`
 }
 
 type RegisterRequest struct {
@@ -43,3 +57,40 @@ type RegisterRequest struct {
 	Age         *int    `json:"age,omitempty"`
 	CompanyName *string `json:"company_name,omitempty"`
 }
+
+// CreateUserRequest is used by staff to register a user on someone's behalf,
+// e.g. a walk-in customer without a smartphone. Role defaults to customer;
+// staff accounts need user:write:staff and are assigned to BranchIDs.
+type CreateUserRequest struct {
+	RegisterRequest
+	CompanyID  *string  `json:"company_id,omitempty"`
+	EmployeeID *string  `json:"employee_id,omitempty"`
+	Department *string  `json:"department,omitempty"`
+	JobTitle   *string  `json:"job_title,omitempty"`
+	Role       *string  `json:"role,omitempty"`
+	BranchIDs  []string `json:"branch_ids,omitempty"`
+}
+
+// UpdateUserRequest changes only the fields that are set. Role changes need
+// user:role:assign; BranchIDs replaces a staff member's branches;
+// IsActive=false deactivates the account.
+type UpdateUserRequest struct {
+	Phone       *string `json:"phone,omitempty"`
+	FullName    *string `json:"full_name,omitempty"`
+	BirthDate   *string `json:"birth_date,omitempty"`
+	Gender      *string `json:"gender,omitempty"`
+	Email       *string `json:"email,omitempty"`
+	Address     *string `json:"address,omitempty"`
+	BloodType   *string `json:"blood_type,omitempty"`
+	Age         *int    `json:"age,omitempty"`
+	CompanyID   *string `json:"company_id,omitempty"`
+	CompanyName *string `json:"company_name,omitempty"`
+	EmployeeID  *string `json:"employee_id,omitempty"`
+	Department  *string `json:"department,omitempty"`
+	JobTitle    *string `json:"job_title,omitempty"`
+	Role        *string `json:"role,omitempty"`
+	IsActive    *bool   `json:"is_active,omitempty"`
+
+	PreferredLanguage *string   `json:"preferred_language,omitempty"`
+	BranchIDs         *[]string `json:"branch_ids,omitempty"`
+}
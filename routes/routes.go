@@ -5,18 +5,25 @@ import (
 	"github.com/sittawut/backend-appointment/config"
 	"github.com/sittawut/backend-appointment/handlers"
 	"github.com/sittawut/backend-appointment/middleware"
+	"github.com/sittawut/backend-appointment/models"
 	"github.com/sittawut/backend-appointment/services"
 	supa "github.com/supabase-community/supabase-go"
 )
 
-func SetupRoutes(router *gin.Engine, supabaseClient *supa.Client, cfg *config.Config, smsClient services.SMSClient) {
+func SetupRoutes(router *gin.Engine, supabaseClient *supa.Client, cfg *config.Config, smsService *services.SMSService, otpService *services.OTPService, smsTracker *services.SMSTracker, rateLimitStore services.RateLimitStore, sessionService *services.SessionService, permissionService *services.PermissionService, oidcProviders *services.OIDCProviders, notifier *services.Notifier, scheduleGenerator *services.ScheduleGenerator) {
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(supabaseClient, cfg, smsClient)
-	otpHandler := handlers.NewOTPHandler(supabaseClient, cfg, smsClient)
-	azureAuthHandler := handlers.NewAzureAuthHandler(supabaseClient, cfg)
-	bookingHandler := handlers.NewBookingHandler(supabaseClient, cfg)
+	authHandler := handlers.NewAuthHandler(supabaseClient, cfg, sessionService)
+	otpHandler := handlers.NewOTPHandler(supabaseClient, cfg, otpService, sessionService)
+	oidcAuthHandler := handlers.NewOIDCAuthHandler(supabaseClient, cfg, sessionService, oidcProviders)
+	bookingHandler := handlers.NewBookingHandler(supabaseClient, cfg, notifier)
 	doctorHandler := handlers.NewDoctorHandler(supabaseClient, cfg)
-	nurseHandler := handlers.NewNurseHandler(supabaseClient, cfg)
+	nurseHandler := handlers.NewNurseHandler(supabaseClient, cfg, smsService, notifier, sessionService, permissionService)
+	scheduleHandler := handlers.NewScheduleHandler(supabaseClient, cfg, scheduleGenerator)
+	sessionHandler := handlers.NewSessionHandler(supabaseClient, cfg, sessionService)
+	smsWebhookHandler := handlers.NewSMSWebhookHandler(cfg, smsService, smsTracker)
+	roleHandler := handlers.NewRoleHandler(supabaseClient, permissionService)
+	branchHandler := handlers.NewBranchHandler(supabaseClient)
+	jwksHandler := handlers.NewJWKSHandler(sessionService.Keys())
 
 	// Health check
 	router.GET("/health", func(c *gin.Context) {
@@ -26,36 +33,68 @@ func SetupRoutes(router *gin.Engine, supabaseClient *supa.Client, cfg *config.Co
 		})
 	})
 
+	// Public keys for verifying access tokens
+	router.GET("/.well-known/jwks.json", jwksHandler.GetJWKS)
+
 	// API v1 routes
 	v1 := router.Group("/api/v1")
 	{
-		// Auth routes (public)
+		// Auth routes (public), limited per client IP and, where the body
+		// carries one, per phone number
 		auth := v1.Group("/auth")
+		auth.Use(middleware.RateLimit(rateLimitStore, "auth_ip", cfg.RateLimitAuthIP, middleware.KeyByIP))
+		byPhone := middleware.RateLimit(rateLimitStore, "auth_phone", cfg.RateLimitAuthPhone, middleware.KeyByPhone)
 		{
-			// OTP Login (THSMS)
-			auth.POST("/otp/request", otpHandler.RequestOTP)
-			auth.POST("/otp/verify", otpHandler.VerifyOTP)
-
-			// Legacy auth
-			auth.POST("/request-otp", authHandler.RequestOTP)
-			auth.POST("/verify-otp", authHandler.VerifyOTP)
-			auth.POST("/register", authHandler.Register)
-			auth.GET("/azure/callback", azureAuthHandler.AzureCallback)
-			auth.POST("/azure/token", azureAuthHandler.AzureCreateToken)
-
-			auth.POST("/logout", azureAuthHandler.Logout)
+			// OTP Login
+			auth.POST("/otp/request", byPhone, otpHandler.RequestOTP)
+			auth.POST("/otp/verify", byPhone, otpHandler.VerifyOTP)
+
+			// Legacy OTP routes, same service with the old request/response shapes
+			if cfg.LegacyOTPRoutesEnabled {
+				auth.POST("/request-otp", middleware.Deprecated("/api/v1/auth/otp/request", cfg.LegacyOTPSunset), byPhone, otpHandler.LegacyRequestOTP)
+				auth.POST("/verify-otp", middleware.Deprecated("/api/v1/auth/otp/verify", cfg.LegacyOTPSunset), byPhone, otpHandler.LegacyVerifyOTP)
+			} else {
+				auth.POST("/request-otp", middleware.Gone("/api/v1/auth/otp/request"))
+				auth.POST("/verify-otp", middleware.Gone("/api/v1/auth/otp/verify"))
+			}
+			auth.POST("/register", byPhone, authHandler.Register)
+
+			// Staff login with OIDC providers; /azure/* keeps the original
+			// Azure AD paths
+			auth.GET("/oidc/providers", oidcAuthHandler.GetProviders)
+			auth.GET("/oidc/:provider/login", oidcAuthHandler.Login)
+			auth.GET("/oidc/:provider/callback", oidcAuthHandler.Callback)
+			auth.POST("/oidc/:provider/token", oidcAuthHandler.CreateToken)
+			azure := handlers.UseOIDCProvider("azure")
+			auth.GET("/azure/login", azure, oidcAuthHandler.Login)
+			auth.GET("/azure/callback", azure, oidcAuthHandler.Callback)
+			auth.POST("/azure/token", azure, oidcAuthHandler.CreateToken)
+
+			// Sessions
+			auth.POST("/refresh", sessionHandler.Refresh)
+			auth.POST("/logout", sessionHandler.Logout)
 		}
 
+		// Provider delivery reports (authenticated by SMS_WEBHOOK_SECRET)
+		v1.POST("/webhooks/sms/:provider", smsWebhookHandler.DeliveryReport)
+
 		// Public routes - Doctors and schedules (no auth required)
-		v1.GET("/doctors", doctorHandler.GetDoctors)
-		v1.GET("/doctors/:id", doctorHandler.GetDoctorByID)
-		v1.GET("/schedules", doctorHandler.GetSchedules)
-		v1.GET("/time-slots", doctorHandler.GetTimeSlots)
-		v1.GET("/time-slots/available", doctorHandler.GetAvailableSlots)
+		public := v1.Group("")
+		public.Use(middleware.RateLimit(rateLimitStore, "public_ip", cfg.RateLimitPublicIP, middleware.KeyByIP))
+		{
+			public.GET("/branches", branchHandler.GetBranches)
+			public.GET("/doctors", doctorHandler.GetDoctors)
+			public.GET("/doctors/:id", doctorHandler.GetDoctorByID)
+			public.GET("/specialties", doctorHandler.GetSpecialties)
+			public.GET("/schedules", doctorHandler.GetSchedules)
+			public.GET("/time-slots", doctorHandler.GetTimeSlots)
+			public.GET("/time-slots/available", doctorHandler.GetAvailableSlots)
+		}
 
-		// Protected routes
+		// Protected routes, each guarded by the permissions it needs
 		protected := v1.Group("")
-		protected.Use(middleware.AuthMiddleware(cfg))
+		protected.Use(middleware.AuthMiddleware(sessionService.Keys(), sessionService), middleware.LoadPermissions(permissionService))
+		can := middleware.RequirePermission
 		{
 			// User profile
 			protected.GET("/auth/me", authHandler.GetMe)
@@ -64,37 +103,59 @@ func SetupRoutes(router *gin.Engine, supabaseClient *supa.Client, cfg *config.Co
 			// Customer bookings
 			customer := protected.Group("/bookings")
 			{
-				customer.GET("", bookingHandler.GetMyBookings)
-				customer.POST("", bookingHandler.CreateBooking)
-				customer.GET("/:id", bookingHandler.GetBookingByID)
-				customer.PUT("/:id", bookingHandler.UpdateBooking)
-				customer.DELETE("/:id", bookingHandler.CancelBooking)
+				customer.GET("", can(models.PermBookingReadOwn, models.PermBookingReadAny), bookingHandler.GetMyBookings)
+				customer.POST("", can(models.PermBookingWriteOwn, models.PermBookingWriteAny), bookingHandler.CreateBooking)
+				customer.GET("/:id", can(models.PermBookingReadOwn, models.PermBookingReadAny), bookingHandler.GetBookingByID)
+				customer.PUT("/:id", can(models.PermBookingWriteOwn, models.PermBookingWriteAny), bookingHandler.UpdateBooking)
+				customer.DELETE("/:id", can(models.PermBookingCancelOwn, models.PermBookingCancelAny), bookingHandler.CancelBooking)
+				customer.DELETE("/:id/appointments/:appointment_id", can(models.PermBookingCancelOwn, models.PermBookingCancelAny), bookingHandler.CancelAppointment)
+				customer.PUT("/:id/appointments/:appointment_id/status", can(models.PermBookingWriteOwn, models.PermBookingWriteAny), bookingHandler.UpdateAppointmentStatus)
 			}
 
-			// Nurse routes
+			// Staff routes
 			nurse := protected.Group("/nurse")
-			nurse.Use(middleware.RoleMiddleware("nurse", "admin"))
 			{
 				// Booking management
-				nurse.GET("/bookings", nurseHandler.GetAllBookings)
-				nurse.POST("/bookings", nurseHandler.CreateBookingForCustomer)
-				nurse.PUT("/bookings/:id", nurseHandler.UpdateBooking)
-				nurse.DELETE("/bookings/:id", nurseHandler.DeleteBooking)
-				nurse.GET("/dashboard", nurseHandler.GetDashboard)
+				nurse.GET("/bookings", can(models.PermBookingReadAny), nurseHandler.GetAllBookings)
+				nurse.POST("/bookings", can(models.PermBookingWriteAny), nurseHandler.CreateBookingForCustomer)
+				nurse.PUT("/bookings/:id", can(models.PermBookingWriteAny), nurseHandler.UpdateBooking)
+				nurse.DELETE("/bookings/:id", can(models.PermBookingDelete), nurseHandler.DeleteBooking)
+				nurse.GET("/dashboard", can(models.PermDashboardRead), nurseHandler.GetDashboard)
+				nurse.GET("/sms/providers", can(models.PermSMSRead), nurseHandler.GetSMSHealth)
+				nurse.GET("/sms/messages", can(models.PermSMSRead), nurseHandler.GetSMSMessages)
 
 				// Slot management
-				nurse.POST("/slots/block", nurseHandler.BlockTimeSlots)
-				nurse.POST("/slots/unblock", nurseHandler.UnblockTimeSlots)
+				nurse.POST("/slots/block", can(models.PermSlotBlock), nurseHandler.BlockTimeSlots)
+				nurse.POST("/slots/unblock", can(models.PermSlotBlock), nurseHandler.UnblockTimeSlots)
+
+				// Schedule templates
+				nurse.GET("/schedules/templates", can(models.PermScheduleRead), scheduleHandler.GetTemplates)
+				nurse.POST("/schedules/templates", can(models.PermScheduleWrite), scheduleHandler.CreateTemplate)
+				nurse.PUT("/schedules/templates/:id", can(models.PermScheduleWrite), scheduleHandler.UpdateTemplate)
+				nurse.DELETE("/schedules/templates/:id", can(models.PermScheduleWrite), scheduleHandler.DeleteTemplate)
+				nurse.POST("/schedules/preview", can(models.PermScheduleRead), scheduleHandler.PreviewSchedules)
+				nurse.POST("/schedules/apply", can(models.PermScheduleWrite), scheduleHandler.ApplySchedules)
 
 				// Doctor management
-				nurse.POST("/doctors", nurseHandler.CreateDoctor)
-				nurse.PUT("/doctors/:id", nurseHandler.UpdateDoctor)
-				nurse.DELETE("/doctors/:id", nurseHandler.DeleteDoctor)
+				nurse.POST("/doctors", can(models.PermDoctorWrite), nurseHandler.CreateDoctor)
+				nurse.PUT("/doctors/:id", can(models.PermDoctorWrite), nurseHandler.UpdateDoctor)
+				nurse.DELETE("/doctors/:id", can(models.PermDoctorWrite), nurseHandler.DeleteDoctor)
+				nurse.POST("/specialties", can(models.PermDoctorWrite), nurseHandler.CreateSpecialty)
+				nurse.PUT("/specialties/:id", can(models.PermDoctorWrite), nurseHandler.UpdateSpecialty)
 
 				// User management
-				nurse.GET("/users", nurseHandler.GetAllUsers)
-				nurse.POST("/users", nurseHandler.CreateUser)
-				nurse.PUT("/users/:id", nurseHandler.UpdateUser)
+				nurse.GET("/users", can(models.PermUserRead), nurseHandler.GetAllUsers)
+				nurse.POST("/users", can(models.PermUserWrite, models.PermUserWriteStaff), nurseHandler.CreateUser)
+				nurse.PUT("/users/:id", can(models.PermUserWrite, models.PermUserWriteStaff), nurseHandler.UpdateUser)
+
+				// Roles and permissions
+				nurse.GET("/permissions", can(models.PermRoleManage), roleHandler.GetPermissions)
+				nurse.GET("/roles", can(models.PermRoleManage), roleHandler.GetRoles)
+				nurse.PUT("/roles/:name", can(models.PermRoleManage), roleHandler.SaveRole)
+
+				// Branches
+				nurse.POST("/branches", can(models.PermBranchManage), branchHandler.CreateBranch)
+				nurse.PUT("/branches/:id", can(models.PermBranchManage), branchHandler.UpdateBranch)
 			}
 		}
 	}
@@ -0,0 +1,70 @@
+// Package rpc calls Postgres functions through PostgREST and decodes the
+// errors they raise.
+package rpc
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	supa "github.com/supabase-community/supabase-go"
+)
+
+// errorPattern matches the "(code) message" errors produced by postgrest-go.
+var errorPattern = regexp.MustCompile(`^\(([^)]*)\) (.*)$`)
+
+// Error is an exception raised inside a Postgres function.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Reason returns the tag before the first colon of messages raised as
+// '<reason>:<detail>' by our SQL functions.
+func (e *Error) Reason() string {
+	reason, _, _ := strings.Cut(e.Message, ":")
+	return reason
+}
+
+// Detail returns the part after the first colon, usually a row ID.
+func (e *Error) Detail() string {
+	_, detail, _ := strings.Cut(e.Message, ":")
+	return detail
+}
+
+// Call invokes a Postgres function and decodes its JSON result into out.
+// Errors raised by the function are returned as *Error.
+//
+// The request goes through From("rpc/<name>") instead of Client.Rpc because
+// Rpc neither reports HTTP errors nor resets the client error it records on
+// failure, which would poison every later query on the shared client.
+func Call(client *supa.Client, name string, params interface{}, out interface{}) error {
+	data, _, err := client.From("rpc/"+name).
+		Insert(params, false, "", "", "").
+		Execute()
+	if err != nil {
+		if m := errorPattern.FindStringSubmatch(err.Error()); m != nil {
+			return &Error{Code: m[1], Message: m[2]}
+		}
+		return err
+	}
+
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// IsUniqueViolation reports whether a PostgREST request failed on a unique
+// constraint.
+func IsUniqueViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+	m := errorPattern.FindStringSubmatch(err.Error())
+	return m != nil && m[1] == "23505"
+}
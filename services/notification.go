@@ -0,0 +1,373 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sittawut/backend-appointment/models"
+	"github.com/sittawut/backend-appointment/rpc"
+	"github.com/supabase-community/postgrest-go"
+	supa "github.com/supabase-community/supabase-go"
+)
+
+// NotifierOptions tunes the notification worker.
+type NotifierOptions struct {
+	// MaxAttempts is how many times a message is tried before it is marked
+	// failed. Retries back off exponentially from RetryDelay up to an hour.
+	MaxAttempts int
+	RetryDelay  time.Duration
+	BatchSize   int
+	// ReminderHour is the local hour from which day-before reminders for
+	// tomorrow's bookings are queued.
+	ReminderHour int
+}
+
+// Notifier queues customer notifications for booking events and sends them
+// from a background worker.
+type Notifier struct {
+	supabase *supa.Client
+	sms      *SMSService
+	location *time.Location
+	opts     NotifierOptions
+
+	lastReminderDate string
+}
+
+func NewNotifier(supabase *supa.Client, sms *SMSService, location *time.Location, opts NotifierOptions) *Notifier {
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 5
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = time.Minute
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 50
+	}
+	return &Notifier{
+		supabase: supabase,
+		sms:      sms,
+		location: location,
+		opts:     opts,
+	}
+}
+
+// notificationRow is an appointment with the booking, customer, slot and
+// doctor details needed to render a notification.
+type notificationRow struct {
+	ID         string `json:"id"`
+	BookingID  string `json:"booking_id"`
+	TimeSlotID string `json:"time_slot_id"`
+	Status     string `json:"status"`
+	Doctor     *struct {
+		FullName string  `json:"full_name"`
+		Title    *string `json:"title"`
+	} `json:"doctor"`
+	TimeSlot struct {
+		StartTime string `json:"start_time"`
+		Schedule  struct {
+			ScheduleDate string `json:"schedule_date"`
+		} `json:"schedule"`
+	} `json:"time_slot"`
+	Booking struct {
+		ID              string  `json:"id"`
+		BookingNumber   *string `json:"booking_number"`
+		AppointmentDate string  `json:"appointment_date"`
+		Customer        struct {
+			ID                string  `json:"id"`
+			FullName          string  `json:"full_name"`
+			Phone             string  `json:"phone"`
+			PreferredLanguage *string `json:"preferred_language"`
+		} `json:"customer"`
+	} `json:"booking"`
+}
+
+const notificationSelect = "id, booking_id, time_slot_id, status, " +
+	"doctor:doctors(full_name, title), " +
+	"time_slot:time_slots(start_time, schedule:doctor_schedules(schedule_date)), " +
+	"booking:bookings!inner(id, booking_number, appointment_date, customer:users!customer_id(id, full_name, phone, preferred_language))"
+
+func (r notificationRow) data(reason string) NotificationData {
+	d := NotificationData{
+		CustomerName: r.Booking.Customer.FullName,
+		Date:         formatNotificationDate(r.TimeSlot.Schedule.ScheduleDate),
+		Time:         formatNotificationTime(r.TimeSlot.StartTime),
+		Reason:       reason,
+	}
+	if d.Date == "" {
+		d.Date = formatNotificationDate(r.Booking.AppointmentDate)
+	}
+	if r.Booking.BookingNumber != nil {
+		d.BookingNumber = *r.Booking.BookingNumber
+	}
+	if r.Doctor != nil {
+		d.Doctor = r.Doctor.FullName
+		if r.Doctor.Title != nil && *r.Doctor.Title != "" {
+			d.Doctor = *r.Doctor.Title + " " + r.Doctor.FullName
+		}
+	}
+	return d
+}
+
+func (r notificationRow) language() string {
+	if r.Booking.Customer.PreferredLanguage != nil {
+		return *r.Booking.Customer.PreferredLanguage
+	}
+	return "th"
+}
+
+// NotifyBooking queues a booking-level event (confirmation, cancellation) for
+// the booking's customer, describing its earliest appointment. Errors are
+// logged; a failed notification never fails the booking change itself.
+func (n *Notifier) NotifyBooking(bookingID, event, reason string) {
+	query := n.supabase.From("appointments").
+		Select(notificationSelect, "", false).
+		Eq("booking_id", bookingID)
+	if event != models.NotificationBookingCancelled {
+		query = query.In("status", []string{models.StatusPending, models.StatusConfirmed})
+	}
+
+	rows, err := n.loadRows(query)
+	if err != nil {
+		fmt.Printf("[Notify] Failed to load booking %s: %v\n", bookingID, err)
+		return
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	first := earliestRow(rows)
+	if _, err := n.enqueue([]notificationRow{first}, event, reason, func(r notificationRow) (string, *string) {
+		return fmt.Sprintf("%s:%s", event, r.BookingID), nil
+	}); err != nil {
+		fmt.Printf("[Notify] Failed to queue %s for booking %s: %v\n", event, bookingID, err)
+	}
+}
+
+// NotifyAppointments queues an appointment-level event (rescheduled,
+// reschedule needed). The current slot is part of the dedupe key, so a
+// customer hears about each move once.
+func (n *Notifier) NotifyAppointments(appointmentIDs []string, event, reason string) {
+	if len(appointmentIDs) == 0 {
+		return
+	}
+
+	rows, err := n.loadRows(n.supabase.From("appointments").
+		Select(notificationSelect, "", false).
+		In("id", appointmentIDs))
+	if err != nil {
+		fmt.Printf("[Notify] Failed to load appointments: %v\n", err)
+		return
+	}
+
+	if _, err := n.enqueue(rows, event, reason, func(r notificationRow) (string, *string) {
+		id := r.ID
+		return fmt.Sprintf("%s:%s:%s", event, r.ID, r.TimeSlotID), &id
+	}); err != nil {
+		fmt.Printf("[Notify] Failed to queue %s: %v\n", event, err)
+	}
+}
+
+// QueueReminders queues day-before reminders for open bookings on date. It is
+// safe to call repeatedly: each booking is reminded once per date.
+func (n *Notifier) QueueReminders(date string) (int, error) {
+	rows, err := n.loadRows(n.supabase.From("appointments").
+		Select(notificationSelect, "", false).
+		In("status", []string{models.StatusPending, models.StatusConfirmed}).
+		Eq("booking.appointment_date", date).
+		In("booking.status", []string{models.StatusPending, models.StatusConfirmed}).
+		Is("booking.deleted_at", "null"))
+	if err != nil {
+		return 0, err
+	}
+
+	byBooking := map[string][]notificationRow{}
+	for _, r := range rows {
+		byBooking[r.BookingID] = append(byBooking[r.BookingID], r)
+	}
+	reminders := make([]notificationRow, 0, len(byBooking))
+	for _, group := range byBooking {
+		reminders = append(reminders, earliestRow(group))
+	}
+
+	return n.enqueue(reminders, models.NotificationAppointmentReminder, "", func(r notificationRow) (string, *string) {
+		return fmt.Sprintf("%s:%s:%s", models.NotificationAppointmentReminder, r.BookingID, date), nil
+	})
+}
+
+func (n *Notifier) loadRows(query *postgrest.FilterBuilder) ([]notificationRow, error) {
+	data, _, err := query.Execute()
+	if err != nil {
+		return nil, err
+	}
+	var rows []notificationRow
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// enqueue renders the event for each row and inserts it into the queue.
+// key returns the dedupe key and the appointment the message is about.
+func (n *Notifier) enqueue(rows []notificationRow, event, reason string, key func(notificationRow) (string, *string)) (int, error) {
+	items := make([]map[string]interface{}, 0, len(rows))
+	for _, r := range rows {
+		if r.Booking.Customer.Phone == "" {
+			continue
+		}
+
+		language := r.language()
+		message, err := RenderNotification(event, language, r.data(reason))
+		if err != nil {
+			return 0, err
+		}
+
+		dedupeKey, appointmentID := key(r)
+		items = append(items, map[string]interface{}{
+			"user_id":        r.Booking.Customer.ID,
+			"phone":          r.Booking.Customer.Phone,
+			"booking_id":     r.BookingID,
+			"appointment_id": appointmentID,
+			"event":          event,
+			"language":       language,
+			"message":        message,
+			"dedupe_key":     dedupeKey,
+			"max_attempts":   n.opts.MaxAttempts,
+		})
+	}
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	var inserted int
+	if err := rpc.Call(n.supabase, "enqueue_notifications", map[string]interface{}{"p_notifications": items}, &inserted); err != nil {
+		return 0, err
+	}
+	return inserted, nil
+}
+
+// Start runs the worker: every interval it queues tomorrow's reminders once
+// the reminder hour has passed and sends due messages.
+func (n *Notifier) Start(interval time.Duration) {
+	go func() {
+		for {
+			n.queueTomorrowsReminders()
+
+			sent, err := n.ProcessQueue()
+			if err != nil {
+				fmt.Printf("[Notify] Queue processing error: %v\n", err)
+			} else if sent > 0 {
+				fmt.Printf("[Notify] Sent %d notifications\n", sent)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func (n *Notifier) queueTomorrowsReminders() {
+	now := time.Now().In(n.location)
+	if now.Hour() < n.opts.ReminderHour {
+		return
+	}
+	tomorrow := now.AddDate(0, 0, 1).Format("2006-01-02")
+	if tomorrow == n.lastReminderDate {
+		return
+	}
+
+	queued, err := n.QueueReminders(tomorrow)
+	if err != nil {
+		fmt.Printf("[Notify] Failed to queue reminders for %s: %v\n", tomorrow, err)
+		return
+	}
+	n.lastReminderDate = tomorrow
+	fmt.Printf("[Notify] Queued %d reminders for %s\n", queued, tomorrow)
+}
+
+// ProcessQueue sends one batch of due notifications and returns how many
+// were delivered.
+func (n *Notifier) ProcessQueue() (int, error) {
+	if !n.sms.HasTextProvider() {
+		return 0, nil
+	}
+
+	var batch []models.Notification
+	params := map[string]interface{}{
+		"p_limit": n.opts.BatchSize,
+		"p_now":   time.Now(),
+	}
+	if err := rpc.Call(n.supabase, "claim_notifications", params, &batch); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, msg := range batch {
+		update := map[string]interface{}{"updated_at": time.Now()}
+
+		delivery, err := n.sms.SendText(SMSPurposeNotification, msg.Phone, msg.Message)
+		switch {
+		case err == nil:
+			update["status"] = models.NotificationSent
+			update["provider"] = delivery.Provider
+			update["message_id"] = delivery.MessageID
+			update["sent_at"] = time.Now()
+			update["last_error"] = nil
+			sent++
+		case msg.Attempts >= msg.MaxAttempts:
+			update["status"] = models.NotificationFailed
+			update["last_error"] = err.Error()
+		default:
+			update["status"] = models.NotificationPending
+			update["last_error"] = err.Error()
+			update["next_attempt_at"] = time.Now().Add(n.retryDelay(msg.Attempts))
+		}
+
+		if _, _, err := n.supabase.From("notification_queue").
+			Update(update, "", "").
+			Eq("id", msg.ID).
+			Execute(); err != nil {
+			fmt.Printf("[Notify] Failed to update notification %s: %v\n", msg.ID, err)
+		}
+	}
+	return sent, nil
+}
+
+// retryDelay doubles the delay after every attempt, capped at one hour.
+func (n *Notifier) retryDelay(attempts int) time.Duration {
+	delay := n.opts.RetryDelay
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
+
+// earliestRow returns the appointment that starts first.
+func earliestRow(rows []notificationRow) notificationRow {
+	sorted := append([]notificationRow(nil), rows...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a := sorted[i].TimeSlot.Schedule.ScheduleDate + sorted[i].TimeSlot.StartTime
+		b := sorted[j].TimeSlot.Schedule.ScheduleDate + sorted[j].TimeSlot.StartTime
+		return a < b
+	})
+	return sorted[0]
+}
+
+// formatNotificationDate turns YYYY-MM-DD into DD/MM/YYYY.
+func formatNotificationDate(date string) string {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return date
+	}
+	return t.Format("02/01/2006")
+}
+
+// formatNotificationTime trims HH:MM:SS to HH:MM.
+func formatNotificationTime(clock string) string {
+	if len(clock) > 5 {
+		return clock[:5]
+	}
+	return clock
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"github.com/sittawut/backend-appointment/models"
+)
+
+// NotificationData fills the notification templates.
+type NotificationData struct {
+	CustomerName  string
+	BookingNumber string
+	Date          string
+	Time          string
+	Doctor        string
+	Reason        string
+}
+
+// notificationTemplates holds the SMS text per event and language. Keep
+// messages short: Thai text uses UCS-2, so 70 characters fit in one SMS.
+var notificationTemplates = map[string]map[string]*template.Template{
+	models.NotificationBookingConfirmed: {
+		"th": mustTemplate("ยืนยันการจอง{{if .BookingNumber}} {{.BookingNumber}}{{end}}: วันที่ {{.Date}} เวลา {{.Time}} น.{{if .Doctor}} {{.Doctor}}{{end}}"),
+		"en": mustTemplate("Booking confirmed{{if .BookingNumber}} {{.BookingNumber}}{{end}}: {{.Date}} at {{.Time}}{{if .Doctor}} with {{.Doctor}}{{end}}."),
+	},
+	models.NotificationAppointmentReminder: {
+		"th": mustTemplate("แจ้งเตือน: พรุ่งนี้ {{.Date}} คุณมีนัดเวลา {{.Time}} น.{{if .Doctor}} {{.Doctor}}{{end}}"),
+		"en": mustTemplate("Reminder: you have an appointment tomorrow, {{.Date}} at {{.Time}}{{if .Doctor}} with {{.Doctor}}{{end}}."),
+	},
+	models.NotificationAppointmentRescheduled: {
+		"th": mustTemplate("นัดหมายของคุณเปลี่ยนเป็นวันที่ {{.Date}} เวลา {{.Time}} น.{{if .Doctor}} {{.Doctor}}{{end}}"),
+		"en": mustTemplate("Your appointment has been moved to {{.Date}} at {{.Time}}{{if .Doctor}} with {{.Doctor}}{{end}}."),
+	},
+	models.NotificationRescheduleNeeded: {
+		"th": mustTemplate("แจ้งเลื่อนนัด: นัดหมายวันที่ {{.Date}} เวลา {{.Time}} ไม่สามารถให้บริการได้{{if .Reason}} ({{.Reason}}){{end}} กรุณาติดต่อคลินิกเพื่อเลือกเวลาใหม่"),
+		"en": mustTemplate("Your appointment on {{.Date}} at {{.Time}} can no longer go ahead{{if .Reason}} ({{.Reason}}){{end}}. Please contact the clinic to choose a new time."),
+	},
+	models.NotificationBookingCancelled: {
+		"th": mustTemplate("การจอง{{if .BookingNumber}} {{.BookingNumber}}{{end}} วันที่ {{.Date}} ถูกยกเลิกแล้ว{{if .Reason}} ({{.Reason}}){{end}}"),
+		"en": mustTemplate("Your booking{{if .BookingNumber}} {{.BookingNumber}}{{end}} on {{.Date}} has been cancelled{{if .Reason}} ({{.Reason}}){{end}}."),
+	},
+}
+
+func mustTemplate(text string) *template.Template {
+	return template.Must(template.New("").Parse(text))
+}
+
+// RenderNotification builds the message for event in language, falling back
+// to Thai for unsupported languages.
+func RenderNotification(event, language string, data NotificationData) (string, error) {
+	byLanguage, ok := notificationTemplates[event]
+	if !ok {
+		return "", fmt.Errorf("unknown notification event %q", event)
+	}
+	tpl, ok := byLanguage[language]
+	if !ok {
+		tpl = byLanguage["th"]
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,500 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sittawut/backend-appointment/config"
+	"github.com/sittawut/backend-appointment/models"
+)
+
+// ErrOIDCTokenInvalid wraps every reason a provider's token is refused.
+// Other errors mean the provider could not be reached.
+var ErrOIDCTokenInvalid = errors.New("invalid identity token")
+
+// oidcDiscoveryTTL is how long a provider's discovery document is reused.
+const oidcDiscoveryTTL = 24 * time.Hour
+
+// staffRolePrecedence picks the role for staff whose claims map to more
+// than one, most privileged first.
+var staffRolePrecedence = []string{"admin", "nurse", "doctor"}
+
+// OIDCIdentity is the signed-in user as stated by a verified token and,
+// when loaded, the provider's profile.
+type OIDCIdentity struct {
+	Provider   string
+	Subject    string
+	Email      string
+	Name       string
+	GivenName  string
+	FamilyName string
+	Phone      string
+	EmployeeID string
+	Department string
+	JobTitle   string
+	// RoleValues are the values of the provider's role claims, such as
+	// group IDs; Role maps them to a user role.
+	RoleValues []string
+	Nonce      string
+}
+
+// FullName joins the given and family names, falling back to Name.
+func (i *OIDCIdentity) FullName() string {
+	if full := strings.TrimSpace(i.GivenName + " " + i.FamilyName); full != "" {
+		return full
+	}
+	return i.Name
+}
+
+// OIDCTokens is a token endpoint response.
+type OIDCTokens struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider logs staff in with one OpenID Connect identity provider. The
+// discovery document is fetched on first use, so a provider that is down at
+// startup does not stop the server.
+type OIDCProvider struct {
+	cfg    config.OIDCProvider
+	client *http.Client
+
+	mu         sync.Mutex
+	discovery  *oidcDiscovery
+	keys       *RemoteKeySet
+	discovered time.Time
+}
+
+func NewOIDCProvider(cfg config.OIDCProvider, client *http.Client) *OIDCProvider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(cfg.Audiences) == 0 {
+		cfg.Audiences = []string{cfg.ClientID}
+	}
+	if cfg.ClaimMap["subject"] == "" {
+		if cfg.ClaimMap == nil {
+			cfg.ClaimMap = map[string]string{}
+		}
+		cfg.ClaimMap["subject"] = "sub"
+	}
+	return &OIDCProvider{
+		cfg:    cfg,
+		client: client,
+	}
+}
+
+// Name is the provider's configured name, used in routes and as the
+// Provider claim of access tokens.
+func (p *OIDCProvider) Name() string {
+	return p.cfg.Name
+}
+
+// AuthCodeURL returns the authorization endpoint URL for a code flow with
+// PKCE (S256) and the given state and nonce.
+func (p *OIDCProvider) AuthCodeURL(state, nonce, codeVerifier string) (string, error) {
+	discovery, _, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	query := url.Values{
+		"client_id":             {p.cfg.ClientID},
+		"response_type":         {"code"},
+		"redirect_uri":          {p.cfg.RedirectURI},
+		"response_mode":         {"query"},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems an authorization code together with its PKCE verifier.
+func (p *OIDCProvider) Exchange(code, codeVerifier string) (*OIDCTokens, error) {
+	discovery, _, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	data := url.Values{
+		"client_id":     {p.cfg.ClientID},
+		"code":          {code},
+		"code_verifier": {codeVerifier},
+		"redirect_uri":  {p.cfg.RedirectURI},
+		"grant_type":    {"authorization_code"},
+		"scope":         {strings.Join(p.cfg.Scopes, " ")},
+	}
+	if p.cfg.ClientSecret != "" {
+		data.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	resp, err := p.client.PostForm(discovery.TokenEndpoint, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token exchange failed: %s", string(body))
+	}
+
+	var tokens OIDCTokens
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal token response: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+	return &tokens, nil
+}
+
+// Verify checks an ID token, or an access token issued for this client:
+// signature against the provider's keys, issuer, audience, expiry and the
+// configured required claims. A non-empty nonce must match the token's.
+func (p *OIDCProvider) Verify(tokenString, nonce string) (*OIDCIdentity, error) {
+	discovery, keys, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return keys.Key(kid, token.Method.Alg())
+	}, jwt.WithValidMethods([]string{"RS256", "ES256"}), jwt.WithExpirationRequired(), jwt.WithLeeway(time.Minute))
+	if err != nil {
+		if errors.Is(err, ErrUnknownKey) || errors.Is(err, ErrKeyAlgorithm) || !errors.Is(err, jwt.ErrTokenUnverifiable) {
+			return nil, fmt.Errorf("%w: %v", ErrOIDCTokenInvalid, err)
+		}
+		return nil, err
+	}
+
+	issuer, _ := claims.GetIssuer()
+	if issuer != discovery.Issuer && !contains(p.cfg.ExtraIssuers, issuer) {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrOIDCTokenInvalid, issuer)
+	}
+	audiences, _ := claims.GetAudience()
+	audienceOK := false
+	for _, aud := range audiences {
+		if contains(p.cfg.Audiences, aud) {
+			audienceOK = true
+			break
+		}
+	}
+	if !audienceOK {
+		return nil, fmt.Errorf("%w: unexpected audience %v", ErrOIDCTokenInvalid, audiences)
+	}
+	for claim, want := range p.cfg.RequiredClaims {
+		if got := claimString(claims, claim); !strings.EqualFold(got, want) {
+			return nil, fmt.Errorf("%w: claim %s is %q", ErrOIDCTokenInvalid, claim, got)
+		}
+	}
+	tokenNonce := claimString(claims, "nonce")
+	if nonce != "" && subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrOIDCTokenInvalid)
+	}
+
+	identity := &OIDCIdentity{Provider: p.cfg.Name, Nonce: tokenNonce}
+	p.applyClaims(identity, claims, p.cfg.ClaimMap)
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrOIDCTokenInvalid)
+	}
+	for _, claim := range p.cfg.RoleClaims {
+		identity.RoleValues = append(identity.RoleValues, claimStrings(claims, claim)...)
+	}
+	return identity, nil
+}
+
+// LoadProfile fills in identity fields from the profile endpoint using the
+// provider's access token. The profile must belong to the same subject.
+func (p *OIDCProvider) LoadProfile(identity *OIDCIdentity, accessToken string) error {
+	discovery, _, err := p.discover()
+	if err != nil {
+		return err
+	}
+	profileURL := p.cfg.ProfileURL
+	if profileURL == "" {
+		profileURL = discovery.UserInfoEndpoint
+	}
+	if profileURL == "" {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, profileURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get profile: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get profile: status %d", resp.StatusCode)
+	}
+
+	profile := map[string]interface{}{}
+	if err := json.Unmarshal(body, &profile); err != nil {
+		return fmt.Errorf("failed to unmarshal profile: %w", err)
+	}
+	if subject := claimString(profile, p.cfg.ProfileSubjectClaim); subject != identity.Subject {
+		return fmt.Errorf("%w: profile %q does not belong to subject %q", ErrOIDCTokenInvalid, subject, identity.Subject)
+	}
+
+	profileClaims := map[string]string{}
+	for field, claim := range p.cfg.ProfileClaimMap {
+		if field != "subject" {
+			profileClaims[field] = claim
+		}
+	}
+	p.applyClaims(identity, profile, profileClaims)
+	return nil
+}
+
+// Role maps the identity's role claim values through the provider's role
+// map. Roles outside staffRolePrecedence, such as ones added to the roles
+// table later, rank below it in name order. ok is false when none of the
+// values is mapped.
+func (p *OIDCProvider) Role(identity *OIDCIdentity) (string, bool) {
+	matched := map[string]bool{}
+	for _, value := range identity.RoleValues {
+		if role, ok := p.cfg.RoleMap[value]; ok {
+			matched[role] = true
+		}
+	}
+	for _, role := range staffRolePrecedence {
+		if matched[role] {
+			return role, true
+		}
+	}
+	others := make([]string, 0, len(matched))
+	for role := range matched {
+		others = append(others, role)
+	}
+	if len(others) == 0 {
+		return "", false
+	}
+	sort.Strings(others)
+	return others[0], true
+}
+
+// applyClaims copies the mapped, non-empty claims onto identity.
+func (p *OIDCProvider) applyClaims(identity *OIDCIdentity, claims map[string]interface{}, claimMap map[string]string) {
+	fields := map[string]*string{
+		"subject":     &identity.Subject,
+		"email":       &identity.Email,
+		"name":        &identity.Name,
+		"given_name":  &identity.GivenName,
+		"family_name": &identity.FamilyName,
+		"phone":       &identity.Phone,
+		"employee_id": &identity.EmployeeID,
+		"department":  &identity.Department,
+		"job_title":   &identity.JobTitle,
+	}
+	for field, claim := range claimMap {
+		target, ok := fields[field]
+		if !ok {
+			continue
+		}
+		for _, alternative := range strings.Split(claim, "|") {
+			if value := claimString(claims, strings.TrimSpace(alternative)); value != "" {
+				*target = value
+				break
+			}
+		}
+	}
+}
+
+// discover returns the discovery document and key set, fetching them when
+// missing or older than oidcDiscoveryTTL. A failed refresh keeps the old
+// document.
+func (p *OIDCProvider) discover() (*oidcDiscovery, *RemoteKeySet, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && time.Since(p.discovered) < oidcDiscoveryTTL {
+		return p.discovery, p.keys, nil
+	}
+
+	discovery, err := p.fetchDiscovery()
+	if err != nil {
+		if p.discovery != nil {
+			fmt.Printf("[OIDC] %s discovery refresh failed, using cached document: %v\n", p.cfg.Name, err)
+			return p.discovery, p.keys, nil
+		}
+		return nil, nil, err
+	}
+	if p.keys == nil || p.discovery.JWKSURI != discovery.JWKSURI {
+		p.keys = NewRemoteKeySet(discovery.JWKSURI, p.client, 24*time.Hour)
+	}
+	p.discovery = discovery
+	p.discovered = time.Now()
+	return p.discovery, p.keys, nil
+}
+
+func (p *OIDCProvider) fetchDiscovery() (*oidcDiscovery, error) {
+	resp, err := p.client.Get(p.cfg.DiscoveryURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetch %s discovery: %w", p.cfg.Name, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("read %s discovery: %w", p.cfg.Name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s discovery: status %d", p.cfg.Name, resp.StatusCode)
+	}
+
+	var discovery oidcDiscovery
+	if err := json.Unmarshal(body, &discovery); err != nil {
+		return nil, fmt.Errorf("decode %s discovery: %w", p.cfg.Name, err)
+	}
+	if discovery.Issuer == "" || discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("%s discovery document is incomplete", p.cfg.Name)
+	}
+	return &discovery, nil
+}
+
+// OIDCProviders holds the configured providers by name.
+type OIDCProviders struct {
+	providers map[string]*OIDCProvider
+}
+
+// NewOIDCProviders checks every provider's settings and role map. Mapped
+// roles are staff roles from the roles table, so customer is refused.
+func NewOIDCProviders(configs []config.OIDCProvider) (*OIDCProviders, error) {
+	set := &OIDCProviders{providers: map[string]*OIDCProvider{}}
+	for _, cfg := range configs {
+		if _, exists := set.providers[cfg.Name]; exists {
+			return nil, fmt.Errorf("OIDC provider %q is configured twice", cfg.Name)
+		}
+		if cfg.DiscoveryURL == "" || cfg.ClientID == "" || cfg.RedirectURI == "" {
+			return nil, fmt.Errorf("OIDC provider %q needs a discovery URL, client ID and redirect URI", cfg.Name)
+		}
+		for value, role := range cfg.RoleMap {
+			if role == "" || role == models.RoleCustomer {
+				return nil, fmt.Errorf("OIDC provider %q maps %q to %q, which is not a staff role", cfg.Name, value, role)
+			}
+		}
+		set.providers[cfg.Name] = NewOIDCProvider(cfg, nil)
+	}
+	return set, nil
+}
+
+// Get returns the provider called name.
+func (s *OIDCProviders) Get(name string) (*OIDCProvider, bool) {
+	provider, ok := s.providers[name]
+	return provider, ok
+}
+
+// Names lists the configured providers, sorted.
+func (s *OIDCProviders) Names() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// claimString reads a string claim; a dotted path walks nested objects, as
+// in Keycloak's "realm_access.roles".
+func claimString(claims map[string]interface{}, path string) string {
+	switch value := claimValue(claims, path).(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	return ""
+}
+
+// claimStrings reads a claim that may be a single string or a list.
+func claimStrings(claims map[string]interface{}, path string) []string {
+	switch value := claimValue(claims, path).(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func claimValue(claims map[string]interface{}, path string) interface{} {
+	if path == "" {
+		return nil
+	}
+	if value, ok := claims[path]; ok {
+		return value
+	}
+	var current interface{} = claims
+	for _, part := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = object[part]
+	}
+	return current
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
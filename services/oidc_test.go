@@ -0,0 +1,390 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sittawut/backend-appointment/config"
+)
+
+const (
+	testClientID = "client-1"
+	testTenantID = "tenant-1"
+)
+
+// fakeIdP serves a discovery document and a JWKS holding the keys in jwks,
+// which a test may swap to roll the provider's keys.
+type fakeIdP struct {
+	*httptest.Server
+	mu          sync.Mutex
+	jwks        []*TokenKey
+	jwksFetches int
+}
+
+func newFakeIdP(t *testing.T, keys ...*TokenKey) *fakeIdP {
+	t.Helper()
+	idp := &fakeIdP{jwks: keys}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.issuer(),
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		idp.jwksFetches++
+		set := JWKSet{Keys: []JWK{}}
+		for _, key := range idp.jwks {
+			set.Keys = append(set.Keys, key.jwk())
+		}
+		json.NewEncoder(w).Encode(set)
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *fakeIdP) issuer() string {
+	return idp.URL + "/" + testTenantID + "/v2.0"
+}
+
+func (idp *fakeIdP) setKeys(keys ...*TokenKey) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.jwks = keys
+}
+
+func (idp *fakeIdP) fetches() int {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	return idp.jwksFetches
+}
+
+// provider configures an Azure-style provider that requires the tenant
+// claim.
+func (idp *fakeIdP) provider() *OIDCProvider {
+	return NewOIDCProvider(config.OIDCProvider{
+		Name:           "azure",
+		DiscoveryURL:   idp.URL + "/.well-known/openid-configuration",
+		ClientID:       testClientID,
+		RedirectURI:    "https://api.example.com/callback",
+		Scopes:         []string{"openid", "profile"},
+		RequiredClaims: map[string]string{"tid": testTenantID},
+		ClaimMap:       map[string]string{"subject": "oid", "email": "preferred_username|email", "name": "name"},
+		RoleClaims:     []string{"groups"},
+	}, nil)
+}
+
+// claims returns a valid ID token payload for idp; tests change it.
+func (idp *fakeIdP) claims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":                idp.issuer(),
+		"aud":                testClientID,
+		"sub":                "pairwise-sub",
+		"oid":                "object-1",
+		"tid":                testTenantID,
+		"nonce":              nonce,
+		"name":               "Nurse Joy",
+		"preferred_username": "joy@example.com",
+		"groups":             []string{"group-nurse"},
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Hour).Unix(),
+	}
+}
+
+func newTestRSAKey(t *testing.T, kid string) *TokenKey {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &TokenKey{ID: kid, Algorithm: "RS256", public: &private.PublicKey, private: private}
+}
+
+func newTestECKey(t *testing.T, kid string) *TokenKey {
+	t.Helper()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &TokenKey{ID: kid, Algorithm: "ES256", public: &private.PublicKey, private: private}
+}
+
+// signTestToken signs claims with key, naming kid in the header.
+func signTestToken(t *testing.T, key *TokenKey, kid string, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestOIDCProviderVerify(t *testing.T) {
+	rsaKey := newTestRSAKey(t, "rsa-1")
+	ecKey := newTestECKey(t, "ec-1")
+	otherKey := newTestRSAKey(t, "rsa-1")
+	idp := newFakeIdP(t, rsaKey, ecKey)
+
+	with := func(change func(jwt.MapClaims)) jwt.MapClaims {
+		claims := idp.claims("nonce-1")
+		change(claims)
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   func() string
+		nonce   string
+		wantErr bool
+	}{
+		{
+			name:  "valid RS256",
+			token: func() string { return signTestToken(t, rsaKey, "rsa-1", idp.claims("nonce-1")) },
+			nonce: "nonce-1",
+		},
+		{
+			name:  "valid ES256",
+			token: func() string { return signTestToken(t, ecKey, "ec-1", idp.claims("nonce-1")) },
+			nonce: "nonce-1",
+		},
+		{
+			name:  "nonce not checked when none is expected",
+			token: func() string { return signTestToken(t, rsaKey, "rsa-1", idp.claims("nonce-1")) },
+		},
+		{
+			name:    "bad signature",
+			token:   func() string { return signTestToken(t, otherKey, "rsa-1", idp.claims("nonce-1")) },
+			nonce:   "nonce-1",
+			wantErr: true,
+		},
+		{
+			name: "alg none",
+			token: func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodNone, idp.claims("nonce-1"))
+				token.Header["kid"] = "rsa-1"
+				signed, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return signed
+			},
+			nonce:   "nonce-1",
+			wantErr: true,
+		},
+		{
+			name: "HS256 keyed with the public key",
+			token: func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodHS256, idp.claims("nonce-1"))
+				token.Header["kid"] = "rsa-1"
+				signed, err := token.SignedString([]byte(rsaKey.jwk().N))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return signed
+			},
+			nonce:   "nonce-1",
+			wantErr: true,
+		},
+		{
+			name: "algorithm of another key type",
+			token: func() string {
+				return signTestToken(t, &TokenKey{Algorithm: "ES256", private: ecKey.private}, "rsa-1", idp.claims("nonce-1"))
+			},
+			nonce:   "nonce-1",
+			wantErr: true,
+		},
+		{
+			name: "wrong issuer",
+			token: func() string {
+				return signTestToken(t, rsaKey, "rsa-1", with(func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com/v2.0" }))
+			},
+			nonce:   "nonce-1",
+			wantErr: true,
+		},
+		{
+			name: "wrong audience",
+			token: func() string {
+				return signTestToken(t, rsaKey, "rsa-1", with(func(c jwt.MapClaims) { c["aud"] = "another-app" }))
+			},
+			nonce:   "nonce-1",
+			wantErr: true,
+		},
+		{
+			name: "expired",
+			token: func() string {
+				return signTestToken(t, rsaKey, "rsa-1", with(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() }))
+			},
+			nonce:   "nonce-1",
+			wantErr: true,
+		},
+		{
+			name: "no expiry",
+			token: func() string {
+				return signTestToken(t, rsaKey, "rsa-1", with(func(c jwt.MapClaims) { delete(c, "exp") }))
+			},
+			nonce:   "nonce-1",
+			wantErr: true,
+		},
+		{
+			name: "wrong tenant",
+			token: func() string {
+				return signTestToken(t, rsaKey, "rsa-1", with(func(c jwt.MapClaims) { c["tid"] = "tenant-2" }))
+			},
+			nonce:   "nonce-1",
+			wantErr: true,
+		},
+		{
+			name:    "nonce mismatch",
+			token:   func() string { return signTestToken(t, rsaKey, "rsa-1", idp.claims("nonce-2")) },
+			nonce:   "nonce-1",
+			wantErr: true,
+		},
+		{
+			name:    "unknown kid",
+			token:   func() string { return signTestToken(t, otherKey, "rsa-unknown", idp.claims("nonce-1")) },
+			nonce:   "nonce-1",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := idp.provider().Verify(tt.token(), tt.nonce)
+			if tt.wantErr {
+				if !errors.Is(err, ErrOIDCTokenInvalid) {
+					t.Fatalf("err = %v, want ErrOIDCTokenInvalid", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.Subject != "object-1" || identity.Email != "joy@example.com" || identity.Nonce != "nonce-1" {
+				t.Errorf("identity = %+v", identity)
+			}
+			if len(identity.RoleValues) != 1 || identity.RoleValues[0] != "group-nurse" {
+				t.Errorf("role values = %v, want [group-nurse]", identity.RoleValues)
+			}
+		})
+	}
+}
+
+func TestOIDCProviderVerifyRefreshesKeysForUnknownKid(t *testing.T) {
+	oldKey := newTestRSAKey(t, "key-2025")
+	newKey := newTestRSAKey(t, "key-2026")
+	idp := newFakeIdP(t, oldKey)
+	provider := idp.provider()
+
+	if _, err := provider.Verify(signTestToken(t, oldKey, "key-2025", idp.claims("")), ""); err != nil {
+		t.Fatal(err)
+	}
+	if idp.fetches() != 1 {
+		t.Fatalf("jwks fetched %d times, want 1", idp.fetches())
+	}
+
+	// The provider rolls its keys. Right after a fetch an unknown kid does
+	// not trigger another one.
+	idp.setKeys(newKey)
+	token := signTestToken(t, newKey, "key-2026", idp.claims(""))
+	if _, err := provider.Verify(token, ""); !errors.Is(err, ErrOIDCTokenInvalid) {
+		t.Fatalf("err = %v, want ErrOIDCTokenInvalid", err)
+	}
+	if idp.fetches() != 1 {
+		t.Fatalf("jwks fetched %d times within the refetch interval, want 1", idp.fetches())
+	}
+
+	provider.keys.mu.Lock()
+	provider.keys.fetched = time.Now().Add(-remoteKeyRefetchInterval)
+	provider.keys.mu.Unlock()
+
+	identity, err := provider.Verify(token, "")
+	if err != nil {
+		t.Fatalf("token signed with the new key: %v", err)
+	}
+	if identity.Subject != "object-1" {
+		t.Errorf("subject = %q, want object-1", identity.Subject)
+	}
+	if idp.fetches() != 2 {
+		t.Errorf("jwks fetched %d times, want 2", idp.fetches())
+	}
+}
+
+func TestRemoteKeySetKey(t *testing.T) {
+	rsaKey := newTestRSAKey(t, "rsa-1")
+	ecKey := newTestECKey(t, "ec-1")
+	idp := newFakeIdP(t, rsaKey, ecKey)
+	keys := NewRemoteKeySet(idp.URL+"/keys", nil, time.Hour)
+
+	tests := []struct {
+		kid, alg string
+		want     crypto.PublicKey
+		wantErr  error
+	}{
+		{kid: "rsa-1", alg: "RS256", want: rsaKey.public},
+		{kid: "ec-1", alg: "ES256", want: ecKey.public},
+		{kid: "rsa-1", alg: "ES256", wantErr: ErrKeyAlgorithm},
+		{kid: "ec-1", alg: "RS256", wantErr: ErrKeyAlgorithm},
+		{kid: "missing", alg: "RS256", wantErr: ErrUnknownKey},
+	}
+	for _, tt := range tests {
+		got, err := keys.Key(tt.kid, tt.alg)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Key(%s, %s) err = %v, want %v", tt.kid, tt.alg, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Key(%s, %s): %v", tt.kid, tt.alg, err)
+			continue
+		}
+		if !tt.want.(interface{ Equal(crypto.PublicKey) bool }).Equal(got) {
+			t.Errorf("Key(%s, %s) returned another key", tt.kid, tt.alg)
+		}
+	}
+}
+
+func TestRemoteKeySetKeepsCachedKeysWhenProviderIsDown(t *testing.T) {
+	key := newTestRSAKey(t, "rsa-1")
+	idp := newFakeIdP(t, key)
+	keys := NewRemoteKeySet(idp.URL+"/keys", nil, time.Hour)
+	if _, err := keys.Key("rsa-1", "RS256"); err != nil {
+		t.Fatal(err)
+	}
+
+	idp.Close()
+	keys.fetched = time.Now().Add(-2 * time.Hour)
+	if _, err := keys.Key("rsa-1", "RS256"); err != nil {
+		t.Errorf("cached key refused while the provider is down: %v", err)
+	}
+	if _, err := keys.Key("rsa-2", "RS256"); err == nil || errors.Is(err, ErrUnknownKey) {
+		t.Errorf("err = %v, want the fetch error for an uncached kid", err)
+	}
+}
+
+func TestJWKPublicKeyRejectsPointOffCurve(t *testing.T) {
+	jwk := newTestECKey(t, "ec-1").jwk()
+	jwk.Y = jwk.X
+	if _, err := jwk.publicKey(); err == nil {
+		t.Error("accepted an EC point that is not on P-256")
+	}
+}
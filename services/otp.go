@@ -0,0 +1,470 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/sittawut/backend-appointment/config"
+	"github.com/sittawut/backend-appointment/models"
+	"github.com/sittawut/backend-appointment/rpc"
+	"github.com/supabase-community/postgrest-go"
+	supa "github.com/supabase-community/supabase-go"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// OTP login failures. Handlers map them to responses.
+var (
+	ErrOTPUserNotFound = errors.New("phone number not found")
+	ErrOTPRateLimited  = errors.New("too many otp attempts")
+	ErrOTPNotFound     = errors.New("no valid otp found")
+	ErrOTPExpired      = errors.New("otp has expired")
+	ErrOTPMaxAttempts  = errors.New("maximum otp attempts exceeded")
+	ErrOTPInvalid      = errors.New("invalid otp code")
+)
+
+// OTP delivery methods. A text OTP is generated here, sent as a plain
+// message and stored as a bcrypt hash; a managed OTP is generated and
+// checked by the provider, and only its token is stored.
+const (
+	OTPMethodText    = "text"
+	OTPMethodManaged = "managed"
+)
+
+const (
+	// otpTextTTL matches the expiry promised in OTPMessage. Managed codes
+	// expire at the provider; the row lives a little longer so the
+	// provider's own expiry error is what the user sees.
+	otpTextTTL    = 1 * time.Minute
+	otpManagedTTL = 5 * time.Minute
+)
+
+// OTPRateLimitError reports which rate limit scope ("user", "phone" or
+// "ip") was exceeded and when to retry. It matches ErrOTPRateLimited.
+type OTPRateLimitError struct {
+	Scope      string
+	RetryAfter time.Duration
+}
+
+func (e *OTPRateLimitError) Error() string {
+	return fmt.Sprintf("%v: %s limit, retry after %s", ErrOTPRateLimited, e.Scope, e.RetryAfter)
+}
+
+func (e *OTPRateLimitError) Is(target error) bool {
+	return target == ErrOTPRateLimited
+}
+
+// OTPOptions sets the OTP attempt and rate limits. Limits are keyed by
+// scope: "user", "phone" and "ip".
+type OTPOptions struct {
+	MaxAttempts   int
+	RequestLimits map[string]config.RateLimit
+	VerifyLimits  map[string]config.RateLimit
+}
+
+// OTPChallenge is a sent OTP waiting to be verified.
+type OTPChallenge struct {
+	ID        string
+	Method    string
+	Provider  string
+	ExpiresAt time.Time
+}
+
+// otpRecord is a row of otp_codes. Rows written before migration 016 by the
+// legacy flow keep the provider token in id and have no otp_hash.
+type otpRecord struct {
+	ID            string    `json:"id"`
+	UserID        *string   `json:"user_id"`
+	Phone         string    `json:"phone"`
+	OTPHash       *string   `json:"otp_hash"`
+	Provider      *string   `json:"provider"`
+	ProviderToken *string   `json:"provider_token"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	IsUsed        bool      `json:"is_used"`
+	Attempts      int       `json:"attempts"`
+}
+
+// OTPService runs phone OTP login for every OTP route: it picks the
+// delivery method, stores the code in otp_codes, enforces attempts and rate
+// limits and writes the audit log.
+type OTPService struct {
+	supabase *supa.Client
+	sms      *SMSService
+	opts     OTPOptions
+	now      func() time.Time
+}
+
+func NewOTPService(supabase *supa.Client, sms *SMSService, opts OTPOptions) *OTPService {
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 3
+	}
+	return &OTPService{supabase: supabase, sms: sms, opts: opts, now: time.Now}
+}
+
+// Available reports whether any SMS provider can deliver an OTP.
+func (s *OTPService) Available() bool {
+	return s.sms.HasTextProvider() || s.sms.HasOTPProvider()
+}
+
+// NormalisePhone returns the local ten digit form of a Thai phone number,
+// so "081-234-5678" and "+66812345678" both become "0812345678" and share
+// one user, one OTP and one set of rate limits. Anything else is returned
+// trimmed.
+func NormalisePhone(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+
+	switch {
+	case len(digits) == 10 && digits[0] == '0':
+		return digits
+	case len(digits) == 11 && strings.HasPrefix(digits, "66"):
+		return "0" + digits[2:]
+	}
+	return strings.TrimSpace(phone)
+}
+
+// Request sends a new OTP to an active user's phone, replacing any unused
+// one. Text providers are preferred; managed OTP is used when no text
+// provider is configured or all of them fail.
+func (s *OTPService) Request(phone, ip string) (*OTPChallenge, error) {
+	phone = NormalisePhone(phone)
+	user, err := s.activeUser(phone)
+	if err != nil && !errors.Is(err, ErrOTPUserNotFound) {
+		return nil, err
+	}
+
+	// Unknown phone numbers still count against the phone and IP limits.
+	scopes := map[string]string{"phone": phone, "ip": ip}
+	if user != nil {
+		scopes["user"] = user.ID
+	}
+	if err := s.hitRateLimits("request_otp", s.opts.RequestLimits, scopes); err != nil {
+		if errors.Is(err, ErrOTPRateLimited) {
+			s.logAudit(phone, "request_otp", "failed", "rate_limited", ip, "")
+		}
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrOTPUserNotFound
+	}
+
+	row := map[string]interface{}{
+		"user_id":  user.ID,
+		"phone":    phone,
+		"is_used":  false,
+		"attempts": 0,
+	}
+	challenge := &OTPChallenge{}
+
+	var sendErr error
+	if s.sms.HasTextProvider() {
+		code := generateOTPCode()
+		var delivery *SMSDelivery
+		delivery, sendErr = s.sms.SendText(SMSPurposeOTP, phone, OTPMessage(code))
+		if sendErr == nil {
+			hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+			if err != nil {
+				return nil, err
+			}
+			row["otp_hash"] = string(hash)
+			challenge.Method = OTPMethodText
+			challenge.Provider = delivery.Provider
+			challenge.ExpiresAt = s.now().Add(otpTextTTL)
+		}
+	}
+	if challenge.Method == "" && s.sms.HasOTPProvider() {
+		if sendErr != nil {
+			fmt.Printf("[OTP] Text providers failed, falling back to managed OTP: %v\n", sendErr)
+		}
+		var delivery *SMSDelivery
+		delivery, sendErr = s.sms.SendOTP(phone)
+		if sendErr == nil {
+			row["provider_token"] = delivery.MessageID
+			challenge.Method = OTPMethodManaged
+			challenge.Provider = delivery.Provider
+			challenge.ExpiresAt = s.now().Add(otpManagedTTL)
+		}
+	}
+	if challenge.Method == "" {
+		if sendErr == nil {
+			sendErr = ErrNoSMSProvider
+		}
+		s.logAudit(phone, "request_otp", "failed", "sms_send_failed", ip, "")
+		return nil, sendErr
+	}
+
+	s.invalidateUnused(phone)
+
+	row["provider"] = challenge.Provider
+	row["expires_at"] = challenge.ExpiresAt
+	var saved []otpRecord
+	data, _, err := s.supabase.From("otp_codes").
+		Insert(row, false, "", "", "").
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &saved)
+	}
+	if err != nil || len(saved) == 0 {
+		return nil, fmt.Errorf("failed to save otp: %v", err)
+	}
+	challenge.ID = saved[0].ID
+
+	s.logAudit(phone, "request_otp", "success", challenge.Method, ip, challenge.Provider)
+	return challenge, nil
+}
+
+// Verify checks code against the latest unused OTP for phone and returns the
+// user on success. Each attempt is counted before the code is checked, and
+// the OTP is consumed on success, on expiry and by its last attempt.
+func (s *OTPService) Verify(phone, code, ip string) (*models.User, error) {
+	phone = NormalisePhone(phone)
+	user, err := s.activeUser(phone)
+	if err != nil && !errors.Is(err, ErrOTPUserNotFound) {
+		return nil, err
+	}
+
+	scopes := map[string]string{"ip": ip}
+	if user != nil {
+		scopes["user"] = user.ID
+	}
+	if err := s.hitRateLimits("verify_otp", s.opts.VerifyLimits, scopes); err != nil {
+		if errors.Is(err, ErrOTPRateLimited) {
+			s.logAudit(phone, "verify_otp", "failed", "rate_limited", ip, "")
+		}
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrOTPUserNotFound
+	}
+
+	otp, err := s.latestUnused(phone)
+	if err != nil {
+		return nil, err
+	}
+	provider := ""
+	if otp.Provider != nil {
+		provider = *otp.Provider
+	}
+
+	if s.now().After(otp.ExpiresAt) {
+		s.markUsed(otp.ID)
+		return nil, ErrOTPExpired
+	}
+
+	attempt, ok, err := s.claimAttempt(otp)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		s.markUsed(otp.ID)
+		s.logAudit(phone, "verify_otp", "failed", "max_attempts_exceeded", ip, provider)
+		return nil, ErrOTPMaxAttempts
+	}
+
+	if !s.checkCode(otp, provider, code) {
+		if attempt >= s.opts.MaxAttempts {
+			s.markUsed(otp.ID)
+		}
+		s.logAudit(phone, "verify_otp", "failed", "invalid_code", ip, provider)
+		return nil, ErrOTPInvalid
+	}
+
+	consumed, err := s.consume(otp.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !consumed {
+		return nil, ErrOTPNotFound
+	}
+	s.logAudit(phone, "verify_otp", "success", "login_successful", ip, provider)
+	return user, nil
+}
+
+func (s *OTPService) checkCode(otp *otpRecord, provider, code string) bool {
+	if otp.OTPHash != nil && *otp.OTPHash != "" {
+		return bcrypt.CompareHashAndPassword([]byte(*otp.OTPHash), []byte(code)) == nil
+	}
+
+	token := otp.ID
+	if otp.ProviderToken != nil {
+		token = *otp.ProviderToken
+	}
+	if err := s.sms.ValidateOTP(provider, token, code); err != nil {
+		fmt.Printf("[OTP] %s rejected code for %s: %v\n", provider, otp.Phone, err)
+		return false
+	}
+	return true
+}
+
+func generateOTPCode() string {
+	n, _ := rand.Int(rand.Reader, big.NewInt(1000000))
+	return fmt.Sprintf("%06d", n.Int64())
+}
+
+func (s *OTPService) activeUser(phone string) (*models.User, error) {
+	var users []models.User
+	data, _, err := s.supabase.From("users").
+		Select("*", "", false).
+		Eq("phone", phone).
+		Eq("is_active", "true").
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrOTPUserNotFound
+	}
+	return &users[0], nil
+}
+
+func (s *OTPService) latestUnused(phone string) (*otpRecord, error) {
+	var otps []otpRecord
+	data, _, err := s.supabase.From("otp_codes").
+		Select("*", "", false).
+		Eq("phone", phone).
+		Eq("is_used", "false").
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(1, "").
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &otps); err != nil {
+		return nil, err
+	}
+	if len(otps) == 0 {
+		return nil, ErrOTPNotFound
+	}
+	return &otps[0], nil
+}
+
+func (s *OTPService) invalidateUnused(phone string) {
+	if _, _, err := s.supabase.From("otp_codes").
+		Update(map[string]interface{}{"is_used": true}, "minimal", "").
+		Eq("phone", phone).
+		Eq("is_used", "false").
+		Execute(); err != nil {
+		fmt.Printf("[OTP] Failed to invalidate previous OTPs for %s: %v\n", phone, err)
+	}
+}
+
+func (s *OTPService) markUsed(id string) {
+	if _, _, err := s.supabase.From("otp_codes").
+		Update(map[string]interface{}{"is_used": true, "used_at": s.now()}, "minimal", "").
+		Eq("id", id).
+		Execute(); err != nil {
+		fmt.Printf("[OTP] Failed to mark OTP %s as used: %v\n", id, err)
+	}
+}
+
+// otpLimit is one counter passed to hit_rate_limits.
+type otpLimit struct {
+	Key           string `json:"key"`
+	Limit         int    `json:"limit"`
+	WindowSeconds int    `json:"window_seconds"`
+}
+
+// hitRateLimits counts one hit against every enabled limit for action and
+// returns an *OTPRateLimitError when any of them is exceeded. scopes maps a
+// scope name to its subject; empty subjects are skipped.
+func (s *OTPService) hitRateLimits(action string, limits map[string]config.RateLimit, scopes map[string]string) error {
+	var params []otpLimit
+	for scope, subject := range scopes {
+		limit := limits[scope]
+		if subject == "" || limit.Limit <= 0 {
+			continue
+		}
+		params = append(params, otpLimit{
+			Key:           fmt.Sprintf("%s:%s:%s", action, scope, subject),
+			Limit:         limit.Limit,
+			WindowSeconds: int(limit.Window.Seconds()),
+		})
+	}
+	if len(params) == 0 {
+		return nil
+	}
+
+	var result struct {
+		Allowed           bool    `json:"allowed"`
+		Exceeded          *string `json:"exceeded"`
+		RetryAfterSeconds int     `json:"retry_after_seconds"`
+	}
+	if err := rpc.Call(s.supabase, "hit_rate_limits", map[string]interface{}{"p_limits": params, "p_now": s.now()}, &result); err != nil {
+		return fmt.Errorf("rate limit check failed: %w", err)
+	}
+	if result.Allowed {
+		return nil
+	}
+
+	rateErr := &OTPRateLimitError{RetryAfter: time.Duration(result.RetryAfterSeconds) * time.Second}
+	if result.Exceeded != nil {
+		parts := strings.SplitN(*result.Exceeded, ":", 3)
+		if len(parts) == 3 {
+			rateErr.Scope = parts[1]
+		}
+	}
+	return rateErr
+}
+
+// claimAttempt counts a verification attempt on otp before the code is
+// checked. ok is false when the OTP has no attempts left.
+func (s *OTPService) claimAttempt(otp *otpRecord) (attempt int, ok bool, err error) {
+	var claimed *int
+	params := map[string]interface{}{
+		"p_otp_id":       otp.ID,
+		"p_phone":        otp.Phone,
+		"p_max_attempts": s.opts.MaxAttempts,
+	}
+	if err := rpc.Call(s.supabase, "claim_otp_attempt", params, &claimed); err != nil {
+		return 0, false, err
+	}
+	if claimed == nil {
+		return 0, false, nil
+	}
+	return *claimed, true, nil
+}
+
+// consume marks otp as used, reporting false when a concurrent request got
+// there first.
+func (s *OTPService) consume(id string) (bool, error) {
+	var rows []otpRecord
+	data, _, err := s.supabase.From("otp_codes").
+		Update(map[string]interface{}{"is_used": true, "used_at": s.now()}, "", "").
+		Eq("id", id).
+		Eq("is_used", "false").
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &rows)
+	}
+	return len(rows) > 0, err
+}
+
+// logAudit records an OTP event. provider is the SMS provider involved,
+// empty for events that sent nothing.
+func (s *OTPService) logAudit(phone, action, status, reason, ipAddress, provider string) {
+	auditData := map[string]interface{}{
+		"phone":      phone,
+		"action":     action,
+		"status":     status,
+		"reason":     reason,
+		"ip_address": ipAddress,
+	}
+	if provider != "" {
+		auditData["provider"] = provider
+	}
+
+	_, _, _ = s.supabase.From("otp_audit_log").
+		Insert(auditData, false, "", "", "").
+		Execute()
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	supa "github.com/supabase-community/supabase-go"
+)
+
+// PermissionService resolves the permissions a role grants from the
+// role_permissions table. Results are cached for cacheTTL; edits made on
+// another instance take up to that long to be seen here.
+type PermissionService struct {
+	supabase *supa.Client
+	cacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]roleGrant
+}
+
+type roleGrant struct {
+	exists      bool
+	permissions map[string]bool
+	loaded      time.Time
+}
+
+func NewPermissionService(supabase *supa.Client, cacheTTL time.Duration) *PermissionService {
+	return &PermissionService{
+		supabase: supabase,
+		cacheTTL: cacheTTL,
+		cache:    map[string]roleGrant{},
+	}
+}
+
+// RolePermissions returns the set of permissions granted to role. Unknown
+// roles grant nothing.
+func (s *PermissionService) RolePermissions(role string) (map[string]bool, error) {
+	grant, err := s.load(role)
+	if err != nil {
+		return nil, err
+	}
+	return grant.permissions, nil
+}
+
+// RoleExists reports whether role is defined in the roles table.
+func (s *PermissionService) RoleExists(role string) (bool, error) {
+	grant, err := s.load(role)
+	if err != nil {
+		return false, err
+	}
+	return grant.exists, nil
+}
+
+// Invalidate drops cached grants after roles have been edited.
+func (s *PermissionService) Invalidate() {
+	s.mu.Lock()
+	s.cache = map[string]roleGrant{}
+	s.mu.Unlock()
+}
+
+func (s *PermissionService) load(role string) (roleGrant, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	if grant, ok := s.cache[role]; ok && now.Sub(grant.loaded) < s.cacheTTL {
+		s.mu.Unlock()
+		return grant, nil
+	}
+	s.mu.Unlock()
+
+	var roles []struct {
+		Name            string `json:"name"`
+		RolePermissions []struct {
+			Permission string `json:"permission"`
+		} `json:"role_permissions"`
+	}
+	data, _, err := s.supabase.From("roles").
+		Select("name,role_permissions(permission)", "", false).
+		Eq("name", role).
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &roles)
+	}
+	if err != nil {
+		return roleGrant{}, err
+	}
+
+	grant := roleGrant{permissions: map[string]bool{}, loaded: now}
+	if len(roles) > 0 {
+		grant.exists = true
+		for _, rp := range roles[0].RolePermissions {
+			grant.permissions[rp.Permission] = true
+		}
+	}
+
+	s.mu.Lock()
+	s.cache[role] = grant
+	s.mu.Unlock()
+	return grant, nil
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sittawut/backend-appointment/config"
+)
+
+// RateLimitResult is the outcome of taking one token from a bucket.
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// ResetAfter is how long until the bucket is full again; RetryAfter is
+	// how long until the next token, zero when Allowed.
+	ResetAfter time.Duration
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps token buckets. A bucket for limit holds limit.Limit
+// tokens and refills at limit.Limit per limit.Window, so a client may burst
+// up to the limit and then continues at the average rate.
+type RateLimitStore interface {
+	Take(key string, limit config.RateLimit, now time.Time) (RateLimitResult, error)
+}
+
+// NewRateLimitStore returns a Redis-backed store when redisURL is set and an
+// in-process one otherwise. In-process buckets are per instance, so limits
+// multiply when the API runs on several replicas.
+func NewRateLimitStore(redisURL string) (RateLimitStore, error) {
+	if redisURL == "" {
+		return NewMemoryRateLimitStore(), nil
+	}
+	return NewRedisRateLimitStore(redisURL)
+}
+
+// bucketState computes the result of taking a token from a bucket that held
+// tokens at its last update, elapsed ago. It returns the tokens left.
+func bucketState(tokens float64, elapsed time.Duration, limit config.RateLimit) (float64, RateLimitResult) {
+	capacity := float64(limit.Limit)
+	perSecond := capacity / limit.Window.Seconds()
+
+	tokens = math.Min(capacity, tokens+elapsed.Seconds()*perSecond)
+	result := RateLimitResult{}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsDuration((1 - tokens) / perSecond)
+	}
+	result.Remaining = int(math.Floor(tokens))
+	result.ResetAfter = secondsDuration((capacity - tokens) / perSecond)
+	return tokens, result
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
+
+// memoryBucket is a token bucket held in process memory.
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	window  time.Duration
+}
+
+// MemoryRateLimitStore keeps buckets in a map. Buckets that have refilled
+// completely are dropped once a minute.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*memoryBucket{}}
+}
+
+func (s *MemoryRateLimitStore) Take(key string, limit config.RateLimit, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, b := range s.buckets {
+			if now.Sub(b.updated) > b.window {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(limit.Limit), updated: now}
+		s.buckets[key] = b
+	}
+
+	tokens, result := bucketState(b.tokens, now.Sub(b.updated), limit)
+	b.tokens = tokens
+	b.updated = now
+	b.window = limit.Window
+	return result, nil
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sittawut/backend-appointment/config"
+)
+
+// tokenBucketScript takes one token from the bucket hash at KEYS[1] and
+// returns {allowed, tokens_left}. Tokens are returned as a string because
+// Redis truncates Lua numbers to integers.
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local window_ms = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 't', 'ts')
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+local elapsed = math.max(0, now - ts)
+tokens = math.min(capacity, tokens + elapsed * capacity / window_ms)
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call('HSET', KEYS[1], 't', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], window_ms)
+return {allowed, tostring(tokens)}
+`)
+
+// RedisRateLimitStore keeps buckets in Redis, or any server that speaks the
+// Redis protocol and runs Lua scripts (Valkey, KeyDB, Dragonfly), so all
+// replicas share one limit.
+type RedisRateLimitStore struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+// NewRedisRateLimitStore parses redis://[user:password@]host:port[/db]; use
+// rediss:// for TLS.
+func NewRedisRateLimitStore(rawURL string) (*RedisRateLimitStore, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+
+	timeout := 2 * time.Second
+	opts.DialTimeout = timeout
+	opts.ReadTimeout = timeout
+	opts.WriteTimeout = timeout
+	opts.PoolSize = 8
+	return &RedisRateLimitStore{
+		client:  redis.NewClient(opts),
+		timeout: timeout,
+	}, nil
+}
+
+func (s *RedisRateLimitStore) Take(key string, limit config.RateLimit, now time.Time) (RateLimitResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	values, err := tokenBucketScript.Run(ctx, s.client, []string{key},
+		limit.Limit,
+		limit.Window.Milliseconds(),
+		now.UnixMilli()).Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	if len(values) != 2 {
+		return RateLimitResult{}, fmt.Errorf("unexpected redis reply %v", values)
+	}
+	allowed, _ := values[0].(int64)
+	tokensStr, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(tokensStr, 64)
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("unexpected redis reply %v", values)
+	}
+
+	// The script already refilled and took the token; derive the headers
+	// from what is left.
+	perSecond := float64(limit.Limit) / limit.Window.Seconds()
+	result := RateLimitResult{
+		Allowed:    allowed == 1,
+		Remaining:  int(tokens),
+		ResetAfter: secondsDuration((float64(limit.Limit) - tokens) / perSecond),
+	}
+	if !result.Allowed {
+		result.RetryAfter = secondsDuration((1 - tokens) / perSecond)
+	}
+	return result, nil
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/sittawut/backend-appointment/config"
+)
+
+// tenPerMinute refills one token every six seconds.
+var tenPerMinute = config.RateLimit{Limit: 10, Window: time.Minute}
+
+func TestBucketState(t *testing.T) {
+	tests := []struct {
+		name       string
+		tokens     float64
+		elapsed    time.Duration
+		wantTokens float64
+		want       RateLimitResult
+	}{
+		{
+			name:       "full bucket",
+			tokens:     10,
+			wantTokens: 9,
+			want:       RateLimitResult{Allowed: true, Remaining: 9, ResetAfter: 6 * time.Second},
+		},
+		{
+			name:       "last token",
+			tokens:     1,
+			wantTokens: 0,
+			want:       RateLimitResult{Allowed: true, Remaining: 0, ResetAfter: time.Minute},
+		},
+		{
+			name:       "empty bucket waits for the next token",
+			tokens:     0,
+			elapsed:    2 * time.Second,
+			wantTokens: 1.0 / 3,
+			want:       RateLimitResult{Remaining: 0, ResetAfter: 58 * time.Second, RetryAfter: 4 * time.Second},
+		},
+		{
+			name:       "refill over time",
+			tokens:     0,
+			elapsed:    30 * time.Second,
+			wantTokens: 4,
+			want:       RateLimitResult{Allowed: true, Remaining: 4, ResetAfter: 36 * time.Second},
+		},
+		{
+			name:       "refill stops at capacity",
+			tokens:     5,
+			elapsed:    time.Hour,
+			wantTokens: 9,
+			want:       RateLimitResult{Allowed: true, Remaining: 9, ResetAfter: 6 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, got := bucketState(tt.tokens, tt.elapsed, tenPerMinute)
+			if diff := tokens - tt.wantTokens; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("tokens = %v, want %v", tokens, tt.wantTokens)
+			}
+			if got.Allowed != tt.want.Allowed || got.Remaining != tt.want.Remaining {
+				t.Errorf("result = %+v, want %+v", got, tt.want)
+			}
+			assertDuration(t, "ResetAfter", got.ResetAfter, tt.want.ResetAfter)
+			assertDuration(t, "RetryAfter", got.RetryAfter, tt.want.RetryAfter)
+		})
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	testRateLimitStore(t, NewMemoryRateLimitStore())
+}
+
+func TestMemoryRateLimitStoreSweepsFullBuckets(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	now := time.Now()
+	if _, err := store.Take("a", tenPerMinute, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Take("b", tenPerMinute, now.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.buckets["a"]; ok {
+		t.Error("bucket a was refilled but not swept")
+	}
+	if _, ok := store.buckets["b"]; !ok {
+		t.Error("bucket b was swept while in use")
+	}
+}
+
+func TestRedisRateLimitStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	store, err := NewRedisRateLimitStore("redis://" + server.Addr() + "/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRateLimitStore(t, store)
+
+	// Buckets expire once a full window has passed.
+	server.FastForward(time.Minute)
+	if server.Exists("burst") {
+		t.Error("bucket key outlived its window")
+	}
+}
+
+func TestNewRedisRateLimitStoreRejectsBadURL(t *testing.T) {
+	for _, url := range []string{"http://localhost:6379", "redis://localhost:6379/x"} {
+		if _, err := NewRedisRateLimitStore(url); err == nil {
+			t.Errorf("NewRedisRateLimitStore(%q) succeeded", url)
+		}
+	}
+}
+
+// testRateLimitStore checks that a store allows a burst up to the limit,
+// then refuses with Retry-After until a token has refilled, and keeps keys
+// apart.
+func testRateLimitStore(t *testing.T, store RateLimitStore) {
+	t.Helper()
+	start := time.Now().Truncate(time.Second)
+
+	for i := 0; i < tenPerMinute.Limit; i++ {
+		result, err := store.Take("burst", tenPerMinute, start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != tenPerMinute.Limit-1-i {
+			t.Fatalf("take %d = %+v, want allowed with %d remaining", i+1, result, tenPerMinute.Limit-1-i)
+		}
+	}
+
+	result, err := store.Take("burst", tenPerMinute, start.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed || result.Remaining != 0 {
+		t.Fatalf("take past the burst = %+v, want refused", result)
+	}
+	assertDuration(t, "RetryAfter", result.RetryAfter, 5*time.Second)
+
+	result, err = store.Take("burst", tenPerMinute, start.Add(7*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed {
+		t.Fatalf("take after refill = %+v, want allowed", result)
+	}
+
+	result, err = store.Take("other", tenPerMinute, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed || result.Remaining != tenPerMinute.Limit-1 {
+		t.Fatalf("take on another key = %+v, want a full bucket", result)
+	}
+}
+
+// assertDuration allows for the rounding of fractional tokens.
+func assertDuration(t *testing.T, name string, got, want time.Duration) {
+	t.Helper()
+	if diff := got - want; diff > time.Millisecond || diff < -time.Millisecond {
+		t.Errorf("%s = %v, want %v", name, got, want)
+	}
+}
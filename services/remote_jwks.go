@@ -0,0 +1,188 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// remoteKeyRefetchInterval is the shortest gap between two fetches caused by
+// unknown key IDs, so tokens with made-up kids cannot hammer the issuer.
+const remoteKeyRefetchInterval = time.Minute
+
+// ErrUnknownKey is returned when a kid is not in the remote key set, even
+// after refetching it. ErrKeyAlgorithm is returned when the token's
+// algorithm does not fit the key it names.
+var (
+	ErrUnknownKey   = errors.New("signing key not found in key set")
+	ErrKeyAlgorithm = errors.New("key does not sign this algorithm")
+)
+
+// RemoteKeySet is a JWKS fetched from an identity provider. Keys are cached
+// for ttl and refetched early when a token names a kid we have not seen,
+// which is how providers roll their keys.
+type RemoteKeySet struct {
+	url    string
+	client *http.Client
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	keys    map[string]remoteKey
+	fetched time.Time
+}
+
+type remoteKey struct {
+	algorithm string
+	public    crypto.PublicKey
+}
+
+func NewRemoteKeySet(url string, client *http.Client, ttl time.Duration) *RemoteKeySet {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return &RemoteKeySet{
+		url:    url,
+		client: client,
+		ttl:    ttl,
+	}
+}
+
+// Key returns the public key for kid. alg is the token's algorithm and must
+// match the key type, and the key's own alg when the JWK states one.
+func (s *RemoteKeySet) Key(kid, alg string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	key, ok := s.keys[kid]
+	stale := now.Sub(s.fetched) >= s.ttl
+	if stale || (!ok && now.Sub(s.fetched) >= remoteKeyRefetchInterval) {
+		keys, err := s.fetch()
+		if err != nil {
+			if !ok {
+				return nil, err
+			}
+			// Keep using the cached key while the provider is unreachable.
+			fmt.Printf("[JWKS] Refresh %s failed, using cached keys: %v\n", s.url, err)
+		} else {
+			s.keys = keys
+			s.fetched = now
+			key, ok = keys[kid]
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+	if key.algorithm != "" && key.algorithm != alg {
+		return nil, fmt.Errorf("%w: key %q, alg %s", ErrKeyAlgorithm, kid, alg)
+	}
+	switch key.public.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return nil, fmt.Errorf("%w: key %q, alg %s", ErrKeyAlgorithm, kid, alg)
+		}
+	case *ecdsa.PublicKey:
+		if alg != "ES256" {
+			return nil, fmt.Errorf("%w: key %q, alg %s", ErrKeyAlgorithm, kid, alg)
+		}
+	}
+	return key.public, nil
+}
+
+func (s *RemoteKeySet) fetch() (map[string]remoteKey, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, fmt.Errorf("fetch key set: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("read key set: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch key set: status %d", resp.StatusCode)
+	}
+
+	var set JWKSet
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("decode key set: %w", err)
+	}
+
+	keys := map[string]remoteKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		public, err := jwk.publicKey()
+		if err != nil {
+			// Skip key types we cannot use rather than failing the set.
+			continue
+		}
+		keys[jwk.KeyID] = remoteKey{algorithm: jwk.Algorithm, public: public}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("key set has no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (j JWK) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("invalid key parameter")
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch j.KeyType {
+	case "RSA":
+		n, err := decode(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(j.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if j.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", j.Curve)
+		}
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		if x.BitLen() > 256 || y.BitLen() > 256 {
+			return nil, fmt.Errorf("invalid EC point")
+		}
+		point := append([]byte{4}, x.FillBytes(make([]byte, 32))...)
+		point = append(point, y.FillBytes(make([]byte, 32))...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.KeyType)
+	}
+}
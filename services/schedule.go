@@ -0,0 +1,234 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sittawut/backend-appointment/models"
+	"github.com/sittawut/backend-appointment/rpc"
+	supa "github.com/supabase-community/supabase-go"
+)
+
+// ScheduleGenerator turns schedule templates into doctor_schedules and
+// time_slots rows for a rolling horizon.
+type ScheduleGenerator struct {
+	supabase *supa.Client
+	location *time.Location
+}
+
+// NewScheduleGenerator creates a generator that works in the clinic's time zone
+func NewScheduleGenerator(supabase *supa.Client, location *time.Location) *ScheduleGenerator {
+	return &ScheduleGenerator{
+		supabase: supabase,
+		location: location,
+	}
+}
+
+// LoadTemplates returns the active templates, optionally for one doctor
+// only and, with branchIDs set, only those at the given branches. Templates
+// of inactive doctors are skipped.
+func (g *ScheduleGenerator) LoadTemplates(doctorID string, branchIDs []string) ([]models.ScheduleTemplate, error) {
+	query := g.supabase.From("schedule_templates").
+		Select("*, doctor:doctors!inner(is_active)", "", false).
+		Eq("is_active", "true").
+		Eq("doctor.is_active", "true")
+	if doctorID != "" {
+		query = query.Eq("doctor_id", doctorID)
+	}
+	if branchIDs != nil {
+		query = query.In("branch_id", branchIDs)
+	}
+
+	data, _, err := query.Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var templates []models.ScheduleTemplate
+	if err := json.Unmarshal(data, &templates); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// Apply materialises a plan. Existing days and slots are left untouched, so
+// applying the same plan twice creates nothing the second time.
+func (g *ScheduleGenerator) Apply(plan []models.PlannedSchedule) (*models.ScheduleApplyResult, error) {
+	if plan == nil {
+		plan = []models.PlannedSchedule{}
+	}
+
+	var result models.ScheduleApplyResult
+	if err := rpc.Call(g.supabase, "apply_schedule_plan", map[string]interface{}{"p_plan": plan}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Run loads every active template and applies the plan for the next days,
+// starting today.
+func (g *ScheduleGenerator) Run(days int) (*models.ScheduleApplyResult, error) {
+	templates, err := g.LoadTemplates("", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load templates: %w", err)
+	}
+
+	today := time.Now().In(g.location)
+	from := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	plan, err := BuildSchedulePlan(templates, from, days)
+	if err != nil {
+		return nil, err
+	}
+	return g.Apply(plan)
+}
+
+// StartRollingHorizon keeps the next days of schedules generated, running
+// once immediately and then every interval.
+func (g *ScheduleGenerator) StartRollingHorizon(days int, interval time.Duration) {
+	go func() {
+		for {
+			result, err := g.Run(days)
+			if err != nil {
+				fmt.Printf("[Schedule] Generation error: %v\n", err)
+			} else {
+				fmt.Printf("[Schedule] Generated %d schedules and %d slots\n", result.SchedulesCreated, result.SlotsCreated)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// BuildSchedulePlan expands templates into working days and slots for the
+// days starting at from (a date at midnight UTC). Templates for the same
+// doctor and weekday are merged into one day, which must be at one branch.
+func BuildSchedulePlan(templates []models.ScheduleTemplate, from time.Time, days int) ([]models.PlannedSchedule, error) {
+	plan := []models.PlannedSchedule{}
+
+	for i := 0; i < days; i++ {
+		date := from.AddDate(0, 0, i)
+		dateStr := date.Format("2006-01-02")
+
+		byDoctor := map[string]*models.PlannedSchedule{}
+		var order []string
+		for _, tpl := range templates {
+			if !tpl.IsActive || tpl.DayOfWeek != int(date.Weekday()) {
+				continue
+			}
+			if tpl.EffectiveFrom != nil && dateStr < *tpl.EffectiveFrom {
+				continue
+			}
+			if tpl.EffectiveTo != nil && dateStr > *tpl.EffectiveTo {
+				continue
+			}
+
+			slots, err := TemplateSlots(tpl)
+			if err != nil {
+				return nil, fmt.Errorf("template %s: %w", tpl.ID, err)
+			}
+
+			day, ok := byDoctor[tpl.DoctorID]
+			if !ok {
+				day = &models.PlannedSchedule{
+					DoctorID:     tpl.DoctorID,
+					BranchID:     tpl.BranchID,
+					ScheduleDate: dateStr,
+					DayOfWeek:    strings.ToLower(date.Weekday().String()),
+				}
+				byDoctor[tpl.DoctorID] = day
+				order = append(order, tpl.DoctorID)
+			} else if day.BranchID != tpl.BranchID {
+				return nil, fmt.Errorf("doctor %s has templates at two branches on %s", tpl.DoctorID, dateStr)
+			}
+			day.Slots = append(day.Slots, slots...)
+		}
+
+		for _, doctorID := range order {
+			plan = append(plan, *byDoctor[doctorID])
+		}
+	}
+
+	return plan, nil
+}
+
+// TemplateSlots cuts a template's working hours into slots of SlotMinutes,
+// skipping breaks. A slot that would overlap a break starts again when the
+// break ends; a trailing remainder shorter than one slot is dropped.
+func TemplateSlots(tpl models.ScheduleTemplate) ([]models.PlannedSlot, error) {
+	start, err := parseClock(tpl.StartTime)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(tpl.EndTime)
+	if err != nil {
+		return nil, err
+	}
+	if end <= start {
+		return nil, fmt.Errorf("end_time must be after start_time")
+	}
+	if tpl.SlotMinutes <= 0 {
+		return nil, fmt.Errorf("slot_minutes must be positive")
+	}
+
+	type span struct{ start, end int }
+	var breaks []span
+	for _, b := range tpl.Breaks {
+		bs, err := parseClock(b.StartTime)
+		if err != nil {
+			return nil, err
+		}
+		be, err := parseClock(b.EndTime)
+		if err != nil {
+			return nil, err
+		}
+		if be <= bs {
+			return nil, fmt.Errorf("break end_time must be after start_time")
+		}
+		breaks = append(breaks, span{bs, be})
+	}
+
+	capacity := tpl.MaxCapacity
+	if capacity <= 0 {
+		capacity = 1
+	}
+
+	var slots []models.PlannedSlot
+	for t := start; t+tpl.SlotMinutes <= end; {
+		next := t + tpl.SlotMinutes
+		overlap := false
+		for _, b := range breaks {
+			if t < b.end && next > b.start {
+				t = b.end
+				overlap = true
+				break
+			}
+		}
+		if overlap {
+			continue
+		}
+
+		slots = append(slots, models.PlannedSlot{
+			StartTime:   formatClock(t),
+			EndTime:     formatClock(next),
+			MaxCapacity: capacity,
+		})
+		t = next
+	}
+	return slots, nil
+}
+
+// parseClock converts "HH:MM" or "HH:MM:SS" into minutes after midnight
+func parseClock(value string) (int, error) {
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Hour()*60 + t.Minute(), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+}
+
+// formatClock converts minutes after midnight into "HH:MM:SS"
+func formatClock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d:00", minutes/60, minutes%60)
+}
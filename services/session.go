@@ -0,0 +1,231 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sittawut/backend-appointment/models"
+	"github.com/sittawut/backend-appointment/rpc"
+	supa "github.com/supabase-community/supabase-go"
+)
+
+// Session failures. ErrSessionReused means a rotated-out refresh token was
+// presented again and the session has been revoked.
+var (
+	ErrSessionInvalid = errors.New("session is invalid or expired")
+	ErrSessionReused  = errors.New("refresh token was already used")
+)
+
+// SessionOptions tunes session lifetime. RefreshTTL is extended on every
+// refresh, so it works as an idle timeout. A rotated-out refresh token is
+// still accepted for RefreshGrace, so concurrent refreshes don't end the
+// session. SessionActive results are cached for CheckCacheTTL; revocations
+// made by another instance take up to that long to be seen here.
+type SessionOptions struct {
+	RefreshTTL    time.Duration
+	RefreshGrace  time.Duration
+	CheckCacheTTL time.Duration
+}
+
+// SessionService stores login sessions and their rotating refresh tokens.
+type SessionService struct {
+	supabase *supa.Client
+	keys     *TokenKeys
+	opts     SessionOptions
+
+	mu        sync.Mutex
+	cache     map[string]sessionCheck
+	lastSweep time.Time
+}
+
+type sessionCheck struct {
+	active  bool
+	checked time.Time
+}
+
+func NewSessionService(supabase *supa.Client, keys *TokenKeys, opts SessionOptions) *SessionService {
+	if opts.RefreshTTL <= 0 {
+		opts.RefreshTTL = 30 * 24 * time.Hour
+	}
+	return &SessionService{
+		supabase: supabase,
+		keys:     keys,
+		opts:     opts,
+		cache:    map[string]sessionCheck{},
+	}
+}
+
+// Keys returns the keys that sign and verify the sessions' access tokens.
+func (s *SessionService) Keys() *TokenKeys {
+	return s.keys
+}
+
+// RefreshTTL is how long a refresh token stays valid.
+func (s *SessionService) RefreshTTL() time.Duration {
+	return s.opts.RefreshTTL
+}
+
+// Create starts a session for user and returns it with its first refresh
+// token.
+func (s *SessionService) Create(user *models.User, provider, userAgent, ip string) (*models.Session, string, error) {
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	row := map[string]interface{}{
+		"user_id":            user.ID,
+		"provider":           provider,
+		"refresh_token_hash": hash,
+		"user_agent":         userAgent,
+		"ip_address":         ip,
+		"expires_at":         time.Now().Add(s.opts.RefreshTTL),
+	}
+
+	var sessions []models.Session
+	data, _, err := s.supabase.From("sessions").
+		Insert(row, false, "", "", "").
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &sessions)
+	}
+	if err != nil || len(sessions) == 0 {
+		return nil, "", fmt.Errorf("failed to create session: %v", err)
+	}
+	return &sessions[0], token, nil
+}
+
+// Rotate exchanges a refresh token for a new one and returns the session.
+func (s *SessionService) Rotate(refreshToken string) (*models.Session, string, error) {
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var result struct {
+		Status  string          `json:"status"`
+		Session *models.Session `json:"session"`
+	}
+	params := map[string]interface{}{
+		"p_token_hash":     hashRefreshToken(refreshToken),
+		"p_new_token_hash": hash,
+		"p_expires_at":     time.Now().Add(s.opts.RefreshTTL),
+		"p_grace_seconds":  int(s.opts.RefreshGrace.Seconds()),
+	}
+	if err := rpc.Call(s.supabase, "rotate_session", params, &result); err != nil {
+		return nil, "", err
+	}
+
+	switch result.Status {
+	case "ok":
+		return result.Session, token, nil
+	case "reused":
+		fmt.Printf("[Session] Refresh token reuse detected, session revoked\n")
+		return nil, "", ErrSessionReused
+	default:
+		return nil, "", ErrSessionInvalid
+	}
+}
+
+// Revoke ends a session.
+func (s *SessionService) Revoke(sessionID, reason string) error {
+	return s.revoke("id", sessionID, reason)
+}
+
+// RevokeByRefreshToken ends the session a refresh token belongs to.
+func (s *SessionService) RevokeByRefreshToken(refreshToken, reason string) error {
+	return s.revoke("refresh_token_hash", hashRefreshToken(refreshToken), reason)
+}
+
+// RevokeUser ends every session of a user, e.g. when the account is
+// deactivated.
+func (s *SessionService) RevokeUser(userID, reason string) error {
+	return s.revoke("user_id", userID, reason)
+}
+
+func (s *SessionService) revoke(column, value, reason string) error {
+	var revoked []models.Session
+	data, _, err := s.supabase.From("sessions").
+		Update(map[string]interface{}{
+			"revoked_at":     time.Now(),
+			"revoked_reason": reason,
+		}, "", "").
+		Eq(column, value).
+		Is("revoked_at", "null").
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &revoked)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for _, session := range revoked {
+		s.cache[session.ID] = sessionCheck{active: false, checked: time.Now()}
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// SessionActive reports whether a session is neither revoked nor expired.
+func (s *SessionService) SessionActive(sessionID string) (bool, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	if check, ok := s.cache[sessionID]; ok && now.Sub(check.checked) < s.opts.CheckCacheTTL {
+		s.mu.Unlock()
+		return check.active, nil
+	}
+	s.mu.Unlock()
+
+	var sessions []models.Session
+	data, _, err := s.supabase.From("sessions").
+		Select("id", "", false).
+		Eq("id", sessionID).
+		Is("revoked_at", "null").
+		Gt("expires_at", now.UTC().Format(time.RFC3339)).
+		Execute()
+	if err == nil {
+		err = json.Unmarshal(data, &sessions)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	active := len(sessions) > 0
+	s.mu.Lock()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for id, check := range s.cache {
+			if now.Sub(check.checked) >= s.opts.CheckCacheTTL {
+				delete(s.cache, id)
+			}
+		}
+		s.lastSweep = now
+	}
+	s.cache[sessionID] = sessionCheck{active: active, checked: now}
+	s.mu.Unlock()
+	return active, nil
+}
+
+// newRefreshToken returns a random token and the hash stored for it.
+func newRefreshToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashRefreshToken(token), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
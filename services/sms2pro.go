@@ -6,11 +6,27 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sittawut/backend-appointment/config"
 )
 
+func init() {
+	RegisterSMSProvider("sms2pro", func(cfg *config.Config) (SMSProvider, error) {
+		if cfg.SMS2ProAPIKey == "" {
+			return nil, fmt.Errorf("sms2pro: SMS2PRO_API_KEY is not set")
+		}
+		return NewSMS2ProClient(cfg.SMS2ProAPIKey, cfg.SMS2ProBaseURL), nil
+	})
+}
+
+// SMS2ProClient implements ManagedOTPProvider; SMS2Pro generates and checks
+// the OTP codes.
 type SMS2ProClient struct {
-	APIKey string
-	Client *http.Client
+	APIKey  string
+	BaseURL string
+	Client  *http.Client
 }
 
 type SMS2ProOTPRequest struct {
@@ -41,13 +57,18 @@ type SMS2ProVerifyResponse struct {
 	Msg    string `json:"msg"`
 }
 
-func NewSMS2ProClient(apiKey string) *SMS2ProClient {
+func NewSMS2ProClient(apiKey, baseURL string) *SMS2ProClient {
 	return &SMS2ProClient{
-		APIKey: apiKey,
-		Client: &http.Client{},
+		APIKey:  apiKey,
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Client:  &http.Client{Timeout: 10 * time.Second},
 	}
 }
 
+func (s *SMS2ProClient) Name() string {
+	return "sms2pro"
+}
+
 func (s *SMS2ProClient) SendOTP(phone string) (string, error) {
 	payload := SMS2ProOTPRequest{
 		Recipient:  phone,
@@ -65,7 +86,7 @@ func (s *SMS2ProClient) SendOTP(phone string) (string, error) {
 
 	fmt.Printf("[SMS2PRO] SendOTP Request - Phone: %s, Payload: %s\n", phone, string(payloadBytes))
 
-	req, err := http.NewRequest("POST", "https://portal.sms2pro.com/sms-api/otp-sms/send", bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequest("POST", s.BaseURL+"/sms-api/otp-sms/send", bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		fmt.Printf("[SMS2PRO] Failed to create request: %v\n", err)
 		return "", err
@@ -120,7 +141,7 @@ func (s *SMS2ProClient) ValidateOTP(token string, otpCode string) error {
 
 	fmt.Printf("[SMS2PRO] ValidateOTP Request - Token: %s, OTP: %s, Payload: %s\n", token, otpCode, string(payloadBytes))
 
-	req, err := http.NewRequest("POST", "https://portal.sms2pro.com/sms-api/otp-sms/verify", bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequest("POST", s.BaseURL+"/sms-api/otp-sms/verify", bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		fmt.Printf("[SMS2PRO] Failed to create verify request: %v\n", err)
 		return err
@@ -159,3 +180,9 @@ func (s *SMS2ProClient) ValidateOTP(token string, otpCode string) error {
 	fmt.Printf("[SMS2PRO] ValidateOTP successful\n")
 	return nil
 }
+
+// ParseDeliveryReports reads the delivery report SMS2Pro posts to the
+// webhook.
+func (s *SMS2ProClient) ParseDeliveryReports(r *http.Request) ([]DeliveryReport, error) {
+	return parseDeliveryReports(r, []string{"message_id", "msg_id"}, []string{"status", "dlr_status"})
+}
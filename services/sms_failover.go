@@ -0,0 +1,166 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoSMSProvider is returned when no provider is configured for a role, or
+// every configured provider's circuit is open.
+var ErrNoSMSProvider = errors.New("no sms provider available")
+
+// circuitBreaker stops calling a provider after threshold consecutive
+// failures. Once cooldown has passed a single trial request is let through;
+// its result closes the circuit again or restarts the cooldown.
+type circuitBreaker struct {
+	mu          sync.Mutex
+	threshold   int
+	cooldown    time.Duration
+	failures    int
+	openedAt    time.Time
+	trialActive bool
+	lastError   string
+	lastSuccess time.Time
+	lastFailure time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a request may be sent now.
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if now.Sub(b.openedAt) < b.cooldown || b.trialActive {
+		return false
+	}
+	b.trialActive = true
+	return true
+}
+
+func (b *circuitBreaker) success(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.trialActive = false
+	b.lastSuccess = now
+}
+
+func (b *circuitBreaker) failure(now time.Time, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.trialActive = false
+	b.lastFailure = now
+	b.lastError = err.Error()
+	if b.failures >= b.threshold {
+		b.openedAt = now
+	}
+}
+
+func (b *circuitBreaker) state(now time.Time) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.failures < b.threshold:
+		return "closed"
+	case now.Sub(b.openedAt) >= b.cooldown:
+		return "half_open"
+	default:
+		return "open"
+	}
+}
+
+// SMSProviderHealth is the circuit state of one provider.
+type SMSProviderHealth struct {
+	Provider            string     `json:"provider"`
+	Role                string     `json:"role"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastSuccessAt       *time.Time `json:"last_success_at,omitempty"`
+	LastFailureAt       *time.Time `json:"last_failure_at,omitempty"`
+}
+
+func (b *circuitBreaker) health(provider, role string, now time.Time) SMSProviderHealth {
+	h := SMSProviderHealth{
+		Provider: provider,
+		Role:     role,
+		State:    b.state(now),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h.ConsecutiveFailures = b.failures
+	h.LastError = b.lastError
+	if !b.lastSuccess.IsZero() {
+		t := b.lastSuccess
+		h.LastSuccessAt = &t
+	}
+	if !b.lastFailure.IsZero() {
+		t := b.lastFailure
+		h.LastFailureAt = &t
+	}
+	return h
+}
+
+// SMSDelivery identifies which provider accepted a message. For managed OTP
+// MessageID is the provider token needed to validate the code.
+type SMSDelivery struct {
+	Provider  string
+	MessageID string
+}
+
+// smsRoute is one provider in a failover chain together with its breaker.
+type smsRoute struct {
+	provider SMSProvider
+	breaker  *circuitBreaker
+}
+
+// sendWithFailover tries routes in priority order, skipping those whose
+// circuit is open, and returns the first successful delivery. record, when
+// set, is told the outcome of every provider that was called.
+func sendWithFailover(routes []smsRoute, now func() time.Time, call func(SMSProvider) (string, error), record func(provider, messageID string, err error)) (*SMSDelivery, error) {
+	if len(routes) == 0 {
+		return nil, ErrNoSMSProvider
+	}
+
+	var errs []string
+	for _, r := range routes {
+		name := r.provider.Name()
+		if !r.breaker.allow(now()) {
+			errs = append(errs, name+": circuit open")
+			continue
+		}
+
+		id, err := call(r.provider)
+		if record != nil {
+			record(name, id, err)
+		}
+		if err != nil {
+			r.breaker.failure(now(), err)
+			fmt.Printf("[SMS] %s failed, trying next provider: %v\n", name, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+
+		r.breaker.success(now())
+		return &SMSDelivery{Provider: name, MessageID: id}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNoSMSProvider, strings.Join(errs, "; "))
+}
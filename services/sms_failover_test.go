@@ -0,0 +1,400 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sittawut/backend-appointment/config"
+	supa "github.com/supabase-community/supabase-go"
+)
+
+const testPhone = "0812345678"
+
+// fakeSMSProvider is an httptest stand-in for one provider API. It answers
+// in the provider's own format and fails while failing is set.
+type fakeSMSProvider struct {
+	*httptest.Server
+	mu      sync.Mutex
+	calls   int
+	failing bool
+}
+
+func (p *fakeSMSProvider) setFailing(failing bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failing = failing
+}
+
+func (p *fakeSMSProvider) callCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+// hit counts a call and reports whether it should fail.
+func (p *fakeSMSProvider) hit() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	return p.failing
+}
+
+func newFakeSMSProvider(t *testing.T, path string, respond func(w http.ResponseWriter, fail bool)) *fakeSMSProvider {
+	p := &fakeSMSProvider{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		respond(w, p.hit())
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+type fakeSMSProviders struct {
+	thsms, smsmkt, sms2pro *fakeSMSProvider
+}
+
+func newFakeSMSProviders(t *testing.T) *fakeSMSProviders {
+	return &fakeSMSProviders{
+		thsms: newFakeSMSProvider(t, "/api/send-sms", func(w http.ResponseWriter, fail bool) {
+			if fail {
+				io.WriteString(w, `{"success":false,"message":"gateway down"}`)
+				return
+			}
+			io.WriteString(w, `{"success":true,"data":{"message_id":"thsms-1"}}`)
+		}),
+		smsmkt: newFakeSMSProvider(t, "/otp-send", func(w http.ResponseWriter, fail bool) {
+			if fail {
+				w.WriteHeader(http.StatusBadGateway)
+				io.WriteString(w, `{"code":"500","detail":"gateway down"}`)
+				return
+			}
+			io.WriteString(w, `{"code":"000","detail":"OK","result":{"token":"smsmkt-token"}}`)
+		}),
+		sms2pro: newFakeSMSProvider(t, "/sms-api/otp-sms/send", func(w http.ResponseWriter, fail bool) {
+			if fail {
+				io.WriteString(w, `{"code":500,"status":"error","msg":"gateway down"}`)
+				return
+			}
+			io.WriteString(w, `{"code":0,"status":"success","token":"sms2pro-token"}`)
+		}),
+	}
+}
+
+// config points every provider at its stand-in. thsms sends text, and
+// managed OTP fails over from smsmkt to sms2pro.
+func (f *fakeSMSProviders) config() *config.Config {
+	return &config.Config{
+		THSMSToken:          "thsms-token",
+		THSMSBaseURL:        f.thsms.URL,
+		THSMSSender:         "CLINIC",
+		SMSMKTKey:           "key",
+		SMSMKTSecretKey:     "secret",
+		SMSMKTProjectKey:    "project",
+		SMSMKTURL:           f.smsmkt.URL + "/otp-send",
+		SMSMKTValidateURL:   f.smsmkt.URL + "/otp-validate",
+		SMS2ProAPIKey:       "sms2pro-key",
+		SMS2ProBaseURL:      f.sms2pro.URL,
+		SMSTextProviders:    []string{"thsms"},
+		SMSOTPProviders:     []string{"smsmkt", "sms2pro"},
+		SMSBreakerThreshold: 2,
+		SMSBreakerCooldown:  time.Minute,
+	}
+}
+
+// newTestSMSService builds the service with a clock the test moves.
+func newTestSMSService(t *testing.T, cfg *config.Config) (*SMSService, *time.Time) {
+	t.Helper()
+	sms, err := NewSMSService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC)
+	sms.now = func() time.Time { return now }
+	return sms, &now
+}
+
+func providerState(sms *SMSService, role, provider string) string {
+	for _, h := range sms.Health() {
+		if h.Role == role && h.Provider == provider {
+			return h.State
+		}
+	}
+	return ""
+}
+
+func TestSendWithFailoverPriorityOrder(t *testing.T) {
+	tests := []struct {
+		name         string
+		smsmktFails  bool
+		sms2proFails bool
+		wantProvider string
+		wantToken    string
+		wantSMS2Pro  int
+	}{
+		{name: "first provider", wantProvider: "smsmkt", wantToken: "smsmkt-token"},
+		{name: "falls back to the next", smsmktFails: true, wantProvider: "sms2pro", wantToken: "sms2pro-token", wantSMS2Pro: 1},
+		{name: "all fail", smsmktFails: true, sms2proFails: true, wantSMS2Pro: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakes := newFakeSMSProviders(t)
+			fakes.smsmkt.setFailing(tt.smsmktFails)
+			fakes.sms2pro.setFailing(tt.sms2proFails)
+			sms, _ := newTestSMSService(t, fakes.config())
+
+			var recorded []string
+			delivery, err := sendWithFailover(sms.otp, sms.now, func(p SMSProvider) (string, error) {
+				return p.(ManagedOTPProvider).SendOTP(testPhone)
+			}, func(provider, messageID string, err error) {
+				recorded = append(recorded, provider)
+			})
+
+			if tt.wantProvider == "" {
+				if !errors.Is(err, ErrNoSMSProvider) {
+					t.Fatalf("err = %v, want ErrNoSMSProvider", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if delivery.Provider != tt.wantProvider || delivery.MessageID != tt.wantToken {
+					t.Errorf("delivery = %+v, want %s with %s", delivery, tt.wantProvider, tt.wantToken)
+				}
+			}
+			if fakes.smsmkt.callCount() != 1 || fakes.sms2pro.callCount() != tt.wantSMS2Pro {
+				t.Errorf("calls smsmkt=%d sms2pro=%d, want 1 and %d", fakes.smsmkt.callCount(), fakes.sms2pro.callCount(), tt.wantSMS2Pro)
+			}
+			if len(recorded) != 1+tt.wantSMS2Pro {
+				t.Errorf("recorded %v, want one entry per provider called", recorded)
+			}
+		})
+	}
+}
+
+func TestSendWithFailoverSkipsOpenCircuit(t *testing.T) {
+	fakes := newFakeSMSProviders(t)
+	fakes.smsmkt.setFailing(true)
+	sms, _ := newTestSMSService(t, fakes.config())
+
+	// Two failures reach the threshold and open the circuit.
+	for i := 0; i < 2; i++ {
+		if _, err := sms.SendOTP(testPhone); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if state := providerState(sms, "otp", "smsmkt"); state != "open" {
+		t.Fatalf("smsmkt state = %q, want open", state)
+	}
+
+	delivery, err := sms.SendOTP(testPhone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Provider != "sms2pro" {
+		t.Errorf("provider = %s, want sms2pro", delivery.Provider)
+	}
+	if calls := fakes.smsmkt.callCount(); calls != 2 {
+		t.Errorf("smsmkt called %d times, want 2: an open circuit must not be called", calls)
+	}
+
+	fakes.sms2pro.setFailing(true)
+	for i := 0; i < 2; i++ {
+		sms.SendOTP(testPhone)
+	}
+	calls := fakes.sms2pro.callCount()
+	if _, err := sms.SendOTP(testPhone); !errors.Is(err, ErrNoSMSProvider) {
+		t.Errorf("err = %v, want ErrNoSMSProvider with every circuit open", err)
+	}
+	if fakes.sms2pro.callCount() != calls {
+		t.Error("sms2pro was called with its circuit open")
+	}
+}
+
+func TestSendWithFailoverHalfOpenRecovery(t *testing.T) {
+	fakes := newFakeSMSProviders(t)
+	fakes.smsmkt.setFailing(true)
+	sms, now := newTestSMSService(t, fakes.config())
+
+	for i := 0; i < 2; i++ {
+		sms.SendOTP(testPhone)
+	}
+
+	// After the cooldown a single trial is let through; it fails and the
+	// circuit opens again for another cooldown.
+	*now = now.Add(time.Minute)
+	if state := providerState(sms, "otp", "smsmkt"); state != "half_open" {
+		t.Fatalf("smsmkt state = %q, want half_open", state)
+	}
+	if delivery, _ := sms.SendOTP(testPhone); delivery.Provider != "sms2pro" {
+		t.Errorf("provider = %s, want sms2pro after a failed trial", delivery.Provider)
+	}
+	if calls := fakes.smsmkt.callCount(); calls != 3 {
+		t.Fatalf("smsmkt called %d times, want 3 with one trial", calls)
+	}
+	if state := providerState(sms, "otp", "smsmkt"); state != "open" {
+		t.Fatalf("smsmkt state = %q after a failed trial, want open", state)
+	}
+	sms.SendOTP(testPhone)
+	if calls := fakes.smsmkt.callCount(); calls != 3 {
+		t.Fatalf("smsmkt called %d times during the new cooldown, want 3", calls)
+	}
+
+	// A successful trial closes the circuit.
+	fakes.smsmkt.setFailing(false)
+	*now = now.Add(time.Minute)
+	delivery, err := sms.SendOTP(testPhone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Provider != "smsmkt" {
+		t.Errorf("provider = %s, want smsmkt after a successful trial", delivery.Provider)
+	}
+	if state := providerState(sms, "otp", "smsmkt"); state != "closed" {
+		t.Errorf("smsmkt state = %q, want closed", state)
+	}
+}
+
+func TestCircuitBreakerAllowsOneTrial(t *testing.T) {
+	b := newCircuitBreaker(1, time.Minute)
+	now := time.Now()
+	b.failure(now, errors.New("down"))
+
+	if b.allow(now.Add(30 * time.Second)) {
+		t.Error("allowed during the cooldown")
+	}
+	later := now.Add(time.Minute)
+	if !b.allow(later) {
+		t.Fatal("trial refused after the cooldown")
+	}
+	if b.allow(later) {
+		t.Error("a second request was let through while the trial runs")
+	}
+	b.success(later)
+	if !b.allow(later) || b.state(later) != "closed" {
+		t.Error("circuit not closed by a successful trial")
+	}
+}
+
+// fakePostgREST answers the queries OTPService.Request makes and keeps the
+// rows it inserts.
+type fakePostgREST struct {
+	mu       sync.Mutex
+	otpCodes []map[string]interface{}
+	audit    []map[string]interface{}
+}
+
+func newFakePostgREST(t *testing.T) (*fakePostgREST, *supa.Client) {
+	t.Helper()
+	db := &fakePostgREST{}
+	server := httptest.NewServer(http.HandlerFunc(db.serve))
+	t.Cleanup(server.Close)
+
+	client, err := supa.NewClient(server.URL, "service-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, client
+}
+
+func (db *fakePostgREST) serve(w http.ResponseWriter, r *http.Request) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	var row map[string]interface{}
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&row); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"code":"PGRST102","message":"invalid body"}`)
+			return
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/rest/v1/users":
+		io.WriteString(w, `[{"id":"user-1","phone":"`+testPhone+`","full_name":"Test","role":"customer","is_active":true}]`)
+	case r.Method == http.MethodPatch && r.URL.Path == "/rest/v1/otp_codes":
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && r.URL.Path == "/rest/v1/otp_codes":
+		row["id"] = "otp-1"
+		db.otpCodes = append(db.otpCodes, row)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode([]map[string]interface{}{row})
+	case r.Method == http.MethodPost && r.URL.Path == "/rest/v1/otp_audit_log":
+		db.audit = append(db.audit, row)
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `[]`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"code":"PGRST205","message":"unexpected request `+r.Method+` `+r.URL.Path+`"}`)
+	}
+}
+
+func TestOTPRequestRecordsProvider(t *testing.T) {
+	tests := []struct {
+		name         string
+		failing      []string
+		wantMethod   string
+		wantProvider string
+		wantStatus   string
+		wantReason   string
+	}{
+		{name: "text provider", wantMethod: OTPMethodText, wantProvider: "thsms", wantStatus: "success", wantReason: OTPMethodText},
+		{name: "managed fallback", failing: []string{"thsms"}, wantMethod: OTPMethodManaged, wantProvider: "smsmkt", wantStatus: "success", wantReason: OTPMethodManaged},
+		{name: "managed failover", failing: []string{"thsms", "smsmkt"}, wantMethod: OTPMethodManaged, wantProvider: "sms2pro", wantStatus: "success", wantReason: OTPMethodManaged},
+		{name: "all providers fail", failing: []string{"thsms", "smsmkt", "sms2pro"}, wantStatus: "failed", wantReason: "sms_send_failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakes := newFakeSMSProviders(t)
+			byName := map[string]*fakeSMSProvider{"thsms": fakes.thsms, "smsmkt": fakes.smsmkt, "sms2pro": fakes.sms2pro}
+			for _, name := range tt.failing {
+				byName[name].setFailing(true)
+			}
+			sms, _ := newTestSMSService(t, fakes.config())
+			db, client := newFakePostgREST(t)
+			otp := NewOTPService(client, sms, OTPOptions{})
+
+			challenge, err := otp.Request(testPhone, "203.0.113.7")
+			if tt.wantMethod == "" {
+				if !errors.Is(err, ErrNoSMSProvider) {
+					t.Fatalf("err = %v, want ErrNoSMSProvider", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if challenge.Method != tt.wantMethod || challenge.Provider != tt.wantProvider {
+					t.Errorf("challenge = %+v, want %s via %s", challenge, tt.wantMethod, tt.wantProvider)
+				}
+				if len(db.otpCodes) != 1 || db.otpCodes[0]["provider"] != tt.wantProvider {
+					t.Errorf("otp_codes rows = %v, want one from %s", db.otpCodes, tt.wantProvider)
+				}
+			}
+
+			if len(db.audit) != 1 {
+				t.Fatalf("otp_audit_log rows = %v, want one", db.audit)
+			}
+			entry := db.audit[0]
+			if entry["action"] != "request_otp" || entry["status"] != tt.wantStatus || entry["reason"] != tt.wantReason {
+				t.Errorf("audit = %v, want request_otp %s %s", entry, tt.wantStatus, tt.wantReason)
+			}
+			provider, _ := entry["provider"].(string)
+			if provider != tt.wantProvider {
+				t.Errorf("audit provider = %q, want %q", provider, tt.wantProvider)
+			}
+		})
+	}
+}
@@ -1,7 +1,29 @@
 package services
 
-// SMSClient is an interface for SMS service providers
-type SMSClient interface {
+import "fmt"
+
+// SMSProvider is the common part of every SMS provider.
+type SMSProvider interface {
+	// Name is the identifier used to select the provider in config.
+	Name() string
+}
+
+// ManagedOTPProvider generates, sends and validates OTP codes itself
+// (SMSMKT, SMS2Pro). SendOTP returns the provider token to validate against.
+type ManagedOTPProvider interface {
+	SMSProvider
 	SendOTP(phone string) (string, error)
 	ValidateOTP(token string, otpCode string) error
 }
+
+// TextSender delivers an arbitrary message (THSMS). OTP codes sent this way
+// are generated and checked by the caller.
+type TextSender interface {
+	SMSProvider
+	SendText(phone, message string) (string, error)
+}
+
+// OTPMessage is the SMS text used when the caller generates the OTP code.
+func OTPMessage(otp string) string {
+	return fmt.Sprintf("รหัส OTP ของคุณคือ: %s (หมดอายุใน 1 นาที) ห้ามแชร์กับใคร", otp)
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sittawut/backend-appointment/config"
+	"github.com/sittawut/backend-appointment/models"
+)
+
+// SMSProviderFactory builds a provider from the application config. It should
+// return an error when the provider's credentials are missing.
+type SMSProviderFactory func(cfg *config.Config) (SMSProvider, error)
+
+var smsProviders = map[string]SMSProviderFactory{}
+
+// RegisterSMSProvider makes a provider selectable by name in config. Providers
+// register themselves from an init function in their own file.
+func RegisterSMSProvider(name string, factory SMSProviderFactory) {
+	name = strings.ToLower(name)
+	if _, exists := smsProviders[name]; exists {
+		panic(fmt.Sprintf("sms provider %q registered twice", name))
+	}
+	smsProviders[name] = factory
+}
+
+// NewSMSProvider builds the provider registered under name.
+func NewSMSProvider(name string, cfg *config.Config) (SMSProvider, error) {
+	factory, ok := smsProviders[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown sms provider %q (available: %s)", name, strings.Join(SMSProviderNames(), ", "))
+	}
+	return factory(cfg)
+}
+
+// SMSProviderNames lists the registered provider names.
+func SMSProviderNames() []string {
+	names := make([]string, 0, len(smsProviders))
+	for name := range smsProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SMSService sends SMS through the providers selected in config. Each role
+// has a failover chain in priority order: the text chain sends messages the
+// caller composes, including self-generated OTP codes, and the OTP chain runs
+// provider-managed OTP. A provider that keeps failing is skipped by its
+// circuit breaker until the cooldown has passed.
+type SMSService struct {
+	text     []smsRoute
+	otp      []smsRoute
+	now      func() time.Time
+	recorder SMSRecorder
+}
+
+// NewSMSService builds the chains named by cfg.SMSTextProviders and
+// cfg.SMSOTPProviders, checking that each provider supports its role. A
+// provider used in both roles shares one circuit breaker.
+func NewSMSService(cfg *config.Config) (*SMSService, error) {
+	s := &SMSService{now: time.Now}
+	providers := map[string]smsRoute{}
+
+	route := func(name string) (smsRoute, error) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if r, ok := providers[name]; ok {
+			return r, nil
+		}
+		provider, err := NewSMSProvider(name, cfg)
+		if err != nil {
+			return smsRoute{}, err
+		}
+		r := smsRoute{
+			provider: provider,
+			breaker:  newCircuitBreaker(cfg.SMSBreakerThreshold, cfg.SMSBreakerCooldown),
+		}
+		providers[name] = r
+		return r, nil
+	}
+
+	for _, name := range cfg.SMSTextProviders {
+		r, err := route(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := r.provider.(TextSender); !ok {
+			return nil, fmt.Errorf("sms provider %q cannot send text messages", r.provider.Name())
+		}
+		s.text = append(s.text, r)
+	}
+
+	for _, name := range cfg.SMSOTPProviders {
+		r, err := route(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := r.provider.(ManagedOTPProvider); !ok {
+			return nil, fmt.Errorf("sms provider %q does not manage OTP codes", r.provider.Name())
+		}
+		s.otp = append(s.otp, r)
+	}
+
+	return s, nil
+}
+
+// HasTextProvider reports whether any text provider is configured.
+func (s *SMSService) HasTextProvider() bool {
+	return len(s.text) > 0
+}
+
+// HasOTPProvider reports whether any managed OTP provider is configured.
+func (s *SMSService) HasOTPProvider() bool {
+	return len(s.otp) > 0
+}
+
+// SetRecorder stores every send attempt through recorder.
+func (s *SMSService) SetRecorder(recorder SMSRecorder) {
+	s.recorder = recorder
+}
+
+// SendText delivers message through the first available text provider.
+// purpose is recorded with the attempt, e.g. SMSPurposeOTP.
+func (s *SMSService) SendText(purpose, phone, message string) (*SMSDelivery, error) {
+	return sendWithFailover(s.text, s.now, func(p SMSProvider) (string, error) {
+		return p.(TextSender).SendText(phone, message)
+	}, s.record(purpose, phone))
+}
+
+// SendOTP starts a provider-managed OTP. The returned delivery's MessageID is
+// the token to pass to ValidateOTP together with its Provider.
+func (s *SMSService) SendOTP(phone string) (*SMSDelivery, error) {
+	return sendWithFailover(s.otp, s.now, func(p SMSProvider) (string, error) {
+		return p.(ManagedOTPProvider).SendOTP(phone)
+	}, s.record(SMSPurposeOTP, phone))
+}
+
+func (s *SMSService) record(purpose, phone string) func(provider, messageID string, err error) {
+	if s.recorder == nil {
+		return nil
+	}
+	return func(provider, messageID string, err error) {
+		rec := SMSSendRecord{
+			Provider:  provider,
+			MessageID: messageID,
+			Phone:     phone,
+			Purpose:   purpose,
+			Status:    models.SMSStatusSent,
+		}
+		if err != nil {
+			rec.Status = models.SMSStatusSendFailed
+			rec.Error = err.Error()
+		}
+		s.recorder.RecordSMS(rec)
+	}
+}
+
+// ParseDeliveryReports reads a delivery report webhook for provider. Only
+// configured providers are accepted.
+func (s *SMSService) ParseDeliveryReports(provider string, r *http.Request) ([]DeliveryReport, error) {
+	for _, route := range append(append([]smsRoute{}, s.text...), s.otp...) {
+		if route.provider.Name() != provider {
+			continue
+		}
+		parser, ok := route.provider.(DeliveryReportParser)
+		if !ok {
+			return nil, ErrNoDeliveryReports
+		}
+		return parser.ParseDeliveryReports(r)
+	}
+	return nil, fmt.Errorf("sms provider %q is not configured", provider)
+}
+
+// ValidateOTP checks a code with the provider that issued the token. An empty
+// provider means the first configured one, for tokens issued before the
+// provider was recorded. Rejected codes do not count against the breaker.
+func (s *SMSService) ValidateOTP(provider, token, otpCode string) error {
+	for _, r := range s.otp {
+		if provider == "" || r.provider.Name() == provider {
+			return r.provider.(ManagedOTPProvider).ValidateOTP(token, otpCode)
+		}
+	}
+	if provider == "" {
+		return ErrNoSMSProvider
+	}
+	return fmt.Errorf("sms provider %q is no longer configured", provider)
+}
+
+// Health reports the circuit state of every configured provider.
+func (s *SMSService) Health() []SMSProviderHealth {
+	now := s.now()
+	health := make([]SMSProviderHealth, 0, len(s.text)+len(s.otp))
+	for _, r := range s.text {
+		health = append(health, r.breaker.health(r.provider.Name(), "text", now))
+	}
+	for _, r := range s.otp {
+		health = append(health, r.breaker.health(r.provider.Name(), "otp", now))
+	}
+	return health
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sittawut/backend-appointment/models"
+	supa "github.com/supabase-community/supabase-go"
+)
+
+// SMS purposes recorded in sms_messages.
+const (
+	SMSPurposeOTP          = "otp"
+	SMSPurposeNotification = "notification"
+)
+
+// SMSSendRecord describes one send attempt through one provider.
+type SMSSendRecord struct {
+	Provider  string
+	MessageID string
+	Phone     string
+	Purpose   string
+	Status    string
+	Error     string
+}
+
+// SMSRecorder persists send attempts.
+type SMSRecorder interface {
+	RecordSMS(rec SMSSendRecord)
+}
+
+// DeliveryReport is a provider's final word on a message.
+type DeliveryReport struct {
+	MessageID string
+	Status    string
+	Raw       map[string]interface{}
+}
+
+// DeliveryReportParser is implemented by providers that push delivery
+// reports to our webhook.
+type DeliveryReportParser interface {
+	SMSProvider
+	ParseDeliveryReports(r *http.Request) ([]DeliveryReport, error)
+}
+
+// ErrNoDeliveryReports is returned for webhooks of providers that do not
+// send delivery reports.
+var ErrNoDeliveryReports = errors.New("sms provider does not send delivery reports")
+
+// SMSTracker stores send attempts in sms_messages and applies delivery
+// reports to them.
+type SMSTracker struct {
+	supabase *supa.Client
+}
+
+func NewSMSTracker(supabase *supa.Client) *SMSTracker {
+	return &SMSTracker{supabase: supabase}
+}
+
+func (t *SMSTracker) RecordSMS(rec SMSSendRecord) {
+	row := map[string]interface{}{
+		"provider": rec.Provider,
+		"phone":    rec.Phone,
+		"purpose":  rec.Purpose,
+		"status":   rec.Status,
+	}
+	if rec.MessageID != "" {
+		row["message_id"] = rec.MessageID
+	}
+	if rec.Error != "" {
+		row["error"] = rec.Error
+	}
+
+	if _, _, err := t.supabase.From("sms_messages").
+		Insert(row, false, "", "minimal", "").
+		Execute(); err != nil {
+		fmt.Printf("[SMS] Failed to record %s message to %s: %v\n", rec.Provider, rec.Phone, err)
+	}
+}
+
+// ApplyDeliveryReports updates the matching messages and returns how many
+// were found.
+func (t *SMSTracker) ApplyDeliveryReports(provider string, reports []DeliveryReport) (int, error) {
+	matched := 0
+	for _, report := range reports {
+		var rows []models.SMSMessage
+		data, _, err := t.supabase.From("sms_messages").
+			Update(map[string]interface{}{
+				"status":            report.Status,
+				"report":            report.Raw,
+				"status_updated_at": time.Now(),
+			}, "", "").
+			Eq("provider", provider).
+			Eq("message_id", report.MessageID).
+			Execute()
+		if err == nil {
+			err = json.Unmarshal(data, &rows)
+		}
+		if err != nil {
+			return matched, err
+		}
+		matched += len(rows)
+	}
+	return matched, nil
+}
+
+// parseDeliveryReports reads a JSON object, a JSON array or a form post and
+// picks the message ID and status from the first key present. Only the body
+// is read, never the query string, which carries the webhook secret. Reports
+// with an unknown status are skipped.
+func parseDeliveryReports(r *http.Request, idKeys, statusKeys []string) ([]DeliveryReport, error) {
+	var items []map[string]interface{}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			return nil, err
+		}
+		trimmed := strings.TrimSpace(string(body))
+		if strings.HasPrefix(trimmed, "[") {
+			err = json.Unmarshal(body, &items)
+		} else {
+			var item map[string]interface{}
+			err = json.Unmarshal(body, &item)
+			items = append(items, item)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid delivery report: %w", err)
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return nil, fmt.Errorf("invalid delivery report: %w", err)
+		}
+		item := map[string]interface{}{}
+		for key := range r.PostForm {
+			item[key] = r.PostForm.Get(key)
+		}
+		items = append(items, item)
+	}
+
+	var reports []DeliveryReport
+	for _, item := range items {
+		id := firstValue(item, idKeys)
+		status := normaliseDeliveryStatus(firstValue(item, statusKeys))
+		if id == "" || status == "" {
+			continue
+		}
+		reports = append(reports, DeliveryReport{MessageID: id, Status: status, Raw: item})
+	}
+	return reports, nil
+}
+
+func firstValue(item map[string]interface{}, keys []string) string {
+	for _, key := range keys {
+		if v, ok := item[key]; ok && v != nil {
+			return strings.TrimSpace(fmt.Sprintf("%v", v))
+		}
+	}
+	return ""
+}
+
+// normaliseDeliveryStatus maps provider and SMPP status words to ours.
+func normaliseDeliveryStatus(status string) string {
+	switch strings.ToUpper(status) {
+	case "DELIVERED", "DELIVRD", "SUCCESS", "SENT_SUCCESS":
+		return models.SMSStatusDelivered
+	case "FAILED", "FAIL", "UNDELIV", "UNDELIVERED", "REJECTD", "REJECTED", "ERROR":
+		return models.SMSStatusFailed
+	case "EXPIRED", "EXPIRE":
+		return models.SMSStatusExpired
+	default:
+		return ""
+	}
+}
@@ -6,13 +6,38 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
+
+	"github.com/sittawut/backend-appointment/config"
 )
 
+func init() {
+	RegisterSMSProvider("smsmkt", func(cfg *config.Config) (SMSProvider, error) {
+		if cfg.SMSMKTKey == "" || cfg.SMSMKTSecretKey == "" || cfg.SMSMKTProjectKey == "" {
+			return nil, fmt.Errorf("smsmkt: SMSMKT_API_KEY, SMSMKT_SECRET_KEY and SMSMKT_PROJECT_KEY are required")
+		}
+		return &SMSMKTClient{
+			APIKey:      cfg.SMSMKTKey,
+			SecretKey:   cfg.SMSMKTSecretKey,
+			ProjectKey:  cfg.SMSMKTProjectKey,
+			URL:         cfg.SMSMKTURL,
+			ValidateURL: cfg.SMSMKTValidateURL,
+			HTTPClient:  &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	})
+}
+
+// SMSMKTClient implements ManagedOTPProvider; SMSMKT generates and checks
+// the OTP codes.
 type SMSMKTClient struct {
 	APIKey     string
 	SecretKey  string
 	ProjectKey string
 	URL        string
+	// ValidateURL is the OTP validation endpoint; HTTPClient defaults to
+	// http.DefaultClient when nil.
+	ValidateURL string
+	HTTPClient  *http.Client
 }
 
 type SMSMKTResponse struct {
@@ -25,6 +50,17 @@ type SMSMKTResponse struct {
 	} `json:"result"`
 }
 
+func (s *SMSMKTClient) Name() string {
+	return "smsmkt"
+}
+
+func (s *SMSMKTClient) httpClient() *http.Client {
+	if s.HTTPClient != nil {
+		return s.HTTPClient
+	}
+	return http.DefaultClient
+}
+
 func (s *SMSMKTClient) SendOTP(phone string) (string, error) {
 	fmt.Printf("[SMSMKT] SendOTP - Phone: %s\n", phone)
 	fmt.Printf("[SMSMKT] Config - URL: %s, APIKey: %s, ProjectKey: %s\n", s.URL, s.APIKey, s.ProjectKey)
@@ -48,8 +84,7 @@ func (s *SMSMKTClient) SendOTP(phone string) (string, error) {
 	req.Header.Set("api_key", s.APIKey)
 	req.Header.Set("secret_key", s.SecretKey)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := s.httpClient().Do(req)
 	if err != nil {
 		fmt.Printf("[SMSMKT] HTTP request error: %v\n", err)
 		return "", err
@@ -99,8 +134,7 @@ func (s *SMSMKTClient) ValidateOTP(token, otpCode string) error {
 	fmt.Printf("[SMSMKT] ValidateOTP Request - Token: %s, OTP: %s\n", token, otpCode)
 	fmt.Printf("[SMSMKT] Request Payload: %s\n", string(body))
 
-	validateURL := "https://portal-otp.smsmkt.com/api/otp-validate"
-	req, err := http.NewRequest("POST", validateURL, bytes.NewBuffer(body))
+	req, err := http.NewRequest("POST", s.ValidateURL, bytes.NewBuffer(body))
 	if err != nil {
 		fmt.Printf("[SMSMKT] Request creation error: %v\n", err)
 		return err
@@ -110,8 +144,7 @@ func (s *SMSMKTClient) ValidateOTP(token, otpCode string) error {
 	req.Header.Set("api_key", s.APIKey)
 	req.Header.Set("secret_key", s.SecretKey)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := s.httpClient().Do(req)
 	if err != nil {
 		fmt.Printf("[SMSMKT] HTTP request error: %v\n", err)
 		return err
@@ -9,9 +9,21 @@ import (
 	"math/big"
 	"net/http"
 	"time"
+
+	"github.com/sittawut/backend-appointment/config"
 )
 
-// THSMSClientImpl implements SMSClient interface for THSMS API
+func init() {
+	RegisterSMSProvider("thsms", func(cfg *config.Config) (SMSProvider, error) {
+		if cfg.THSMSToken == "" {
+			return nil, fmt.Errorf("thsms: THSMS_API_TOKEN is not set")
+		}
+		return NewTHSMSClientImpl(cfg.THSMSToken, cfg.THSMSBaseURL, cfg.THSMSSender), nil
+	})
+}
+
+// THSMSClientImpl implements TextSender for the THSMS API. OTP codes are
+// generated by the caller and sent as plain text.
 type THSMSClientImpl struct {
 	APIToken   string
 	BaseURL    string
@@ -48,32 +60,20 @@ func NewTHSMSClientImpl(apiToken, baseURL, sender string) *THSMSClientImpl {
 	}
 }
 
-// SendOTP sends OTP via THSMS
-// Note: OTP should be generated by caller, not by SMS provider
-// Returns: message_id (for logging), error
-func (c *THSMSClientImpl) SendOTP(phone string) (string, error) {
-	// This method signature doesn't match the interface
-	// We need to use a different approach
-	return "", fmt.Errorf("SendOTP requires OTP parameter - use SendOTPWithCode instead")
+func (c *THSMSClientImpl) Name() string {
+	return "thsms"
 }
 
-// SendOTPWithCode sends OTP via THSMS with pre-generated OTP code
+// SendText sends an arbitrary SMS message via THSMS
 // Returns: message_id (for logging), error
-func (c *THSMSClientImpl) SendOTPWithCode(phone, otp string) (string, error) {
-	// Validate input
-	if phone == "" || otp == "" {
-		return "", fmt.Errorf("phone and otp cannot be empty")
+func (c *THSMSClientImpl) SendText(phone, message string) (string, error) {
+	if phone == "" || message == "" {
+		return "", fmt.Errorf("phone and message cannot be empty")
 	}
-
-	// Use phone number as-is (THSMS accepts Thai format like 0631784331)
-	// Don't convert to international format
 	if len(phone) != 10 || phone[0] != '0' {
 		return "", fmt.Errorf("invalid phone number format: %s (must be 10 digits starting with 0)", phone)
 	}
 
-	// Build SMS message
-	message := fmt.Sprintf("รหัส OTP ของคุณคือ: %s (หมดอายุใน 1 นาที) ห้ามแชร์กับใคร", otp)
-
 	// Create request
 	payload := THSMSRequestPayload{
 		Sender:  c.Sender,
@@ -86,8 +86,6 @@ func (c *THSMSClientImpl) SendOTPWithCode(phone, otp string) (string, error) {
 		return "", fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	fmt.Printf("[THSMS] Sending OTP - Phone: %s, OTP: %s\n", phone, otp)
-
 	// Create HTTP request
 	req, err := http.NewRequest("POST", c.BaseURL+"/api/send-sms", bytes.NewBuffer(body))
 	if err != nil {
@@ -146,12 +144,6 @@ func (c *THSMSClientImpl) SendOTPWithCode(phone, otp string) (string, error) {
 	return messageID, nil
 }
 
-// ValidateOTP is a placeholder - THSMS doesn't provide validation
-// OTP validation is handled by backend hash comparison
-func (c *THSMSClientImpl) ValidateOTP(token, otpCode string) error {
-	return fmt.Errorf("ValidateOTP not implemented for THSMS - use backend hash validation")
-}
-
 // generateRandomOTPCode generates a random 6-digit OTP
 func generateRandomOTPCode() string {
 	otp := ""
@@ -185,3 +177,8 @@ func formatThaiPhoneNumber(phone string) string {
 
 	return ""
 }
+
+// ParseDeliveryReports reads the delivery report THSMS posts to the webhook.
+func (c *THSMSClientImpl) ParseDeliveryReports(r *http.Request) ([]DeliveryReport, error) {
+	return parseDeliveryReports(r, []string{"message_id", "messageId", "msgid"}, []string{"status", "dlr_status", "stat"})
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokenKey is one key in the token key set. Its ID is the RFC 7638 JWK
+// thumbprint, so the same key file always gets the same kid.
+type TokenKey struct {
+	ID        string
+	Algorithm string
+	public    crypto.PublicKey
+	private   crypto.Signer
+}
+
+// JWK is the public form of a TokenKey.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKSet is served at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// TokenKeys signs access tokens with one private key and verifies them
+// against every loaded public key, picked by the kid header. Only RS256 and
+// ES256 are accepted. Without a signing key it falls back to HS256 with the
+// shared secret, which other services cannot verify without that secret.
+// Tokens carry the configured issuer and audience, which Parse callers check.
+type TokenKeys struct {
+	signing    *TokenKey
+	verify     map[string]*TokenKey
+	hmacSecret []byte
+	issuer     string
+	audience   string
+}
+
+// LoadTokenKeys reads the signing key (a PEM RSA or P-256 private key) and
+// any extra verification keys, e.g. the previous signing key during
+// rotation. verifyKeyFiles entries may be glob patterns. With no signing key
+// file, hmacSecret is used for HS256.
+func LoadTokenKeys(signingKeyFile string, verifyKeyFiles []string, hmacSecret, issuer, audience string) (*TokenKeys, error) {
+	keys := &TokenKeys{verify: map[string]*TokenKey{}, issuer: issuer, audience: audience}
+
+	if signingKeyFile == "" {
+		if hmacSecret == "" {
+			return nil, errors.New("JWT_SIGNING_KEY_FILE or JWT_SECRET must be set")
+		}
+		keys.hmacSecret = []byte(hmacSecret)
+		return keys, nil
+	}
+
+	signing, err := loadTokenKey(signingKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if signing.private == nil {
+		return nil, fmt.Errorf("%s: signing key must be a private key", signingKeyFile)
+	}
+	keys.signing = signing
+	keys.verify[signing.ID] = signing
+
+	for _, pattern := range verifyKeyFiles {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key file pattern %q: %w", pattern, err)
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no key files match %q", pattern)
+		}
+		for _, file := range files {
+			key, err := loadTokenKey(file)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := keys.verify[key.ID]; !ok {
+				keys.verify[key.ID] = key
+			}
+		}
+	}
+	return keys, nil
+}
+
+// Issuer is the iss claim of the access tokens this service mints.
+func (k *TokenKeys) Issuer() string {
+	return k.issuer
+}
+
+// Audience is the aud claim of the access tokens this service mints.
+func (k *TokenKeys) Audience() string {
+	return k.audience
+}
+
+// Asymmetric reports whether tokens are signed with a private key.
+func (k *TokenKeys) Asymmetric() bool {
+	return k.signing != nil
+}
+
+// Sign signs claims with the current signing key.
+func (k *TokenKeys) Sign(claims jwt.Claims) (string, error) {
+	if k.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.hmacSecret)
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(k.signing.Algorithm), claims)
+	token.Header["kid"] = k.signing.ID
+	return token.SignedString(k.signing.private)
+}
+
+// Parse verifies tokenString into claims. The algorithm is pinned to the
+// one of the key named by kid, so a token cannot pick HS256 or "none".
+func (k *TokenKeys) Parse(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	if k.signing == nil {
+		opts = append(opts, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+		return jwt.ParseWithClaims(tokenString, claims, func(*jwt.Token) (interface{}, error) {
+			return k.hmacSecret, nil
+		}, opts...)
+	}
+
+	opts = append(opts, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}))
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := k.verify[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("key %q does not sign %s", kid, token.Method.Alg())
+		}
+		return key.public, nil
+	}, opts...)
+}
+
+// JWKS returns the public verification keys, sorted by kid. It is empty in
+// HS256 mode.
+func (k *TokenKeys) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range k.verify {
+		set.Keys = append(set.Keys, key.jwk())
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
+
+func loadTokenKey(file string) (*TokenKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", file)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM type %q", file, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	key := &TokenKey{}
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.private = signer
+		parsed = signer.Public()
+	}
+
+	switch pub := parsed.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("%s: RSA keys must be at least 2048 bits", file)
+		}
+		key.Algorithm = jwt.SigningMethodRS256.Alg()
+		key.public = pub
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%s: only P-256 EC keys are supported", file)
+		}
+		key.Algorithm = jwt.SigningMethodES256.Alg()
+		key.public = pub
+	default:
+		return nil, fmt.Errorf("%s: unsupported key type %T", file, parsed)
+	}
+
+	key.ID = key.thumbprint()
+	return key, nil
+}
+
+func (k *TokenKey) jwk() JWK {
+	jwk := JWK{KeyID: k.ID, Use: "sig", Algorithm: k.Algorithm}
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		jwk.KeyType = "EC"
+		jwk.Curve = "P-256"
+		jwk.X = b64(pub.X.FillBytes(make([]byte, 32)))
+		jwk.Y = b64(pub.Y.FillBytes(make([]byte, 32)))
+	}
+	return jwk
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint: the SHA-256 of the
+// required members in lexicographic order.
+func (k *TokenKey) thumbprint() string {
+	jwk := k.jwk()
+	var members interface{}
+	if jwk.KeyType == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y}
+	}
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return b64(sum[:])
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}